@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/frodejac/globster/internal/config"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const configUsage = `Usage: globster config <command> [flags] [file]
+
+Commands:
+  check                  Validate the configuration and report every error
+  print [-effective]     Print the settings that are set, or all settings
+                         including defaults with -effective
+
+The file defaults to CONFIG_FILE. Environment variables override the file.
+`
+
+// runConfigCommand lets operators verify a configuration before rolling it
+// out. It runs before the configuration is loaded, so it also works on
+// configurations the server would refuse to start with.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("config "+command, flag.ContinueOnError)
+	effective := false
+	if command == "print" {
+		flags.BoolVar(&effective, "effective", false, "include settings left at their defaults")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+	path := os.Getenv("CONFIG_FILE")
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+
+	_, settings, err := config.Load(path)
+	switch command {
+	case "check":
+		if err != nil {
+			printConfigErrors(err)
+			return 1
+		}
+		fmt.Println("Configuration is valid")
+	case "print":
+		printSettings(settings, effective)
+		if err != nil {
+			printConfigErrors(err)
+			return 1
+		}
+	default:
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+	return 0
+}
+
+func printConfigErrors(err error) {
+	fmt.Fprintln(os.Stderr, "Invalid configuration:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+}
+
+// printSettings writes the settings in the config file format, so the output
+// can be used as a starting point for one. Secrets are redacted.
+func printSettings(settings []config.Setting, effective bool) {
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	for _, setting := range settings {
+		if !effective && setting.Source == config.SourceDefault {
+			continue
+		}
+		value := strconv.Quote(setting.Value)
+		if setting.Secret && setting.Value != "" {
+			value = `"<redacted>"`
+		}
+		fmt.Printf("%s: %s # %s\n", strings.ToLower(setting.Name), value, setting.Source)
+	}
+}
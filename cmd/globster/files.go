@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/files"
+	"os"
+)
+
+const filesUsage = `Usage: globster files <command> [flags]
+
+Commands:
+  reconcile [-dry-run]   Record metadata for files on disk that have none,
+                         and forget files that no longer exist
+`
+
+// runFilesCommand maintains the file metadata from the command line, for
+// files uploaded by older versions or copied into the upload directory.
+func runFilesCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, filesUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "reconcile":
+		flags := flag.NewFlagSet("files reconcile", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "list the changes without making them")
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+		if flags.NArg() != 0 {
+			fmt.Fprint(os.Stderr, filesUsage)
+			return 2
+		}
+		db, err := database.Open(cfg.Database.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		metadataStore, err := metadata.NewMetadataStore(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create file metadata store: %v\n", err)
+			return 1
+		}
+		fileService := files.NewFileService(&files.Config{
+			BaseDir:     cfg.Upload.Path,
+			MaxFileSize: cfg.Upload.MaxFileSize,
+		}, metadataStore)
+		report, err := fileService.Reconcile(context.Background(), *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reconcile files: %v\n", err)
+			return 1
+		}
+		verb := ""
+		if *dryRun {
+			verb = "would be "
+		}
+		for _, name := range report.Added {
+			fmt.Printf("+ %s\n", name)
+		}
+		for _, name := range report.Removed {
+			fmt.Printf("- %s\n", name)
+		}
+		fmt.Printf("%d files %srecorded, %d missing files %sforgotten\n", len(report.Added), verb, len(report.Removed), verb)
+	default:
+		fmt.Fprint(os.Stderr, filesUsage)
+		return 2
+	}
+	return 0
+}
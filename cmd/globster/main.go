@@ -1,28 +1,55 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"github.com/frodejac/globster/internal/api"
 	"github.com/frodejac/globster/internal/auth"
 	g "github.com/frodejac/globster/internal/auth/google"
 	s "github.com/frodejac/globster/internal/auth/static"
+	"github.com/frodejac/globster/internal/certs"
 	"github.com/frodejac/globster/internal/config"
 	"github.com/frodejac/globster/internal/database"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/database/passkeys"
+	"github.com/frodejac/globster/internal/database/policies"
+	"github.com/frodejac/globster/internal/database/quarantine"
 	"github.com/frodejac/globster/internal/database/sessions"
+	"github.com/frodejac/globster/internal/database/trash"
+	"github.com/frodejac/globster/internal/database/twofactor"
+	"github.com/frodejac/globster/internal/database/users"
 	"github.com/frodejac/globster/internal/downloads"
 	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/health"
+	"github.com/frodejac/globster/internal/janitor"
+	"github.com/frodejac/globster/internal/mail"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/scanner"
+	"github.com/frodejac/globster/internal/templates"
+	"github.com/frodejac/globster/internal/tracing"
 	"github.com/frodejac/globster/internal/uploads"
-	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// tracingShutdownTimeout bounds flushing the last spans on shutdown
+const tracingShutdownTimeout = 5 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		panic(err)
+		printConfigErrors(err)
+		os.Exit(1)
 	}
 	logOptions := &slog.HandlerOptions{Level: cfg.Logger.Level}
 	var logHandler slog.Handler
@@ -34,6 +61,24 @@ func main() {
 	logger := slog.New(logHandler)
 	slog.SetDefault(logger)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "user":
+			os.Exit(runUserCommand(cfg, os.Args[2:]))
+		case "files":
+			os.Exit(runFilesCommand(cfg, os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
 	var googleAuth *g.Auth
 	if cfg.Auth.Type == config.AuthTypeGoogle {
 		cfg.Auth.Google.RedirectURL = cfg.BaseUrl + "/oauth/callback"
@@ -44,25 +89,42 @@ func main() {
 		}
 	}
 
+	db, err := database.Open(cfg.Database.Path)
+	if err != nil {
+		slog.Error("Failed to open database", "error", err)
+		os.Exit(1)
+	}
+
+	userStore, err := users.NewUserStore(db)
+	if err != nil {
+		slog.Error("Failed to create user store", "error", err)
+		os.Exit(1)
+	}
+
 	var staticAuth *s.Auth
 	if cfg.Auth.Type == config.AuthTypeStatic {
-		staticAuth, err = s.NewAuthFromConfig(cfg.Auth.Static)
+		staticAuth, err = s.NewAuthFromConfig(userStore, cfg.Auth.Static)
 		if err != nil {
 			slog.Error("Failed to create static auth", "error", err)
 			os.Exit(1)
 		}
 	}
 
-	db, err := database.Open(cfg.Database.Path)
+	linkStore, err := links.NewLinkStore(db)
 	if err != nil {
-		slog.Error("Failed to open database", "error", err)
+		slog.Error("Failed to create link store", "error", err)
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	linkStore, err := links.NewLinkStore(db)
+	policyStore, err := policies.NewPolicyStore(db)
 	if err != nil {
-		slog.Error("Failed to create link store", "error", err)
+		slog.Error("Failed to create policy store", "error", err)
+		os.Exit(1)
+	}
+
+	metadataStore, err := metadata.NewMetadataStore(db)
+	if err != nil {
+		slog.Error("Failed to create file metadata store", "error", err)
 		os.Exit(1)
 	}
 
@@ -72,13 +134,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	twoFactorStore, err := twofactor.NewTwoFactorStore(db)
+	if err != nil {
+		slog.Error("Failed to create two-factor store", "error", err)
+		os.Exit(1)
+	}
+
 	sessionCookieCfg := &auth.SessionCookieConfig{
-		Name:     cfg.Session.Cookie.Name,
-		Path:     cfg.Session.Cookie.Path,
-		HttpOnly: cfg.Session.Cookie.HttpOnly,
-		Secure:   cfg.Session.Cookie.Secure,
-		SameSite: cfg.Session.Cookie.SameSite,
-		Lifetime: cfg.Session.Lifetime,
+		Name:        cfg.Session.Cookie.Name,
+		Path:        cfg.Session.Cookie.Path,
+		HttpOnly:    cfg.Session.Cookie.HttpOnly,
+		Secure:      cfg.Session.Cookie.Secure,
+		SameSite:    cfg.Session.Cookie.SameSite,
+		Lifetime:    cfg.Session.Lifetime,
+		IdleTimeout: cfg.Session.IdleTimeout,
 	}
 
 	sessionService := auth.NewSessionService(
@@ -86,13 +155,80 @@ func main() {
 		sessionCookieCfg,
 	)
 
+	totpService, err := auth.NewTOTPService(
+		twoFactorStore,
+		&auth.TOTPConfig{
+			Issuer:        cfg.Auth.TOTP.Issuer,
+			EncryptionKey: cfg.Auth.TOTP.EncryptionKey,
+			Required:      cfg.Auth.TOTP.Required && cfg.Auth.Type == config.AuthTypeStatic,
+		},
+	)
+	if err != nil {
+		slog.Error("Failed to create two-factor service", "error", err)
+		os.Exit(1)
+	}
+
+	passkeyStore, err := passkeys.NewPasskeyStore(db)
+	if err != nil {
+		slog.Error("Failed to create passkey store", "error", err)
+		os.Exit(1)
+	}
+
+	// A passkey login would skip the domain and group checks Google users
+	// have to pass at every login
+	passkeysEnabled := cfg.Auth.Passkeys.Enabled && cfg.Auth.Type == config.AuthTypeStatic
+	if cfg.Auth.Passkeys.Enabled && !passkeysEnabled {
+		slog.Warn("Passkeys are only available with static authentication, WEBAUTHN_ENABLED is ignored")
+	}
+	passkeyService, err := auth.NewPasskeyService(
+		passkeyStore,
+		&auth.PasskeyConfig{
+			Enabled:         passkeysEnabled,
+			RPID:            cfg.Auth.Passkeys.RPID,
+			RPDisplayName:   cfg.Auth.Passkeys.RPDisplayName,
+			Origins:         cfg.Auth.Passkeys.Origins,
+			CeremonyTimeout: 5 * time.Minute,
+			CookieSecure:    cfg.Session.Cookie.Secure,
+		},
+	)
+	if err != nil {
+		slog.Error("Failed to create passkey service", "error", err)
+		os.Exit(1)
+	}
+
+	mailer := mail.NewMailer(cfg.Mail)
+	if !mailer.Enabled() {
+		slog.Info("SMTP not configured, download links cannot be restricted to recipients")
+	}
+
+	quarantineStore, err := quarantine.NewQuarantineStore(db)
+	if err != nil {
+		slog.Error("Failed to create quarantine store", "error", err)
+		os.Exit(1)
+	}
+	uploadScanner, err := scanner.NewScanner(cfg.Scanner)
+	if err != nil {
+		slog.Error("Failed to create scanner", "error", err)
+		os.Exit(1)
+	}
+	scanService := scanner.NewService(uploadScanner, quarantineStore, mailer, cfg.Scanner)
+	if !scanService.Enabled() {
+		slog.Info("Malware scanning disabled, uploads are stored unscanned")
+	}
+	if cfg.Scanner.Type == scanner.TypeFake && !cfg.IsDevelopment {
+		slog.Warn("SCANNER is set to fake, which only detects the EICAR test file. Uploads are NOT scanned for malware, use clamd outside development")
+	}
+
 	uploadService, err := uploads.NewUploadService(
 		linkStore,
+		metadataStore,
+		scanService,
 		&uploads.Config{
 			MaxFileSize:       cfg.Upload.MaxFileSize,
 			BaseDir:           cfg.Upload.Path,
 			AllowedExtensions: cfg.Upload.AllowedExtensions,
 			AllowedMimeTypes:  cfg.Upload.AllowedMimeTypes,
+			ComputeMd5:        cfg.Upload.ComputeMd5,
 		})
 	if err != nil {
 		slog.Error("Failed to create upload service", "error", err)
@@ -102,38 +238,91 @@ func main() {
 	downloadService := downloads.NewDownloadService(
 		linkStore,
 		&downloads.Config{
-			BaseDir: cfg.Upload.Path,
+			BaseDir:      cfg.Upload.Path,
+			CodeLifetime: cfg.Upload.RecipientCodeLifetime,
 		},
+		mailer,
 	)
 
 	fileService := files.NewFileService(&files.Config{
 		BaseDir:     cfg.Upload.Path,
 		MaxFileSize: cfg.Upload.MaxFileSize,
-	})
+	}, metadataStore)
+
+	trashStore, err := trash.NewTrashStore(db)
+	if err != nil {
+		slog.Error("Failed to create trash store", "error", err)
+		os.Exit(1)
+	}
+	trashService := files.NewTrashService(trashStore, fileService)
 
-	templates, err := template.ParseGlob(filepath.Join(cfg.TemplatePath, "*.html"))
+	templateSet, err := templates.Load(cfg.TemplatePath)
 	if err != nil {
 		slog.Error("Failed to parse templates", "error", err)
 		os.Exit(1)
 	}
 
+	healthService := health.NewHealthService(&health.Config{
+		UploadPath:       cfg.Upload.Path,
+		MinFreeDiskBytes: cfg.Health.MinFreeDiskBytes,
+	})
+	healthService.AddCheck("database", func(ctx context.Context) error {
+		return database.Ping(ctx, db)
+	})
+	healthService.AddCheck("templates", func(ctx context.Context) error {
+		if templateSet.Count() == 0 {
+			return fmt.Errorf("no templates loaded from %s", cfg.TemplatePath)
+		}
+		return nil
+	})
+	if scanService.Enabled() {
+		healthService.AddCheck("scanner", scanService.Ping)
+	}
+	if googleAuth != nil {
+		healthService.AddCheck("google_oidc", googleAuth.Ready)
+	}
+
+	linkJanitor := janitor.NewJanitor(linkStore, policyStore, metadataStore, trashService, cfg.Janitor)
+
 	apiCfg := &api.Config{
-		AuthType:            cfg.Auth.Type,
-		BaseUrl:             cfg.BaseUrl,
-		StaticAuthRateLimit: cfg.Auth.RateLimit,
-		StaticPath:          cfg.StaticPath,
-		UploadPath:          cfg.Upload.Path,
+		AuthType:             cfg.Auth.Type,
+		BaseUrl:              cfg.BaseUrl,
+		StaticAuthRateLimit:  cfg.Auth.RateLimit,
+		StaticAuthRateBurst:  cfg.Auth.RateBurst,
+		LoginMaxFailures:     cfg.Auth.MaxFailures,
+		LoginLockoutDuration: cfg.Auth.LockoutDuration,
+		TokenMaxFailures:     cfg.Upload.TokenMaxFailures,
+		TokenBanDuration:     cfg.Upload.TokenBanDuration,
+		LinkAccess: &auth.LinkAccessConfig{
+			Lifetime:        cfg.Upload.LinkPassword.AccessLifetime,
+			Secure:          cfg.Session.Cookie.Secure,
+			MaxFailures:     cfg.Upload.LinkPassword.MaxFailures,
+			LockoutDuration: cfg.Upload.LinkPassword.LockoutDuration,
+		},
+		StaticPath:        cfg.StaticPath,
+		UploadPath:        cfg.Upload.Path,
+		UploadTimeout:     cfg.Server.UploadTimeout,
+		DownloadTimeout:   cfg.Server.DownloadTimeout,
+		RequireClientCert: cfg.Server.TLS.RequireClientCert,
+		CookieSecure:      cfg.Session.Cookie.Secure,
 	}
 
 	router := api.NewRouter(
-		templates,
+		templateSet,
 		sessionService,
 		linkStore,
+		policyStore,
 		staticAuth,
 		googleAuth,
 		uploadService,
 		downloadService,
 		fileService,
+		trashService,
+		scanService,
+		healthService,
+		totpService,
+		passkeyService,
+		linkJanitor,
 		apiCfg,
 	)
 
@@ -143,12 +332,116 @@ func main() {
 	// Add middleware
 	handler := api.SecurityHeadersMiddleware(cfg.Server.UseHsts)(mux)
 	handler = api.LoggingMiddleWare(handler)
+	handler = api.TracingMiddleware(handler)
+	handler = api.ProxyHeadersMiddleware(proxy.NewResolver(&proxy.Config{
+		TrustedProxies: cfg.Server.TrustedProxies,
+	}))(handler)
 	handler = api.RequestIdMiddleware(handler)
 
-	slog.Info("Starting server", "port", cfg.Server.Port)
-	err = http.ListenAndServe(":"+cfg.Server.Port, handler)
-	if err != nil {
+	server := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logHandler, slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	configReloader := &reloader{
+		config:        cfg,
+		templates:     templateSet,
+		uploadService: uploadService,
+		staticAuth:    staticAuth,
+	}
+	go configReloader.watch(ctx)
+	go sessionService.Sweep(ctx, cfg.Session.SweepInterval)
+	go linkJanitor.Watch(ctx)
+
+	var redirectServer *http.Server
+	if cfg.Server.TLS.Enabled() {
+		reloader, err := certs.NewReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		go reloader.Watch(ctx, cfg.Server.TLS.ReloadInterval)
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		if cfg.Server.TLS.ClientCAFile != "" {
+			clientCAs, err := certs.LoadCertPool(cfg.Server.TLS.ClientCAFile)
+			if err != nil {
+				slog.Error("Failed to load client CA", "error", err)
+				os.Exit(1)
+			}
+			// Certificates are only enforced on admin routes, so public links
+			// keep working for clients without one
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			server.TLSConfig.ClientCAs = clientCAs
+		}
+		if cfg.Server.TLS.RedirectPort != "" {
+			redirectServer = &http.Server{
+				Addr:              ":" + cfg.Server.TLS.RedirectPort,
+				Handler:           api.HTTPSRedirectHandler(cfg.BaseUrl),
+				ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+				ReadTimeout:       cfg.Server.ReadTimeout,
+				WriteTimeout:      cfg.Server.WriteTimeout,
+				IdleTimeout:       cfg.Server.IdleTimeout,
+				ErrorLog:          server.ErrorLog,
+			}
+		}
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		if server.TLSConfig != nil {
+			slog.Info("Starting server", "port", cfg.Server.Port, "tls", true)
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("Starting server", "port", cfg.Server.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+	if redirectServer != nil {
+		go func() {
+			slog.Info("Starting HTTPS redirect server", "port", cfg.Server.TLS.RedirectPort)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
 		slog.Error("Failed to start server", "error", err)
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Stop accepting connections and let in-flight requests finish
+	slog.Info("Shutting down server", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down redirect server", "error", err)
+		}
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain in-flight requests", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+	// The drain may have used up shutdownCtx, so the final spans get their own
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancelTracing()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
+	slog.Info("Server stopped")
 }
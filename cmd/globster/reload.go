@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	s "github.com/frodejac/globster/internal/auth/static"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/templates"
+	"github.com/frodejac/globster/internal/uploads"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// reloader applies the reloadable parts of the configuration to the running
+// services without restarting the server.
+type reloader struct {
+	config        *config.Config
+	templates     *templates.Set
+	uploadService *uploads.UploadService
+	staticAuth    *s.Auth
+}
+
+// watch reloads on SIGHUP until ctx is cancelled.
+func (r *reloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload(ctx)
+		}
+	}
+}
+
+// reload loads the configuration again and applies what it can. Each part is
+// swapped independently, and a part that fails to load keeps its current
+// value.
+func (r *reloader) reload(ctx context.Context) {
+	slog.Info("Reloading configuration")
+	next, err := config.LoadConfig()
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	if next.Auth.Type == config.AuthTypeGoogle {
+		next.Auth.Google.RedirectURL = next.BaseUrl + "/oauth/callback"
+	}
+	if changed := config.RestartRequired(r.config, next); len(changed) > 0 {
+		slog.Warn("Some changed settings need a restart to take effect", "settings", strings.Join(changed, ","))
+	}
+
+	if err := r.templates.Reload(next.TemplatePath); err != nil {
+		slog.Error("Failed to reload templates", "error", err)
+	} else {
+		slog.Info("Reloaded templates", "path", next.TemplatePath)
+	}
+	r.uploadService.SetAllowedTypes(next.Upload.AllowedExtensions, next.Upload.AllowedMimeTypes)
+	slog.Info("Reloaded allowed upload types",
+		"extensions", strings.Join(next.Upload.AllowedExtensions, ","),
+		"mime_types", strings.Join(next.Upload.AllowedMimeTypes, ","),
+	)
+	if r.staticAuth != nil {
+		if err := r.staticAuth.Reload(ctx, next.Auth.Static); err != nil {
+			slog.Error("Failed to reload static auth", "error", err)
+		} else {
+			slog.Info("Reloaded static auth")
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	s "github.com/frodejac/globster/internal/auth/static"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/database/sessions"
+	"github.com/frodejac/globster/internal/database/users"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const userUsage = `Usage: globster user <command> [flags]
+
+Commands:
+  add [-no-force-change] <username>   Add a user, asking for a temporary password
+  passwd [-force-change] <username>   Set the password of a user
+  disable <username>                  Disable a user and end their sessions
+  enable <username>                   Enable a disabled user
+  list                                List users
+`
+
+// runUserCommand manages static users from the command line, for setting up
+// the first user and for recovering from being locked out of the admin UI.
+func runUserCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	if cfg.Auth.Type != config.AuthTypeStatic {
+		fmt.Fprintf(os.Stderr, "Users can only be managed with AUTH_TYPE=%s\n", config.AuthTypeStatic)
+		return 1
+	}
+	db, err := database.Open(cfg.Database.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	userStore, err := users.NewUserStore(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create user store: %v\n", err)
+		return 1
+	}
+	staticAuth, err := s.NewAuthFromConfig(userStore, cfg.Auth.Static)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create static auth: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	command, args := args[0], args[1:]
+	switch command {
+	case "add":
+		flags := flag.NewFlagSet("user add", flag.ContinueOnError)
+		noForceChange := flags.Bool("no-force-change", false, "don't require a password change on first login")
+		username, ok := parseUserArgs(flags, args)
+		if !ok {
+			return 2
+		}
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+			return 1
+		}
+		if err := staticAuth.AddUser(ctx, username, password, !*noForceChange); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add user: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Added user %s\n", username)
+	case "passwd":
+		flags := flag.NewFlagSet("user passwd", flag.ContinueOnError)
+		forceChange := flags.Bool("force-change", false, "require a password change on next login")
+		username, ok := parseUserArgs(flags, args)
+		if !ok {
+			return 2
+		}
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+			return 1
+		}
+		if err := staticAuth.SetPassword(ctx, username, password, *forceChange); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set password: %v\n", err)
+			return 1
+		}
+		if err := endSessions(ctx, db, username); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to end sessions: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Set password for %s\n", username)
+	case "disable", "enable":
+		username, ok := parseUserArgs(flag.NewFlagSet("user "+command, flag.ContinueOnError), args)
+		if !ok {
+			return 2
+		}
+		disabled := command == "disable"
+		if err := staticAuth.SetDisabled(ctx, username, disabled); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to %s user: %v\n", command, err)
+			return 1
+		}
+		if disabled {
+			if err := endSessions(ctx, db, username); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to end sessions: %v\n", err)
+				return 1
+			}
+		}
+		fmt.Printf("%sd user %s\n", strings.ToUpper(command[:1])+command[1:], username)
+	case "list":
+		list, err := staticAuth.ListUsers(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list users: %v\n", err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tSTATUS\tCREATED\tLAST LOGIN")
+		for _, user := range list {
+			status := "active"
+			if user.Disabled {
+				status = "disabled"
+			} else if user.MustChangePassword {
+				status = "must change password"
+			}
+			lastLogin := "never"
+			if user.LastLoginAt != nil {
+				lastLogin = user.LastLoginAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", user.Username, status, user.CreatedAt.Format(time.DateTime), lastLogin)
+		}
+		tw.Flush()
+	default:
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	return 0
+}
+
+func parseUserArgs(flags *flag.FlagSet, args []string) (string, bool) {
+	if err := flags.Parse(args); err != nil {
+		return "", false
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return "", false
+	}
+	return flags.Arg(0), true
+}
+
+// readPassword prompts for the password twice on a terminal, and otherwise
+// reads a single line from stdin so it can be piped in by scripts.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return string(password), nil
+}
+
+func endSessions(ctx context.Context, db *sql.DB, username string) error {
+	sessionStore, err := sessions.NewSessionStore(db)
+	if err != nil {
+		return err
+	}
+	return sessionStore.DeleteByUsername(ctx, username)
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database/passkeys"
+	"github.com/frodejac/globster/internal/templates"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// passkeyRegisterPath scopes passkey ceremony cookies to the registration routes
+const passkeyRegisterPath = "/admin/account/passkeys/"
+
+type AccountHandler struct {
+	BaseHandler
+	totp     *auth.TOTPService
+	passkeys *auth.PasskeyService
+}
+
+type AccountData struct {
+	Username      string
+	Static        bool
+	Available     bool
+	Required      bool
+	TOTP          *auth.TOTPStatus
+	Enrollment    *auth.Enrollment
+	RecoveryCodes []string
+	Passkeys      []passkeys.Credential
+	PasskeysReady bool
+	Error         string
+}
+
+func NewAccountHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, totp *auth.TOTPService, passkeys *auth.PasskeyService) *AccountHandler {
+	return &AccountHandler{
+		BaseHandler: BaseHandler{
+			authType:  authType,
+			sessions:  sessions,
+			templates: templates,
+		},
+		totp:     totp,
+		passkeys: passkeys,
+	}
+}
+
+func (h *AccountHandler) HandleAccount(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.accountData(w, r)
+	if !ok {
+		return
+	}
+	h.renderTemplate(w, "admin_account.html", data)
+}
+
+func (h *AccountHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.staticAccountData(w, r)
+	if !ok {
+		return
+	}
+	if data.TOTP.Enrolled {
+		http.Redirect(w, r, "/admin/account/", http.StatusFound)
+		return
+	}
+	enrollment, err := h.totp.BeginEnrollment(r.Context(), data.Username)
+	if err != nil {
+		slog.Error("Error starting two-factor enrollment", slog.String("username", data.Username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.Enrollment = enrollment
+	h.renderTemplate(w, "admin_account.html", data)
+}
+
+func (h *AccountHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.staticAccountData(w, r)
+	if !ok {
+		return
+	}
+	codes, err := h.totp.ConfirmEnrollment(r.Context(), data.Username, r.PostFormValue("code"))
+	if err != nil {
+		if !errors.Is(err, auth.ErrTOTPInvalidCode) {
+			slog.Error("Error confirming two-factor enrollment", slog.String("username", data.Username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data.Enrollment, err = h.totp.PendingEnrollment(r.Context(), data.Username)
+		if err != nil {
+			slog.Error("Error loading two-factor enrollment", slog.String("username", data.Username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data.Error = "Invalid code."
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderTemplate(w, "admin_account.html", data)
+		return
+	}
+	slog.Info("Two-factor authentication enrolled", slog.String("username", data.Username))
+	data.RecoveryCodes = codes
+	h.renderTemplate(w, "admin_account.html", data)
+}
+
+func (h *AccountHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.staticAccountData(w, r)
+	if !ok {
+		return
+	}
+	if !h.verifyCode(w, r, data) {
+		return
+	}
+	codes, err := h.totp.RegenerateRecoveryCodes(r.Context(), data.Username)
+	if err != nil {
+		slog.Error("Error generating recovery codes", slog.String("username", data.Username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Recovery codes regenerated", slog.String("username", data.Username))
+	data.RecoveryCodes = codes
+	h.renderTemplate(w, "admin_account.html", data)
+}
+
+func (h *AccountHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.staticAccountData(w, r)
+	if !ok {
+		return
+	}
+	if err := h.totp.Disable(r.Context(), data.Username, r.PostFormValue("code")); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrTOTPInvalidCode):
+			data.Error = "Invalid code."
+		case errors.Is(err, auth.ErrTOTPRequired):
+			data.Error = "Two-factor authentication is required and cannot be disabled."
+		default:
+			slog.Error("Error disabling two-factor authentication", slog.String("username", data.Username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderTemplate(w, "admin_account.html", data)
+		return
+	}
+	slog.Info("Two-factor authentication disabled", slog.String("username", data.Username))
+	http.Redirect(w, r, "/admin/account/", http.StatusFound)
+}
+
+func (h *AccountHandler) HandlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.passkeyUser(w, r)
+	if !ok {
+		return
+	}
+	creation, err := h.passkeys.BeginRegistration(r.Context(), w, username, passkeyRegisterPath)
+	if err != nil {
+		slog.Error("Error starting passkey registration", slog.String("username", username), slog.Any("error", err))
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start passkey registration"})
+		return
+	}
+	writeJson(w, http.StatusOK, creation)
+}
+
+func (h *AccountHandler) HandlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.passkeyUser(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if err := h.passkeys.FinishRegistration(r.Context(), w, r, username, name, passkeyRegisterPath); err != nil {
+		if !errors.Is(err, auth.ErrPasskeyInvalid) {
+			slog.Error("Error registering passkey", slog.String("username", username), slog.Any("error", err))
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to register passkey"})
+			return
+		}
+		slog.Warn("Invalid passkey registration", slog.String("username", username), slog.Any("error", err))
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "Passkey could not be verified"})
+		return
+	}
+	slog.Info("Passkey registered", slog.String("username", username), slog.String("name", name))
+	writeJson(w, http.StatusOK, map[string]string{"redirect": "/admin/account/"})
+}
+
+func (h *AccountHandler) HandlePasskeyDelete(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.passkeyUser(w, r)
+	if !ok {
+		return
+	}
+	id := r.PostFormValue("id")
+	if err := h.passkeys.DeleteCredential(r.Context(), username, id); err != nil {
+		slog.Error("Error deleting passkey", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Passkey deleted", slog.String("username", username))
+	http.Redirect(w, r, "/admin/account/", http.StatusFound)
+}
+
+// passkeyUser returns the user of the session for passkey management, which
+// is available to every user with passkeys enabled.
+func (h *AccountHandler) passkeyUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !h.passkeys.Available() {
+		h.render404(w)
+		return "", false
+	}
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	if username == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return "", false
+	}
+	return username, true
+}
+
+// verifyCode checks the current code posted with the form and renders the
+// account page with an error when it is wrong.
+func (h *AccountHandler) verifyCode(w http.ResponseWriter, r *http.Request, data *AccountData) bool {
+	err := h.totp.Verify(r.Context(), data.Username, r.PostFormValue("code"))
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, auth.ErrTOTPInvalidCode) {
+		slog.Error("Error verifying two-factor code", slog.String("username", data.Username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	data.Error = "Invalid code."
+	w.WriteHeader(http.StatusBadRequest)
+	h.renderTemplate(w, "admin_account.html", data)
+	return false
+}
+
+// staticAccountData loads the account page data for requests that change
+// two-factor settings, which only exist for static users.
+func (h *AccountHandler) staticAccountData(w http.ResponseWriter, r *http.Request) (*AccountData, bool) {
+	data, ok := h.accountData(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !data.Static || data.Username == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+func (h *AccountHandler) accountData(w http.ResponseWriter, r *http.Request) (*AccountData, bool) {
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	data := &AccountData{
+		Username:  username,
+		Static:    h.authType == config.AuthTypeStatic,
+		Available: h.totp.Available(),
+		Required:  h.totp.Required(),
+		TOTP:      &auth.TOTPStatus{},
+		// Sessions from before usernames were recorded cannot register passkeys
+		PasskeysReady: h.passkeys.Available() && username != "",
+	}
+	if data.PasskeysReady {
+		data.Passkeys, err = h.passkeys.ListCredentials(r.Context(), username)
+		if err != nil {
+			slog.Error("Error listing passkeys", slog.String("username", username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	if data.Static && username != "" {
+		data.TOTP, err = h.totp.Status(r.Context(), username)
+		if err != nil {
+			slog.Error("Error checking two-factor status", slog.String("username", username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	return data, true
+}
@@ -1,43 +1,56 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/config"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/policies"
 	"github.com/frodejac/globster/internal/downloads"
 	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/janitor"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"github.com/frodejac/globster/internal/templates"
 	"github.com/frodejac/globster/internal/uploads"
-	"html/template"
 	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 type AdminHandler struct {
 	BaseHandler
-	baseUrl   string
-	linkStore *links.Store
-	uploads   *uploads.UploadService
-	downloads *downloads.DownloadService
-	files     *files.FileService
+	baseUrl    string
+	linkStore  *links.Store
+	policies   *policies.Store
+	uploads    *uploads.UploadService
+	downloads  *downloads.DownloadService
+	files      *files.FileService
+	trash      *files.TrashService
+	tokenGuard *ratelimit.Guard
+	janitor    *janitor.Janitor
 }
 
-func NewAdminHandler(authType config.AuthType, baseUrl string, sessions *auth.SessionService, templates *template.Template, linkStore *links.Store, uploads *uploads.UploadService, downloads *downloads.DownloadService, files *files.FileService) *AdminHandler {
+func NewAdminHandler(authType config.AuthType, baseUrl string, sessions *auth.SessionService, templates *templates.Set, linkStore *links.Store, policies *policies.Store, uploads *uploads.UploadService, downloads *downloads.DownloadService, files *files.FileService, trash *files.TrashService, tokenGuard *ratelimit.Guard, janitor *janitor.Janitor) *AdminHandler {
 	return &AdminHandler{
 		BaseHandler: BaseHandler{
 			authType:  authType,
 			sessions:  sessions,
 			templates: templates,
 		},
-		baseUrl:   baseUrl,
-		linkStore: linkStore,
-		uploads:   uploads,
-		downloads: downloads,
-		files:     files,
+		baseUrl:    baseUrl,
+		linkStore:  linkStore,
+		policies:   policies,
+		uploads:    uploads,
+		downloads:  downloads,
+		files:      files,
+		trash:      trash,
+		tokenGuard: tokenGuard,
+		janitor:    janitor,
 	}
 }
 
@@ -46,20 +59,38 @@ type AdminData struct {
 	Directories   []files.Directory
 	Directory     *files.Directory
 	DownloadLinks []links.DownloadLink
+	TokenStats    ratelimit.GuardStats
+	Janitor       *janitor.Report
+	Policies      map[string]policies.Policy
+	Policy        policies.Policy
+	File          *files.File
+	FileAction    *FileAction
+	// DefaultRetentionDays applies to directories without a retention
+	// policy, zero meaning files are kept forever
+	DefaultRetentionDays int
 }
 
 func (h *AdminHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
-	activeLinks, err := h.linkStore.ListActiveUploadLinks()
+	activeLinks, err := h.linkStore.ListActiveUploadLinks(r.Context())
 	if err != nil {
 		slog.Error("Failed to fetch active links", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	baseUrl := proxy.BaseUrl(r, h.baseUrl)
 	for i := range activeLinks {
-		activeLinks[i].Url = h.baseUrl + activeLinks[i].Url
+		activeLinks[i].Url = baseUrl + activeLinks[i].Url
 	}
 
-	h.renderTemplate(w, "admin_home.html", AdminData{UploadLinks: activeLinks})
+	h.renderTemplate(w, "admin_home.html", AdminData{UploadLinks: activeLinks, TokenStats: h.tokenGuard.Stats(), Janitor: h.janitor.LastReport()})
+}
+
+// HandleRunJanitor runs the janitor right away instead of waiting for the
+// next scheduled run.
+func (h *AdminHandler) HandleRunJanitor(w http.ResponseWriter, r *http.Request) {
+	// Finish the run even if the client goes away
+	h.janitor.Run(context.WithoutCancel(r.Context()))
+	http.Redirect(w, r, "/admin/home/", http.StatusSeeOther)
 }
 
 func (h *AdminHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
@@ -96,7 +127,7 @@ func (h *AdminHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid remaining uses", http.StatusBadRequest)
 		return
 	}
-	if err := h.uploads.CreateLink(directory, expiresAt, remainingUses); err != nil {
+	if err := h.uploads.CreateLink(r.Context(), directory, expiresAt, remainingUses, r.FormValue("password")); err != nil {
 		slog.Error("Failed to create upload link", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -116,7 +147,7 @@ func (h *AdminHandler) HandleDeactivateLink(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Deactivate the link in the database
-	if err := h.uploads.DeactivateLink(token); err != nil {
+	if err := h.uploads.DeactivateLink(r.Context(), token); err != nil {
 		slog.Error("Failed to deactivate upload link", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -126,13 +157,23 @@ func (h *AdminHandler) HandleDeactivateLink(w http.ResponseWriter, r *http.Reque
 }
 
 func (h *AdminHandler) HandleListDirectories(w http.ResponseWriter, r *http.Request) {
-	directories, err := h.files.ListDirectories()
+	directories, err := h.files.ListDirectories(r.Context())
 	if err != nil {
 		slog.Error("Failed to fetch directories", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	h.renderTemplate(w, "admin_directories.html", AdminData{Directories: directories})
+	dirPolicies, err := h.policies.List(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch directory policies", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderTemplate(w, "admin_directories.html", AdminData{
+		Directories:          directories,
+		Policies:             dirPolicies,
+		DefaultRetentionDays: h.defaultRetentionDays(),
+	})
 }
 
 func (h *AdminHandler) HandleListDirectory(w http.ResponseWriter, r *http.Request) {
@@ -141,7 +182,11 @@ func (h *AdminHandler) HandleListDirectory(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Missing directory", http.StatusBadRequest)
 		return
 	}
-	directory, err := h.files.ListFiles(dirName)
+	directory, err := h.files.ListFiles(r.Context(), dirName)
+	if errors.Is(err, files.ErrInvalidName) {
+		h.render404(w)
+		return
+	}
 	if err != nil {
 		slog.Error("Failed to fetch files", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -152,16 +197,117 @@ func (h *AdminHandler) HandleListDirectory(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Directory not found", http.StatusNotFound)
 		return
 	}
-	downloadLinks, err := h.linkStore.ListActiveDownloadLinks()
+	policy, err := h.policies.Get(r.Context(), files.TopLevel(dirName))
+	if err != nil {
+		slog.Error("Failed to fetch directory policy", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if retention := h.janitor.Retention(policy); retention > 0 {
+		for i := range directory.Files {
+			deleteAt := directory.Files[i].LastModified.Add(retention)
+			directory.Files[i].DeleteAt = &deleteAt
+		}
+	}
+	downloadLinks, err := h.linkStore.ListActiveDownloadLinks(r.Context())
 	if err != nil {
 		slog.Error("Failed to fetch download links", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	baseUrl := proxy.BaseUrl(r, h.baseUrl)
 	for i := range downloadLinks {
-		downloadLinks[i].Url = h.baseUrl + downloadLinks[i].Url
+		downloadLinks[i].Url = baseUrl + downloadLinks[i].Url
+		if err := h.downloads.LoadRecipients(r.Context(), &downloadLinks[i]); err != nil {
+			slog.Error("Failed to load link recipients", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.renderTemplate(w, "admin_directory.html", AdminData{
+		Directory:            directory,
+		DownloadLinks:        downloadLinks,
+		Policy:               policy,
+		DefaultRetentionDays: h.defaultRetentionDays(),
+	})
+}
+
+// HandleSetPolicy sets the retention policy and legal hold of a directory.
+func (h *AdminHandler) HandleSetPolicy(w http.ResponseWriter, r *http.Request) {
+	dirName := r.PathValue("directory")
+	if dirName == "" {
+		http.Error(w, "Missing directory", http.StatusBadRequest)
+		return
 	}
-	h.renderTemplate(w, "admin_directory.html", AdminData{Directory: directory, DownloadLinks: downloadLinks})
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	// Subfolders follow the policy of their top level directory
+	if files.Parent(dirName) != "" {
+		http.Error(w, "Policies can only be set on top level directories", http.StatusBadRequest)
+		return
+	}
+	policy := policies.Policy{
+		Dir:       dirName,
+		LegalHold: r.FormValue("legalHold") == "on",
+	}
+	switch r.FormValue("retention") {
+	case "default":
+	case "forever":
+		policy.KeepForever = true
+	case "days":
+		days, err := strconv.Atoi(r.FormValue("retentionDays"))
+		if err != nil || days < 1 {
+			http.Error(w, "Invalid retention days", http.StatusBadRequest)
+			return
+		}
+		policy.RetentionDays = days
+	default:
+		http.Error(w, "Invalid retention", http.StatusBadRequest)
+		return
+	}
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.policies.Set(r.Context(), policy, username); err != nil {
+		slog.Error("Failed to set directory policy", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Directory policy changed",
+		slog.String("directory", dirName),
+		slog.String("username", username),
+		slog.Int("retention_days", policy.RetentionDays),
+		slog.Bool("keep_forever", policy.KeepForever),
+		slog.Bool("legal_hold", policy.LegalHold),
+	)
+	http.Redirect(w, r, adminDirPath(dirName), http.StatusFound)
+}
+
+// HandleCreateDirectory creates a subfolder and opens it.
+func (h *AdminHandler) HandleCreateDirectory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	directory, err := h.files.CreateDirectory(r.Context(), r.PathValue("directory"), r.FormValue("name"))
+	if err != nil {
+		h.fileError(w, err)
+		return
+	}
+	slog.Info("Directory created",
+		slog.String("directory", directory),
+		slog.String("username", h.username(r)),
+	)
+	http.Redirect(w, r, adminDirPath(directory), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) defaultRetentionDays() int {
+	return int(h.janitor.Retention(policies.Policy{}) / (24 * time.Hour))
 }
 
 func (h *AdminHandler) HandleDownloadFile(w http.ResponseWriter, r *http.Request) {
@@ -171,22 +317,16 @@ func (h *AdminHandler) HandleDownloadFile(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Missing directory or filename", http.StatusBadRequest)
 		return
 	}
-	filePath, fileInfo, err := h.files.GetFilePath(dirName, fileName)
-	if err != nil {
-		slog.Error("Failed to get file path", "error", err)
-		h.render404(w)
-		return
-	}
 	// Open the file for reading
-	file, err := os.Open(filePath)
+	file, fileInfo, err := h.files.Open(r.Context(), dirName, fileName)
 	if err != nil {
 		slog.Error("Failed to open file", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		h.render404(w)
 		return
 	}
 	defer file.Close()
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", h.files.DisplayName(fileInfo.Name())))
-	http.ServeContent(w, r, filePath, fileInfo.ModTime(), file)
+	setFileHeaders(w, h.files, r, dirName, fileName)
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
 }
 
 func (h *AdminHandler) HandleShareDirectory(w http.ResponseWriter, r *http.Request) {
@@ -224,12 +364,17 @@ func (h *AdminHandler) HandleShareDirectory(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if err := h.downloads.CreateLink(dirName, expiresAt, remainingUses); err != nil {
+	// Recipients may be separated by commas or whitespace
+	recipients := strings.FieldsFunc(r.FormValue("recipients"), func(c rune) bool {
+		return c == ',' || c == ';' || unicode.IsSpace(c)
+	})
+
+	if err := h.downloads.CreateLink(r.Context(), dirName, expiresAt, remainingUses, r.FormValue("password"), recipients); err != nil {
 		slog.Error("Failed to create download link", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/admin/files/%s/", dirName), http.StatusFound)
+	http.Redirect(w, r, adminDirPath(dirName), http.StatusFound)
 }
 
 func (h *AdminHandler) HandleUnshareDirectory(w http.ResponseWriter, r *http.Request) {
@@ -245,22 +390,20 @@ func (h *AdminHandler) HandleUnshareDirectory(w http.ResponseWriter, r *http.Req
 		return
 	}
 	// Deactivate the link in the database
-	if err := h.downloads.DeactivateLink(token); err != nil {
+	if err := h.downloads.DeactivateLink(r.Context(), token); err != nil {
 		slog.Error("Failed to deactivate download link", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/admin/files/%s/", dirName), http.StatusFound)
+	http.Redirect(w, r, adminDirPath(dirName), http.StatusFound)
 }
 
 func (h *AdminHandler) HandlePostUpload(w http.ResponseWriter, r *http.Request) {
 	directory := r.PathValue("directory")
-	if err := h.uploads.AdminUpload(r, directory); err != nil {
+	if err := h.uploads.AdminUpload(r, directory, h.username(r)); err != nil {
 		slog.Error("Upload error", "error", err)
 		http.Redirect(w, r, "/upload/error", http.StatusFound)
 		return
 	}
-	// Remove the /upload suffix from the URL
-	redirectUrl := strings.TrimSuffix(r.URL.Path, "upload")
-	http.Redirect(w, r, redirectUrl, http.StatusFound)
+	http.Redirect(w, r, adminDirPath(directory), http.StatusFound)
 }
@@ -1,33 +1,67 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/auth/google"
 	"github.com/frodejac/globster/internal/auth/static"
 	"github.com/frodejac/globster/internal/config"
-	"golang.org/x/time/rate"
-	"html/template"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"github.com/frodejac/globster/internal/templates"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type AuthHandler struct {
 	BaseHandler
-	googleAuth *google.Auth
-	staticAuth *static.Auth
-	limiter    *rate.Limiter
+	googleAuth  *google.Auth
+	staticAuth  *static.Auth
+	totp        *auth.TOTPService
+	passkeys    *auth.PasskeyService
+	pending     *auth.PendingLoginService
+	ipLimiter   *ratelimit.KeyedLimiter
+	userLimiter *ratelimit.KeyedLimiter
+	lockout     *ratelimit.Lockout
 }
 
-func NewAuthHandler(authType config.AuthType, rateLimit rate.Limit, sessions *auth.SessionService, templates *template.Template, googleAuth *google.Auth, staticAuth *static.Auth) *AuthHandler {
+// passkeyLoginPath scopes passkey ceremony cookies to the login routes
+const passkeyLoginPath = "/login/"
+
+type SecondFactorData struct {
+	Enrollment *auth.Enrollment
+	TOTP       bool
+	Passkey    bool
+	Incorrect  bool
+}
+
+type RecoveryCodesData struct {
+	Codes    []string
+	Continue string
+}
+
+type PasswordChangeData struct {
+	Error string
+}
+
+func NewAuthHandler(authType config.AuthType, ipLimiter, userLimiter *ratelimit.KeyedLimiter, lockout *ratelimit.Lockout, sessions *auth.SessionService, templates *templates.Set, googleAuth *google.Auth, staticAuth *static.Auth, totp *auth.TOTPService, passkeys *auth.PasskeyService, pending *auth.PendingLoginService) *AuthHandler {
 	return &AuthHandler{
 		BaseHandler: BaseHandler{
 			authType:  authType,
 			sessions:  sessions,
 			templates: templates,
 		},
-		googleAuth: googleAuth,
-		staticAuth: staticAuth,
-		limiter:    rate.NewLimiter(rateLimit, 1),
+		googleAuth:  googleAuth,
+		staticAuth:  staticAuth,
+		totp:        totp,
+		passkeys:    passkeys,
+		pending:     pending,
+		ipLimiter:   ipLimiter,
+		userLimiter: userLimiter,
+		lockout:     lockout,
 	}
 }
 
@@ -37,28 +71,65 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method == http.MethodPost && h.authType == config.AuthTypeStatic {
-		if !h.limiter.Allow() {
-			slog.Warn("Rate limit exceeded", slog.String("username", r.PostForm.Get("username")))
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-			return
-		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 		username := r.PostForm.Get("username")
 		password := r.PostForm.Get("password")
-		if ok := h.staticAuth.Validate(username, password); !ok {
-			slog.Warn("Invalid login attempt", slog.String("username", username))
+		clientIp := proxy.ClientIP(r)
+		ipKey, userKey := loginKeys(username, clientIp)
+
+		for _, key := range []string{ipKey, userKey} {
+			if until, locked := h.lockout.LockedUntil(key); locked {
+				slog.Warn("Login attempt while locked out", slog.String("username", username), slog.String("client_ip", clientIp), slog.String("key", key), slog.Time("locked_until", until))
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+				http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+				return
+			}
+		}
+		if !h.ipLimiter.Allow(clientIp) || !h.userLimiter.Allow(username) {
+			slog.Warn("Rate limit exceeded", slog.String("username", username), slog.String("client_ip", clientIp))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		user, err := h.staticAuth.Validate(r.Context(), username, password)
+		if err != nil {
+			if !errors.Is(err, static.ErrInvalidCredentials) {
+				slog.Error("Error validating login", slog.String("username", username), slog.Any("error", err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			slog.Warn("Invalid login attempt", slog.String("username", username), slog.String("client_ip", clientIp))
+			for _, key := range []string{ipKey, userKey} {
+				if h.lockout.Failure(key) {
+					slog.Warn("Locking out after repeated login failures", slog.String("username", username), slog.String("client_ip", clientIp), slog.String("key", key))
+				}
+			}
 			http.Redirect(w, r, "/?state=1", http.StatusFound)
 			return
 		}
-		if _, err := h.sessions.Create(w); err != nil {
+		needsSecondFactor, err := h.needsSecondFactor(r.Context(), user.Username)
+		if err != nil {
+			slog.Error("Error checking two-factor status", slog.String("username", username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if needsSecondFactor {
+			// Failures are only reset once the second factor has been passed too
+			h.pending.Begin(w, auth.LoginStageSecondFactor, user.Username)
+			http.Redirect(w, r, "/login/2fa", http.StatusFound)
+			return
+		}
+		h.lockout.Reset(ipKey)
+		h.lockout.Reset(userKey)
+		redirect, err := h.startSession(w, r, user.Username)
+		if err != nil {
 			slog.Error("Error creating session", slog.String("username", username), slog.Any("error", err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin/home/", http.StatusFound)
+		http.Redirect(w, r, redirect, http.StatusFound)
 		return
 	}
 	// Invalid method/auth type combination
@@ -66,18 +137,390 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	h.render404(w)
 }
 
+// HandleSecondFactor is the second login step for static users who passed the
+// password check. Enrolled users enter a code from their authenticator app or
+// a recovery code, or use one of their passkeys; when two-factor
+// authentication is required, users with neither enroll an authenticator app
+// here before their first session is created.
+func (h *AuthHandler) HandleSecondFactor(w http.ResponseWriter, r *http.Request) {
+	login, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	status, err := h.totp.Status(r.Context(), login.username)
+	if err != nil {
+		slog.Error("Error checking two-factor status", slog.String("username", login.username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	hasPasskeys, err := h.passkeys.HasCredentials(r.Context(), login.username)
+	if err != nil {
+		slog.Error("Error checking passkeys", slog.String("username", login.username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	enroll := !status.Enrolled && !hasPasskeys
+
+	if r.Method == http.MethodGet {
+		data := SecondFactorData{TOTP: status.Enrolled, Passkey: hasPasskeys}
+		if enroll {
+			data.Enrollment, err = h.totp.BeginEnrollment(r.Context(), login.username)
+			if err != nil {
+				slog.Error("Error starting two-factor enrollment", slog.String("username", login.username), slog.Any("error", err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		h.renderTemplate(w, "login_2fa.html", data)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	code := r.PostForm.Get("code")
+	var recoveryCodes []string
+	switch {
+	case status.Enrolled:
+		err = h.totp.Verify(r.Context(), login.username, code)
+	case enroll:
+		recoveryCodes, err = h.totp.ConfirmEnrollment(r.Context(), login.username, code)
+	default:
+		// Only passkeys are registered, so no code can be right
+		err = auth.ErrTOTPInvalidCode
+	}
+	if err != nil {
+		if !errors.Is(err, auth.ErrTOTPInvalidCode) {
+			slog.Error("Error verifying two-factor code", slog.String("username", login.username), slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		slog.Warn("Invalid two-factor code", slog.String("username", login.username), slog.String("client_ip", login.clientIp))
+		h.secondFactorFailed(login)
+		data := SecondFactorData{TOTP: status.Enrolled, Passkey: hasPasskeys, Incorrect: true}
+		if enroll {
+			data.Enrollment, err = h.totp.PendingEnrollment(r.Context(), login.username)
+			if err != nil {
+				slog.Error("Error loading two-factor enrollment", slog.String("username", login.username), slog.Any("error", err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		h.renderTemplate(w, "login_2fa.html", data)
+		return
+	}
+
+	redirect, ok := h.completeLogin(w, r, login)
+	if !ok {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if recoveryCodes != nil {
+		slog.Info("Two-factor authentication enrolled", slog.String("username", login.username))
+		h.renderTemplate(w, "totp_recovery_codes.html", RecoveryCodesData{Codes: recoveryCodes, Continue: redirect})
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// HandlePasskeySecondFactorBegin starts a passkey check as the second login step.
+func (h *AuthHandler) HandlePasskeySecondFactorBegin(w http.ResponseWriter, r *http.Request) {
+	login, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	assertion, err := h.passkeys.BeginSecondFactor(r.Context(), w, login.username, passkeyLoginPath)
+	if err != nil {
+		slog.Error("Error starting passkey login", slog.String("username", login.username), slog.Any("error", err))
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start passkey login"})
+		return
+	}
+	writeJson(w, http.StatusOK, assertion)
+}
+
+func (h *AuthHandler) HandlePasskeySecondFactorFinish(w http.ResponseWriter, r *http.Request) {
+	login, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	if err := h.passkeys.FinishSecondFactor(r.Context(), w, r, login.username, passkeyLoginPath); err != nil {
+		if !errors.Is(err, auth.ErrPasskeyInvalid) {
+			slog.Error("Error verifying passkey", slog.String("username", login.username), slog.Any("error", err))
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to verify passkey"})
+			return
+		}
+		slog.Warn("Invalid passkey", slog.String("username", login.username), slog.String("client_ip", login.clientIp), slog.Any("error", err))
+		h.secondFactorFailed(login)
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "Passkey could not be verified"})
+		return
+	}
+	redirect, ok := h.completeLogin(w, r, login)
+	if !ok {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]string{"redirect": redirect})
+}
+
+// HandlePasskeyLoginBegin starts a standalone passkey login, which needs no
+// password and no further factor. It is only offered with static users, as
+// Google users have to pass the domain and group checks at every login.
+func (h *AuthHandler) HandlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	clientIp := proxy.ClientIP(r)
+	if h.passkeyLoginLocked(w, clientIp) {
+		return
+	}
+	if !h.ipLimiter.Allow(clientIp) {
+		slog.Warn("Rate limit exceeded", slog.String("client_ip", clientIp))
+		writeJson(w, http.StatusTooManyRequests, map[string]string{"error": "Too many requests"})
+		return
+	}
+	assertion, err := h.passkeys.BeginLogin(w, passkeyLoginPath)
+	if err != nil {
+		if errors.Is(err, auth.ErrPasskeyNotAvailable) {
+			h.render404(w)
+			return
+		}
+		slog.Error("Error starting passkey login", slog.Any("error", err))
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start passkey login"})
+		return
+	}
+	writeJson(w, http.StatusOK, assertion)
+}
+
+func (h *AuthHandler) HandlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	clientIp := proxy.ClientIP(r)
+	if h.passkeyLoginLocked(w, clientIp) {
+		return
+	}
+	username, err := h.passkeys.FinishLogin(r.Context(), w, r, passkeyLoginPath)
+	if err != nil {
+		if !errors.Is(err, auth.ErrPasskeyInvalid) {
+			slog.Error("Error verifying passkey", slog.Any("error", err))
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to verify passkey"})
+			return
+		}
+		slog.Warn("Invalid passkey login", slog.String("client_ip", clientIp), slog.Any("error", err))
+		h.passkeyLoginFailed(clientIp)
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "Passkey could not be verified"})
+		return
+	}
+	// Passkeys outlive the static user they were registered by
+	if _, err := h.staticAuth.Active(r.Context(), username); err != nil {
+		if !errors.Is(err, static.ErrInvalidCredentials) {
+			slog.Error("Error checking user", slog.String("username", username), slog.Any("error", err))
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to verify passkey"})
+			return
+		}
+		slog.Warn("Passkey login for unknown or disabled user", slog.String("username", username), slog.String("client_ip", clientIp))
+		h.passkeyLoginFailed(clientIp)
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "Passkey could not be verified"})
+		return
+	}
+	h.lockout.Reset(ipLoginKey(clientIp))
+	redirect, err := h.startSession(w, r, username)
+	if err != nil {
+		slog.Error("Error creating session", slog.String("username", username), slog.Any("error", err))
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+		return
+	}
+	slog.Info("Passkey login", slog.String("username", username), slog.String("client_ip", clientIp))
+	writeJson(w, http.StatusOK, map[string]string{"redirect": redirect})
+}
+
+// passkeyLoginLocked writes a response and returns true if the client is
+// locked out after failed logins of any kind.
+func (h *AuthHandler) passkeyLoginLocked(w http.ResponseWriter, clientIp string) bool {
+	until, locked := h.lockout.LockedUntil(ipLoginKey(clientIp))
+	if !locked {
+		return false
+	}
+	slog.Warn("Passkey login attempt while locked out", slog.String("client_ip", clientIp), slog.Time("locked_until", until))
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	writeJson(w, http.StatusTooManyRequests, map[string]string{"error": "Too many failed login attempts, try again later"})
+	return true
+}
+
+// passkeyLoginFailed counts a failed passkey login like a wrong password, as
+// no user is known to count it against.
+func (h *AuthHandler) passkeyLoginFailed(clientIp string) {
+	ipKey := ipLoginKey(clientIp)
+	if h.lockout.Failure(ipKey) {
+		slog.Warn("Locking out after repeated login failures", slog.String("client_ip", clientIp), slog.String("key", ipKey))
+	}
+}
+
+// loginKeys returns the lockout keys for login failures by a client. Failures
+// against a user are counted per client too, so nobody can lock another
+// client out of an account by failing to log in to it on purpose; guessing
+// one user's password from many clients is slowed by userLimiter instead.
+func loginKeys(username, clientIp string) (ipKey, userKey string) {
+	return ipLoginKey(clientIp), "user:" + username + "|" + clientIp
+}
+
+// ipLoginKey is the lockout key for all login failures by a client.
+func ipLoginKey(clientIp string) string {
+	return "ip:" + clientIp
+}
+
+// pendingSecondFactor is a login that passed the password step.
+type pendingSecondFactor struct {
+	username string
+	clientIp string
+	ipKey    string
+	userKey  string
+}
+
+// pendingLogin returns the login waiting for its second factor, writing a
+// response and returning false when there is none or it is locked out.
+func (h *AuthHandler) pendingLogin(w http.ResponseWriter, r *http.Request) (*pendingSecondFactor, bool) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return nil, false
+	}
+	username, ok := h.pending.Get(r, auth.LoginStageSecondFactor)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil, false
+	}
+	clientIp := proxy.ClientIP(r)
+	login := &pendingSecondFactor{username: username, clientIp: clientIp}
+	login.ipKey, login.userKey = loginKeys(username, clientIp)
+	for _, key := range []string{login.ipKey, login.userKey} {
+		if until, locked := h.lockout.LockedUntil(key); locked {
+			slog.Warn("Two-factor attempt while locked out", slog.String("username", username), slog.String("client_ip", clientIp), slog.String("key", key), slog.Time("locked_until", until))
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+			return nil, false
+		}
+	}
+	return login, true
+}
+
+func (h *AuthHandler) secondFactorFailed(login *pendingSecondFactor) {
+	for _, key := range []string{login.ipKey, login.userKey} {
+		if h.lockout.Failure(key) {
+			slog.Warn("Locking out after repeated login failures", slog.String("username", login.username), slog.String("client_ip", login.clientIp), slog.String("key", key))
+		}
+	}
+}
+
+// completeLogin finishes a login once the second factor has been passed and
+// returns where to send the user next.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, login *pendingSecondFactor) (string, bool) {
+	h.lockout.Reset(login.ipKey)
+	h.lockout.Reset(login.userKey)
+	h.pending.End(w)
+	redirect, err := h.startSession(w, r, login.username)
+	if err != nil {
+		slog.Error("Error creating session", slog.String("username", login.username), slog.Any("error", err))
+		return "", false
+	}
+	return redirect, true
+}
+
+// startSession creates the session for an authenticated user and returns
+// where to send them. Static users with a temporary password are sent to
+// change it first, and get their session only once they have.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, username string) (string, error) {
+	if h.authType == config.AuthTypeStatic {
+		user, err := h.staticAuth.Active(r.Context(), username)
+		if err != nil {
+			return "", err
+		}
+		if user.MustChangePassword {
+			h.pending.Begin(w, auth.LoginStagePasswordChange, username)
+			return "/login/password", nil
+		}
+		if err := h.staticAuth.RecordLogin(r.Context(), username); err != nil {
+			return "", err
+		}
+	}
+	if _, err := h.sessions.Create(w, r, username); err != nil {
+		return "", err
+	}
+	return "/admin/home/", nil
+}
+
+// needsSecondFactor reports whether username has to pass the second login step.
+func (h *AuthHandler) needsSecondFactor(ctx context.Context, username string) (bool, error) {
+	needed, err := h.totp.NeedsSecondFactor(ctx, username)
+	if err != nil || needed {
+		return needed, err
+	}
+	return h.passkeys.HasCredentials(ctx, username)
+}
+
+// HandleChangePassword is the last login step for users with a temporary
+// password, who have to choose their own before a session is created.
+func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	username, ok := h.pending.Get(r, auth.LoginStagePasswordChange)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if r.Method == http.MethodGet {
+		h.renderTemplate(w, "login_password.html", PasswordChangeData{})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	password := r.PostForm.Get("password")
+	if password != r.PostForm.Get("confirm") {
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderTemplate(w, "login_password.html", PasswordChangeData{Error: "The passwords do not match."})
+		return
+	}
+	if err := h.staticAuth.ChangePassword(r.Context(), username, password); err != nil {
+		if message, ok := passwordError(err); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			h.renderTemplate(w, "login_password.html", PasswordChangeData{Error: message})
+			return
+		}
+		slog.Error("Error changing password", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Temporary password changed", slog.String("username", username))
+	h.pending.End(w)
+	redirect, err := h.startSession(w, r, username)
+	if err != nil {
+		slog.Error("Error creating session", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
 func (h *AuthHandler) HandleGoogleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	if h.authType != config.AuthTypeGoogle {
 		h.render404(w)
 		return
 	}
-	if err := h.googleAuth.Callback(w, r); err != nil {
+	email, err := h.googleAuth.Callback(w, r)
+	if err != nil {
 		slog.Error("Google OAuth callback error", slog.Any("error", err))
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	// Set session cookie
-	if _, err := h.sessions.Create(w); err != nil {
+	if _, err := h.sessions.Create(w, r, email); err != nil {
 		slog.Error("Error creating session", slog.Any("error", err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
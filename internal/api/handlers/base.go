@@ -3,7 +3,7 @@ package handlers
 import (
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/config"
-	"html/template"
+	"github.com/frodejac/globster/internal/templates"
 	"log/slog"
 	"net/http"
 )
@@ -11,7 +11,7 @@ import (
 type BaseHandler struct {
 	authType  config.AuthType
 	sessions  *auth.SessionService
-	templates *template.Template
+	templates *templates.Set
 }
 
 func (b *BaseHandler) renderTemplate(w http.ResponseWriter, name string, data interface{}) {
@@ -26,3 +26,13 @@ func (b *BaseHandler) render404(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotFound)
 	b.renderTemplate(w, "404.html", nil)
 }
+
+// username returns the user making the request. Errors reading the session
+// are logged, and leave the username empty.
+func (b *BaseHandler) username(r *http.Request) string {
+	username, err := b.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+	}
+	return username
+}
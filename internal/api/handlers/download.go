@@ -1,77 +1,334 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database/links"
 	"github.com/frodejac/globster/internal/downloads"
 	"github.com/frodejac/globster/internal/files"
-	"html/template"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"github.com/frodejac/globster/internal/templates"
+	"golang.org/x/time/rate"
 	"log/slog"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 )
 
 type DownloadHandler struct {
 	BaseHandler
-	downloads *downloads.DownloadService
-	files     *files.FileService
+	tokenGuard
+	linkPasswords
+	downloads   *downloads.DownloadService
+	files       *files.FileService
+	codeLimiter *ratelimit.KeyedLimiter
 }
 
 type DownloadData struct {
 	Directory *files.Directory
 	Token     string
+	// Path is the subfolder being shown, relative to the shared directory
+	Path string
+	// Up links to the parent folder, empty at the top of the share
+	Up string
 }
 
-func NewDownloadHandler(authType config.AuthType, sessions *auth.SessionService, templates *template.Template, downloads *downloads.DownloadService, files *files.FileService) *DownloadHandler {
+type LinkVerifyData struct {
+	Action    string
+	Email     string
+	CodeSent  bool
+	Incorrect bool
+	Throttled bool
+}
+
+func NewDownloadHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, guard *ratelimit.Guard, linkAccess *auth.LinkAccessService, downloads *downloads.DownloadService, files *files.FileService) *DownloadHandler {
 	return &DownloadHandler{
 		BaseHandler: BaseHandler{
 			authType:  authType,
 			sessions:  sessions,
 			templates: templates,
 		},
-		downloads: downloads,
-		files:     files,
+		tokenGuard:    tokenGuard{guard: guard},
+		linkPasswords: linkPasswords{linkAccess: linkAccess},
+		downloads:     downloads,
+		files:         files,
+		// Each client may have a few codes sent per link before having to wait
+		codeLimiter: ratelimit.NewKeyedLimiter(rate.Every(30*time.Second), 3, 10*time.Minute),
 	}
 }
 
 func (h *DownloadHandler) HandleGetDirectory(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
 	token := r.PathValue("token")
-	link, err := h.downloads.ValidateToken(token)
+	link, err := h.downloads.ValidateToken(r.Context(), token)
 	if err != nil {
+		h.recordInvalid(r, err)
+		h.render404(w)
+		return
+	}
+	if h.requirePassword(&h.BaseHandler, w, r, token, downloadPath(token), link.Protected()) {
+		return
+	}
+	if _, ok := h.verifiedRecipient(r, link); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		h.renderTemplate(w, "link_verify.html", LinkVerifyData{Action: verifyPath(token)})
+		return
+	}
+	data := DownloadData{Token: token, Path: r.PathValue("subdir")}
+	dirName, ok := linkSubdir(link.Dir, data.Path)
+	if !ok {
 		h.render404(w)
 		return
 	}
-	directory, err := h.files.ListFiles(link.Dir)
+	if data.Path != "" {
+		data.Up = downloadPath(token)
+		if parent := files.Parent(data.Path); parent != "" {
+			data.Up += url.PathEscape(parent) + "/"
+		}
+	}
+	data.Directory, err = h.files.ListFiles(r.Context(), dirName)
 	if err != nil {
 		h.render404(w)
 		return
 	}
-	h.renderTemplate(w, "download.html", DownloadData{Token: token, Directory: directory})
+	h.renderTemplate(w, "download.html", data)
 }
 
 func (h *DownloadHandler) HandleGetFile(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
 	token := r.PathValue("token")
 	fileName := r.PathValue("file")
-	link, err := h.downloads.ValidateToken(token)
+	link, err := h.downloads.ValidateToken(r.Context(), token)
 	if err != nil {
+		h.recordInvalid(r, err)
 		h.render404(w)
 		return
 	}
-	filePath, fileInfo, err := h.files.GetFilePath(link.Dir, fileName)
-	if err != nil {
-		slog.Error("Failed to get file path", "error", err)
+	if link.Protected() && !h.linkAccess.HasAccess(r, token) {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	email, ok := h.verifiedRecipient(r, link)
+	if !ok {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	// Files in subfolders are named by their path relative to the share
+	subdir, name := path.Split(fileName)
+	dirName, ok := linkSubdir(link.Dir, strings.TrimSuffix(subdir, "/"))
+	if !ok {
 		h.render404(w)
 		return
 	}
 	// Open the file for reading
-	file, err := os.Open(filePath)
+	file, fileInfo, err := h.files.Open(r.Context(), dirName, name)
 	if err != nil {
 		slog.Error("Failed to open file", "error", err)
 		h.render404(w)
 		return
 	}
 	defer file.Close()
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", h.files.DisplayName(fileInfo.Name())))
-	http.ServeContent(w, r, filePath, fileInfo.ModTime(), file)
+	if link.Restricted() {
+		if err := h.downloads.RecordAccess(r.Context(), link, email, fileName, proxy.ClientIP(r)); err != nil {
+			slog.Error("Failed to record download access", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	setFileHeaders(w, h.files, r, dirName, name)
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+}
+
+// HandleChecksums serves a SHA256SUMS manifest of every file in the shared
+// directory and its subfolders, which sha256sum -c can check the downloaded
+// files against.
+func (h *DownloadHandler) HandleChecksums(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
+	token := r.PathValue("token")
+	link, err := h.downloads.ValidateToken(r.Context(), token)
+	if err != nil {
+		h.recordInvalid(r, err)
+		h.render404(w)
+		return
+	}
+	if link.Protected() && !h.linkAccess.HasAccess(r, token) {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	if _, ok := h.verifiedRecipient(r, link); !ok {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	checksums, err := h.files.Checksums(r.Context(), link.Dir)
+	if err != nil {
+		slog.Error("Failed to list checksums", "error", err)
+		h.render404(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", attachment("SHA256SUMS"))
+	for _, checksum := range checksums {
+		// Names with a backslash or newline are escaped like sha256sum does
+		name := checksum.Path
+		prefix := ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+			prefix = "\\"
+		}
+		fmt.Fprintf(w, "%s%s  %s\n", prefix, checksum.Sha256, name)
+	}
+}
+
+func (h *DownloadHandler) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
+	token := r.PathValue("token")
+	link, err := h.downloads.ValidateToken(r.Context(), token)
+	if err != nil {
+		h.recordInvalid(r, err)
+		h.render404(w)
+		return
+	}
+	if !link.Protected() {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	h.unlock(&h.BaseHandler, w, r, token, downloadPath(token), link.PasswordHash)
+}
+
+func (h *DownloadHandler) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
+	token := r.PathValue("token")
+	link, err := h.downloads.ValidateToken(r.Context(), token)
+	if err != nil {
+		h.recordInvalid(r, err)
+		h.render404(w)
+		return
+	}
+	if !link.Restricted() || (link.Protected() && !h.linkAccess.HasAccess(r, token)) {
+		http.Redirect(w, r, downloadPath(token), http.StatusFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(r.PostForm.Get("email")))
+	code := r.PostForm.Get("code")
+	data := LinkVerifyData{Action: verifyPath(token), Email: email}
+	clientIp := proxy.ClientIP(r)
+
+	if code == "" {
+		if !h.codeLimiter.Allow(token + "|" + clientIp) {
+			slog.Warn("Verification code requests throttled", slog.String("client_ip", clientIp), slog.String("dir", link.Dir))
+			data.Throttled = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			h.renderTemplate(w, "link_verify.html", data)
+			return
+		}
+		if err := h.downloads.RequestCode(r.Context(), link, email); err != nil {
+			slog.Error("Failed to send verification code", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data.CodeSent = true
+		h.renderTemplate(w, "link_verify.html", data)
+		return
+	}
+
+	if err := h.downloads.VerifyCode(r.Context(), link, email, code); err != nil {
+		if !errors.Is(err, downloads.ErrInvalidCode) {
+			slog.Error("Failed to verify code", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		slog.Warn("Invalid verification code", slog.String("client_ip", clientIp), slog.String("dir", link.Dir))
+		data.CodeSent = true
+		data.Incorrect = true
+		w.WriteHeader(http.StatusUnauthorized)
+		h.renderTemplate(w, "link_verify.html", data)
+		return
+	}
+	slog.Info("Recipient verified", slog.String("email", email), slog.String("dir", link.Dir), slog.String("client_ip", clientIp))
+	h.linkAccess.GrantRecipient(w, token, email, downloadPath(token))
+	http.Redirect(w, r, downloadPath(token), http.StatusFound)
+}
+
+// verifiedRecipient returns the recipient verified for a restricted link. For
+// links without recipients it always succeeds with an empty address.
+func (h *DownloadHandler) verifiedRecipient(r *http.Request, link *links.DownloadLink) (string, bool) {
+	if !link.Restricted() {
+		return "", true
+	}
+	return h.linkAccess.Recipient(r, link.Token)
+}
+
+// verifyPath is where recipients of a restricted link submit their address and code
+func verifyPath(token string) string {
+	return downloadPath(token) + "verify"
+}
+
+// linkSubdir returns the directory of a subfolder of a shared directory. It
+// reports false if subdir would leave the shared directory.
+func linkSubdir(dir, subdir string) (string, bool) {
+	if subdir == "" {
+		return dir, true
+	}
+	if _, err := files.CleanDir(subdir); err != nil {
+		return "", false
+	}
+	return dir + "/" + subdir, true
+}
+
+// downloadPath is the path of the directory listing for token
+func downloadPath(token string) string {
+	return "/download/" + token + "/"
+}
+
+// attachment returns a Content-Disposition header value that downloads a
+// file as name. Names that are not plain ASCII are encoded as in RFC 2231.
+func attachment(name string) string {
+	if value := mime.FormatMediaType("attachment", map[string]string{"filename": name}); value != "" {
+		return value
+	}
+	return "attachment"
+}
+
+// setFileHeaders sets the name a file is downloaded as, and the checksums it
+// has recorded. Repr-Digest is from RFC 9530, Digest is the older RFC 3230
+// header, which is the only one to also carry MD5. Both describe the whole
+// file, so they are correct for range requests too.
+func setFileHeaders(w http.ResponseWriter, fileService *files.FileService, r *http.Request, directory, filename string) {
+	file, err := fileService.GetFile(r.Context(), directory, filename)
+	if err != nil {
+		w.Header().Set("Content-Disposition", attachment(filename))
+		return
+	}
+	w.Header().Set("Content-Disposition", attachment(file.DownloadName))
+	if sum, err := hex.DecodeString(file.Sha256); err == nil && len(sum) > 0 {
+		encoded := base64.StdEncoding.EncodeToString(sum)
+		w.Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+		digest := "sha-256=" + encoded
+		if sum, err := hex.DecodeString(file.Md5); err == nil && len(sum) > 0 {
+			digest += ",md5=" + base64.StdEncoding.EncodeToString(sum)
+		}
+		w.Header().Set("Digest", digest)
+	}
 }
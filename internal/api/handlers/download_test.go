@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkSubdir(t *testing.T) {
+	tests := []struct {
+		subdir string
+		dir    string
+		ok     bool
+	}{
+		{"", "shared", true},
+		{"a", "shared/a", true},
+		{"a/b", "shared/a/b", true},
+		{"..", "", false},
+		{"a/../..", "", false},
+		{"a/..", "", false},
+		{"./a", "", false},
+		{"/etc", "", false},
+		{"a//b", "", false},
+		{".trash", "", false},
+		{`a\..\..`, "", false},
+	}
+	for _, tt := range tests {
+		dir, ok := linkSubdir("shared", tt.subdir)
+		if ok != tt.ok || dir != tt.dir {
+			t.Errorf("linkSubdir(%q) = %q, %v, want %q, %v", tt.subdir, dir, ok, tt.dir, tt.ok)
+		}
+	}
+}
+
+// TestLinkSubdirEscaped checks that slashes escaped in the URL, which the
+// mux decodes into a single path value, cannot leave the shared directory.
+func TestLinkSubdirEscaped(t *testing.T) {
+	mux := http.NewServeMux()
+	var subdir string
+	var dir string
+	var ok bool
+	mux.HandleFunc("GET /download/{token}/{subdir}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		subdir = r.PathValue("subdir")
+		dir, ok = linkSubdir("shared", subdir)
+	})
+	for _, target := range []string{
+		"/download/token/a%2F..%2F../",
+		"/download/token/..%2F..%2Fetc/",
+		"/download/token/%2E%2E/",
+	} {
+		subdir, dir, ok = "", "", false
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		if subdir == "" {
+			t.Errorf("%s: not routed to the directory listing", target)
+		}
+		if ok {
+			t.Errorf("%s: linkSubdir(%q) = %q, want it rejected", target, subdir, dir)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/frodejac/globster/internal/database/trash"
+	"github.com/frodejac/globster/internal/files"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+var errLegalHold = errors.New("directory is on legal hold")
+
+// FileAction describes a file operation waiting for confirmation.
+type FileAction struct {
+	Action    string
+	Directory string
+	File      *files.File
+	NewName   string
+	Target    string
+	Error     string
+}
+
+type fileResponse struct {
+	Directory   string `json:"directory"`
+	Filename    string `json:"filename"`
+	DisplayName string `json:"displayName"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (h *AdminHandler) HandleManageFile(w http.ResponseWriter, r *http.Request) {
+	dirName := r.PathValue("directory")
+	file, err := h.files.GetFile(r.Context(), dirName, r.PathValue("filename"))
+	if err != nil {
+		h.fileError(w, err)
+		return
+	}
+	directories, err := h.files.ListDirectories(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch directories", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	policy, err := h.policies.Get(r.Context(), files.TopLevel(dirName))
+	if err != nil {
+		slog.Error("Failed to fetch directory policy", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderTemplate(w, "admin_file.html", AdminData{
+		Directory:   &files.Directory{Name: dirName},
+		Directories: directories,
+		File:        file,
+		Policy:      policy,
+	})
+}
+
+// HandleDeleteFile, HandleRenameFile and HandleMoveFile show a confirmation
+// page first, and only change the file when the form is confirmed.
+func (h *AdminHandler) HandleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	h.handleFileAction(w, r, "delete")
+}
+
+func (h *AdminHandler) HandleRenameFile(w http.ResponseWriter, r *http.Request) {
+	h.handleFileAction(w, r, "rename")
+}
+
+func (h *AdminHandler) HandleMoveFile(w http.ResponseWriter, r *http.Request) {
+	h.handleFileAction(w, r, "move")
+}
+
+func (h *AdminHandler) handleFileAction(w http.ResponseWriter, r *http.Request, action string) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	dirName := r.PathValue("directory")
+	file, err := h.files.GetFile(r.Context(), dirName, r.PathValue("filename"))
+	if err != nil {
+		h.fileError(w, err)
+		return
+	}
+	fileAction := &FileAction{
+		Action:    action,
+		Directory: dirName,
+		File:      file,
+		NewName:   r.FormValue("name"),
+		Target:    r.FormValue("target"),
+	}
+	if r.FormValue("confirm") != "yes" {
+		if action != "rename" {
+			if err := h.checkLegalHold(r.Context(), dirName); err != nil {
+				fileAction.Error = err.Error()
+			}
+		}
+		h.renderTemplate(w, "admin_file_confirm.html", AdminData{FileAction: fileAction})
+		return
+	}
+
+	redirect := adminDirPath(dirName)
+	switch action {
+	case "delete":
+		err = h.deleteFile(r, dirName, file.Name)
+	case "rename":
+		_, err = h.renameFile(r, dirName, file.Name, fileAction.NewName)
+	case "move":
+		err = h.moveFile(r, dirName, file.Name, fileAction.Target)
+		redirect = adminDirPath(fileAction.Target)
+	}
+	if err != nil {
+		h.fileError(w, err)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// HandleDeleteDirectory moves a directory to the trash after confirmation.
+func (h *AdminHandler) HandleDeleteDirectory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	dirName := r.PathValue("directory")
+	if r.FormValue("confirm") != "yes" {
+		fileAction := &FileAction{Action: "delete", Directory: dirName}
+		if err := h.checkLegalHold(r.Context(), dirName); err != nil {
+			fileAction.Error = err.Error()
+		}
+		h.renderTemplate(w, "admin_file_confirm.html", AdminData{FileAction: fileAction})
+		return
+	}
+	if err := h.deleteDirectory(r, dirName); err != nil {
+		h.fileError(w, err)
+		return
+	}
+	redirect := "/admin/files/"
+	if parent := files.Parent(dirName); parent != "" {
+		redirect = adminDirPath(parent)
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+func (h *AdminHandler) HandleApiDeleteDirectory(w http.ResponseWriter, r *http.Request) {
+	if err := h.deleteDirectory(r, r.PathValue("directory")); err != nil {
+		h.apiFileError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) HandleApiDeleteFile(w http.ResponseWriter, r *http.Request) {
+	if err := h.deleteFile(r, r.PathValue("directory"), r.PathValue("filename")); err != nil {
+		h.apiFileError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) HandleApiRenameFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJson(w, http.StatusBadRequest, apiError{Error: "invalid request body"})
+		return
+	}
+	dirName := r.PathValue("directory")
+	newName, err := h.renameFile(r, dirName, r.PathValue("filename"), req.Name)
+	if err != nil {
+		h.apiFileError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, fileResponse{Directory: dirName, Filename: newName, DisplayName: h.files.DisplayName(r.Context(), dirName, newName)})
+}
+
+func (h *AdminHandler) HandleApiMoveFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Directory string `json:"directory"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJson(w, http.StatusBadRequest, apiError{Error: "invalid request body"})
+		return
+	}
+	filename := r.PathValue("filename")
+	if err := h.moveFile(r, r.PathValue("directory"), filename, req.Directory); err != nil {
+		h.apiFileError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, fileResponse{Directory: req.Directory, Filename: filename, DisplayName: h.files.DisplayName(r.Context(), req.Directory, filename)})
+}
+
+func (h *AdminHandler) deleteFile(r *http.Request, directory, filename string) error {
+	if err := h.checkLegalHold(r.Context(), directory); err != nil {
+		return err
+	}
+	username := h.username(r)
+	if err := h.trash.DeleteFile(r.Context(), directory, filename, username); err != nil {
+		return err
+	}
+	slog.Info("File moved to trash",
+		slog.String("directory", directory),
+		slog.String("filename", filename),
+		slog.String("username", username),
+	)
+	return nil
+}
+
+func (h *AdminHandler) deleteDirectory(r *http.Request, directory string) error {
+	if err := h.checkLegalHold(r.Context(), directory); err != nil {
+		return err
+	}
+	username := h.username(r)
+	if err := h.trash.DeleteDirectory(r.Context(), directory, username); err != nil {
+		return err
+	}
+	slog.Info("Directory moved to trash",
+		slog.String("directory", directory),
+		slog.String("username", username),
+	)
+	return nil
+}
+
+func (h *AdminHandler) renameFile(r *http.Request, directory, filename, name string) (string, error) {
+	newName, err := h.files.Rename(r.Context(), directory, filename, name)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("File renamed",
+		slog.String("directory", directory),
+		slog.String("filename", filename),
+		slog.String("new_filename", newName),
+		slog.String("username", h.username(r)),
+	)
+	return newName, nil
+}
+
+// moveFile moves a file to another directory. Moving a file out of a
+// directory on legal hold is refused like a delete.
+func (h *AdminHandler) moveFile(r *http.Request, directory, filename, target string) error {
+	if err := h.checkLegalHold(r.Context(), directory); err != nil {
+		return err
+	}
+	if err := h.files.Move(r.Context(), directory, filename, target); err != nil {
+		return err
+	}
+	slog.Info("File moved",
+		slog.String("directory", directory),
+		slog.String("filename", filename),
+		slog.String("target", target),
+		slog.String("username", h.username(r)),
+	)
+	return nil
+}
+
+// checkLegalHold fails if the top level directory of directory is on legal
+// hold, which covers all of its subfolders.
+func (h *AdminHandler) checkLegalHold(ctx context.Context, directory string) error {
+	policy, err := h.policies.Get(ctx, files.TopLevel(directory))
+	if err != nil {
+		return err
+	}
+	if policy.LegalHold {
+		return errLegalHold
+	}
+	return nil
+}
+
+// adminDirPath returns the admin page of a directory. Nested directories
+// are escaped into a single path segment.
+func adminDirPath(directory string) string {
+	return "/admin/files/" + url.PathEscape(directory) + "/"
+}
+
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, files.ErrNotFound), errors.Is(err, trash.ErrItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, files.ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, files.ErrExists), errors.Is(err, errLegalHold):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *AdminHandler) fileError(w http.ResponseWriter, err error) {
+	status := fileErrorStatus(err)
+	switch status {
+	case http.StatusNotFound:
+		h.render404(w)
+	case http.StatusInternalServerError:
+		slog.Error("File operation failed", "error", err)
+		http.Error(w, "Internal Server Error", status)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
+
+func (h *AdminHandler) apiFileError(w http.ResponseWriter, err error) {
+	status := fileErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		slog.Error("File operation failed", "error", err)
+		message = "internal server error"
+	}
+	writeJson(w, status, apiError{Error: message})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/frodejac/globster/internal/health"
+	"log/slog"
+	"net/http"
+)
+
+type HealthHandler struct {
+	health *health.HealthService
+}
+
+func NewHealthHandler(health *health.HealthService) *HealthHandler {
+	return &HealthHandler{health: health}
+}
+
+// HandleLiveness reports that the process is up and serving requests.
+func (h *HealthHandler) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, &health.Report{Status: health.StatusOK})
+}
+
+// HandleReadiness runs the dependency checks and reports 503 if any fail.
+func (h *HealthHandler) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := h.health.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		slog.Warn("Readiness check failed", slog.Any("checks", report.Checks))
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, report)
+}
+
+func writeJson(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("Failed to encode JSON response", "error", err)
+	}
+}
@@ -3,28 +3,31 @@ package handlers
 import (
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/config"
-	"html/template"
+	"github.com/frodejac/globster/internal/templates"
 	"log/slog"
 	"net/http"
 )
 
 type HomeHandler struct {
 	BaseHandler
+	passkeys bool
 }
 
 type HomeData struct {
 	GoogleAuth bool
 	StaticAuth bool
+	Passkeys   bool
 	Incorrect  bool
 }
 
-func NewHomeHandler(authType config.AuthType, sessions *auth.SessionService, templates *template.Template) *HomeHandler {
+func NewHomeHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, passkeys bool) *HomeHandler {
 	home := &HomeHandler{
 		BaseHandler: BaseHandler{
 			authType:  authType,
 			sessions:  sessions,
 			templates: templates,
 		},
+		passkeys: passkeys,
 	}
 	return home
 }
@@ -51,6 +54,7 @@ func (h *HomeHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 	data := HomeData{
 		GoogleAuth: h.authType == config.AuthTypeGoogle,
 		StaticAuth: h.authType == config.AuthTypeStatic,
+		Passkeys:   h.passkeys,
 		Incorrect:  state != "",
 	}
 	// Render the home page
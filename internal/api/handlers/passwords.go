@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/proxy"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type LinkPasswordData struct {
+	Action    string
+	Incorrect bool
+	Locked    bool
+}
+
+// linkPasswords handles the passphrase prompt in front of protected links
+type linkPasswords struct {
+	linkAccess *auth.LinkAccessService
+}
+
+// requirePassword renders the passphrase prompt and returns true if the link
+// is protected and the request doesn't carry an access cookie for it.
+func (p *linkPasswords) requirePassword(b *BaseHandler, w http.ResponseWriter, r *http.Request, token, linkPath string, protected bool) bool {
+	if !protected || p.linkAccess.HasAccess(r, token) {
+		return false
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	b.renderTemplate(w, "link_password.html", LinkPasswordData{Action: unlockPath(linkPath)})
+	return true
+}
+
+// unlock checks the submitted passphrase and redirects back to the link on success.
+func (p *linkPasswords) unlock(b *BaseHandler, w http.ResponseWriter, r *http.Request, token, linkPath, passwordHash string) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	data := LinkPasswordData{Action: unlockPath(linkPath)}
+	clientIp := proxy.ClientIP(r)
+	err := p.linkAccess.Unlock(w, token, clientIp, linkPath, passwordHash, r.PostForm.Get("password"))
+	switch {
+	case err == nil:
+		http.Redirect(w, r, linkPath, http.StatusFound)
+		return
+	case errors.Is(err, auth.ErrLinkPasswordLocked):
+		slog.Warn("Link password locked out", slog.String("client_ip", clientIp), slog.String("path", r.URL.Path))
+		data.Locked = true
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		slog.Warn("Incorrect link password", slog.String("client_ip", clientIp), slog.String("path", r.URL.Path))
+		data.Incorrect = true
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	b.renderTemplate(w, "link_password.html", data)
+}
+
+// unlockPath is where the passphrase form for the link at linkPath is posted.
+// It lies below linkPath so the access cookie, scoped to linkPath, covers it.
+func unlockPath(linkPath string) string {
+	return strings.TrimSuffix(linkPath, "/") + "/unlock"
+}
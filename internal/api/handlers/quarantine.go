@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database/quarantine"
+	"github.com/frodejac/globster/internal/scanner"
+	"github.com/frodejac/globster/internal/templates"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type QuarantineHandler struct {
+	BaseHandler
+	scanner *scanner.Service
+}
+
+type QuarantineData struct {
+	Items []quarantine.Item
+	// Enabled is false when uploads are not scanned
+	Enabled bool
+	Confirm *quarantine.Item
+	Message string
+}
+
+func NewQuarantineHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, scanner *scanner.Service) *QuarantineHandler {
+	return &QuarantineHandler{
+		BaseHandler: BaseHandler{
+			authType:  authType,
+			sessions:  sessions,
+			templates: templates,
+		},
+		scanner: scanner,
+	}
+}
+
+func (h *QuarantineHandler) HandleListQuarantine(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, &QuarantineData{})
+}
+
+// HandleDelete permanently deletes a quarantined upload after confirmation.
+func (h *QuarantineHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.render404(w)
+		return
+	}
+	if r.FormValue("confirm") != "yes" {
+		h.renderConfirm(w, r, id)
+		return
+	}
+	item, err := h.scanner.Delete(r.Context(), id)
+	if errors.Is(err, quarantine.ErrItemNotFound) {
+		h.render404(w)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to delete quarantined upload", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Quarantined upload deleted",
+		slog.String("directory", item.Directory),
+		slog.String("filename", item.OriginalName),
+		slog.String("signature", item.Signature),
+		slog.String("username", h.username(r)),
+	)
+	h.render(w, r, &QuarantineData{Message: "Permanently deleted " + item.OriginalName + "."})
+}
+
+func (h *QuarantineHandler) renderConfirm(w http.ResponseWriter, r *http.Request, id int64) {
+	items, err := h.scanner.List(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch quarantined uploads", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for i := range items {
+		if items[i].Id == id {
+			h.render(w, r, &QuarantineData{Confirm: &items[i]})
+			return
+		}
+	}
+	h.render404(w)
+}
+
+func (h *QuarantineHandler) render(w http.ResponseWriter, r *http.Request, data *QuarantineData) {
+	items, err := h.scanner.List(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch quarantined uploads", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.Items = items
+	data.Enabled = h.scanner.Enabled()
+	h.renderTemplate(w, "admin_quarantine.html", data)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/templates"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type SessionsHandler struct {
+	BaseHandler
+}
+
+type SessionsData struct {
+	Username string
+	Sessions []SessionRow
+}
+
+type SessionRow struct {
+	Handle     string
+	Username   string
+	Device     string
+	ClientIp   string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	Current    bool
+}
+
+func NewSessionsHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set) *SessionsHandler {
+	return &SessionsHandler{
+		BaseHandler: BaseHandler{
+			authType:  authType,
+			sessions:  sessions,
+			templates: templates,
+		},
+	}
+}
+
+func (h *SessionsHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	currentId, err := h.sessions.CurrentId(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	list, err := h.sessions.List(r.Context())
+	if err != nil {
+		slog.Error("Error listing sessions", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rows := make([]SessionRow, 0, len(list))
+	for _, session := range list {
+		rows = append(rows, SessionRow{
+			Handle:     auth.SessionHandle(session.Id),
+			Username:   session.Username,
+			Device:     describeUserAgent(session.UserAgent),
+			ClientIp:   session.ClientIp,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.Id == currentId,
+		})
+	}
+	h.renderTemplate(w, "admin_sessions.html", SessionsData{Username: username, Sessions: rows})
+}
+
+func (h *SessionsHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	handle := r.PostFormValue("session")
+	if handle == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	currentId, err := h.sessions.CurrentId(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Revoking the current session is the same as logging out
+	if handle == auth.SessionHandle(currentId) {
+		if err := h.sessions.Destroy(w, r); err != nil {
+			slog.Error("Error revoking session", slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Session revoked", slog.String("by", username))
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if err := h.sessions.Revoke(r.Context(), handle); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Redirect(w, r, "/admin/sessions/", http.StatusFound)
+			return
+		}
+		slog.Error("Error revoking session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Session revoked", slog.String("by", username))
+	http.Redirect(w, r, "/admin/sessions/", http.StatusFound)
+}
+
+// HandleLogoutEverywhere ends every session of the current user, including
+// the one making the request.
+func (h *SessionsHandler) HandleLogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	username, err := h.sessions.Username(r)
+	if err != nil {
+		slog.Error("Error reading session", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if username == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := h.sessions.DestroyAll(r.Context(), username); err != nil {
+		slog.Error("Error ending sessions", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.sessions.Destroy(w, r); err != nil {
+		slog.Error("Error clearing session cookie", slog.Any("error", err))
+	}
+	slog.Info("Logged out everywhere", slog.String("username", username))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// describeUserAgent gives a short browser and platform description, such as
+// "Firefox on Linux", for telling sessions apart.
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+	browsers := []struct{ token, name string }{
+		// Order matters, most user agents also claim to be Safari or Chrome
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	platforms := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+	browser, platform := "", ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	// Unrecognised clients are shown by their product token
+	product, _, _ := strings.Cut(userAgent, " ")
+	return product
+}
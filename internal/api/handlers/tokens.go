@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// tokenGuard slows down enumeration of upload and download link tokens by
+// banning clients after repeated lookups of tokens that don't exist
+type tokenGuard struct {
+	guard *ratelimit.Guard
+}
+
+// rejectBanned responds with 429 and returns true if the client is banned.
+func (t *tokenGuard) rejectBanned(w http.ResponseWriter, r *http.Request) bool {
+	until, banned := t.guard.BannedUntil(proxy.ClientIP(r))
+	if !banned {
+		return false
+	}
+	slog.Warn("Rejected token lookup from banned client", slog.String("client_ip", proxy.ClientIP(r)), slog.String("path", r.URL.Path), slog.Time("banned_until", until))
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// recordInvalid counts a failed token validation against the client. Expired
+// and exhausted links don't count, since their holders are legitimate.
+func (t *tokenGuard) recordInvalid(r *http.Request, err error) {
+	if !errors.Is(err, links.ErrLinkNotFound) {
+		slog.Debug("Invalid token", "path", r.URL.Path, "error", err)
+		return
+	}
+	clientIp := proxy.ClientIP(r)
+	count, banned := t.guard.Failure(clientIp)
+	slog.Warn("Unknown link token", slog.String("client_ip", clientIp), slog.String("path", r.URL.Path), slog.Int("invalid_count", count))
+	if banned {
+		slog.Warn("Banning client after repeated unknown link tokens", slog.String("client_ip", clientIp), slog.Int("invalid_count", count))
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database/trash"
+	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/janitor"
+	"github.com/frodejac/globster/internal/templates"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TrashHandler struct {
+	BaseHandler
+	trash   *files.TrashService
+	janitor *janitor.Janitor
+}
+
+type TrashData struct {
+	Items   []TrashRow
+	Confirm *trash.Item
+	Message string
+	Error   string
+}
+
+type TrashRow struct {
+	trash.Item
+	// PurgeAt is when the item is purged automatically, if ever
+	PurgeAt *time.Time
+}
+
+func NewTrashHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, trash *files.TrashService, janitor *janitor.Janitor) *TrashHandler {
+	return &TrashHandler{
+		BaseHandler: BaseHandler{
+			authType:  authType,
+			sessions:  sessions,
+			templates: templates,
+		},
+		trash:   trash,
+		janitor: janitor,
+	}
+}
+
+func (h *TrashHandler) HandleListTrash(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, &TrashData{})
+}
+
+func (h *TrashHandler) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.render404(w)
+		return
+	}
+	item, err := h.trash.Restore(r.Context(), id)
+	switch {
+	case errors.Is(err, trash.ErrItemNotFound):
+		h.render404(w)
+	case errors.Is(err, files.ErrExists):
+		w.WriteHeader(http.StatusConflict)
+		h.render(w, r, &TrashData{Error: "Cannot restore, something else now exists at the original path."})
+	case err != nil:
+		slog.Error("Failed to restore trash item", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Trash item restored",
+			slog.String("kind", item.Kind),
+			slog.String("directory", item.Directory),
+			slog.String("filename", item.Filename),
+			slog.String("username", h.username(r)),
+		)
+		h.render(w, r, &TrashData{Message: "Restored " + itemPath(item) + "."})
+	}
+}
+
+// HandlePurge permanently deletes an item after confirmation.
+func (h *TrashHandler) HandlePurge(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.render404(w)
+		return
+	}
+	if r.FormValue("confirm") != "yes" {
+		h.renderConfirm(w, r, id)
+		return
+	}
+	item, err := h.trash.Purge(r.Context(), id)
+	if errors.Is(err, trash.ErrItemNotFound) {
+		h.render404(w)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to purge trash item", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Trash item purged",
+		slog.String("kind", item.Kind),
+		slog.String("directory", item.Directory),
+		slog.String("filename", item.Filename),
+		slog.String("username", h.username(r)),
+	)
+	h.render(w, r, &TrashData{Message: "Permanently deleted " + itemPath(item) + "."})
+}
+
+func (h *TrashHandler) renderConfirm(w http.ResponseWriter, r *http.Request, id int64) {
+	items, err := h.trash.List(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch trash items", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for i := range items {
+		if items[i].Id == id {
+			h.render(w, r, &TrashData{Confirm: &items[i]})
+			return
+		}
+	}
+	h.render404(w)
+}
+
+func (h *TrashHandler) render(w http.ResponseWriter, r *http.Request, data *TrashData) {
+	items, err := h.trash.List(r.Context())
+	if err != nil {
+		slog.Error("Failed to fetch trash items", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	retention := h.janitor.TrashRetention()
+	data.Items = make([]TrashRow, 0, len(items))
+	for _, item := range items {
+		row := TrashRow{Item: item}
+		if retention > 0 {
+			purgeAt := item.DeletedAt.Add(retention)
+			row.PurgeAt = &purgeAt
+		}
+		data.Items = append(data.Items, row)
+	}
+	h.renderTemplate(w, "admin_trash.html", data)
+}
+
+func itemPath(item *trash.Item) string {
+	if item.Kind == trash.KindDirectory {
+		return "./" + item.Directory
+	}
+	return "./" + item.Directory + "/" + item.Filename
+}
@@ -3,14 +3,17 @@ package handlers
 import (
 	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"github.com/frodejac/globster/internal/templates"
 	"github.com/frodejac/globster/internal/uploads"
-	"html/template"
 	"log/slog"
 	"net/http"
 )
 
 type UploadHandler struct {
 	BaseHandler
+	tokenGuard
+	linkPasswords
 	uploads *uploads.UploadService
 }
 
@@ -18,35 +21,51 @@ type UploadData struct {
 	Token string
 }
 
-func NewUploadHandler(authType config.AuthType, sessions *auth.SessionService, templates *template.Template, uploads *uploads.UploadService) *UploadHandler {
+func NewUploadHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, guard *ratelimit.Guard, linkAccess *auth.LinkAccessService, uploads *uploads.UploadService) *UploadHandler {
 	return &UploadHandler{
 		BaseHandler: BaseHandler{
 			authType:  authType,
 			sessions:  sessions,
 			templates: templates,
 		},
-		uploads: uploads,
+		tokenGuard:    tokenGuard{guard: guard},
+		linkPasswords: linkPasswords{linkAccess: linkAccess},
+		uploads:       uploads,
 	}
 }
 
 func (h *UploadHandler) HandleGetUpload(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
 	token := r.PathValue("token")
-	if _, err := h.uploads.ValidateToken(token); err != nil {
-		slog.Debug("Invalid token", "token", token)
+	link, err := h.uploads.ValidateToken(r.Context(), token)
+	if err != nil {
+		h.recordInvalid(r, err)
 		h.render404(w)
 		return
 	}
+	if h.requirePassword(&h.BaseHandler, w, r, token, uploadPath(token), link.Protected()) {
+		return
+	}
 	h.renderTemplate(w, "upload.html", UploadData{Token: token})
 }
 
 func (h *UploadHandler) HandlePostUpload(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
 	token := r.PathValue("token")
-	link, err := h.uploads.ValidateToken(token)
+	link, err := h.uploads.ValidateToken(r.Context(), token)
 	if err != nil {
-		slog.Debug("Invalid token", "token", token)
+		h.recordInvalid(r, err)
 		h.render404(w)
 		return
 	}
+	if link.Protected() && !h.linkAccess.HasAccess(r, token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 	if err := h.uploads.Upload(r, link); err != nil {
 		slog.Error("Upload error", "error", err)
 		http.Redirect(w, r, "/upload/error", http.StatusFound)
@@ -55,6 +74,24 @@ func (h *UploadHandler) HandlePostUpload(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/upload/success", http.StatusFound)
 }
 
+func (h *UploadHandler) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	if h.rejectBanned(w, r) {
+		return
+	}
+	token := r.PathValue("token")
+	link, err := h.uploads.ValidateToken(r.Context(), token)
+	if err != nil {
+		h.recordInvalid(r, err)
+		h.render404(w)
+		return
+	}
+	if !link.Protected() {
+		http.Redirect(w, r, uploadPath(token), http.StatusFound)
+		return
+	}
+	h.unlock(&h.BaseHandler, w, r, token, uploadPath(token), link.PasswordHash)
+}
+
 func (h *UploadHandler) HandleSuccess(w http.ResponseWriter, r *http.Request) {
 	h.renderTemplate(w, "upload_success.html", nil)
 }
@@ -62,3 +99,8 @@ func (h *UploadHandler) HandleSuccess(w http.ResponseWriter, r *http.Request) {
 func (h *UploadHandler) HandleError(w http.ResponseWriter, r *http.Request) {
 	h.renderTemplate(w, "upload_error.html", nil)
 }
+
+// uploadPath is the path of the upload page for token
+func uploadPath(token string) string {
+	return "/upload/" + token
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/auth"
+	"github.com/frodejac/globster/internal/auth/static"
+	"github.com/frodejac/globster/internal/config"
+	"github.com/frodejac/globster/internal/database/users"
+	"github.com/frodejac/globster/internal/templates"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type UsersHandler struct {
+	BaseHandler
+	staticAuth *static.Auth
+}
+
+type UsersData struct {
+	Static   bool
+	Username string
+	Users    []users.User
+	Message  string
+	Error    string
+}
+
+func NewUsersHandler(authType config.AuthType, sessions *auth.SessionService, templates *templates.Set, staticAuth *static.Auth) *UsersHandler {
+	return &UsersHandler{
+		BaseHandler: BaseHandler{
+			authType:  authType,
+			sessions:  sessions,
+			templates: templates,
+		},
+		staticAuth: staticAuth,
+	}
+}
+
+func (h *UsersHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.renderTemplate(w, "admin_users.html", UsersData{})
+		return
+	}
+	h.renderUsers(w, r, http.StatusOK, "", "")
+}
+
+func (h *UsersHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	mustChange := r.PostForm.Get("must_change") == "on"
+	if err := h.staticAuth.AddUser(r.Context(), username, r.PostForm.Get("password"), mustChange); err != nil {
+		if errors.Is(err, users.ErrUserExists) {
+			h.renderUsers(w, r, http.StatusBadRequest, "", "A user with that name already exists.")
+			return
+		}
+		if errors.Is(err, static.ErrInvalidUsername) {
+			h.renderUsers(w, r, http.StatusBadRequest, "", "Usernames must not be empty or contain whitespace.")
+			return
+		}
+		if message, ok := passwordError(err); ok {
+			h.renderUsers(w, r, http.StatusBadRequest, "", message)
+			return
+		}
+		slog.Error("Error creating user", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("User created", slog.String("username", username), slog.String("by", h.username(r)))
+	http.Redirect(w, r, "/admin/users/", http.StatusFound)
+}
+
+func (h *UsersHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	username := r.PathValue("username")
+	mustChange := r.PostForm.Get("must_change") == "on"
+	if err := h.staticAuth.SetPassword(r.Context(), username, r.PostForm.Get("password"), mustChange); err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			h.render404(w)
+			return
+		}
+		if message, ok := passwordError(err); ok {
+			h.renderUsers(w, r, http.StatusBadRequest, "", message)
+			return
+		}
+		slog.Error("Error resetting password", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// A reset password should lock out whoever knew the old one
+	if err := h.sessions.DestroyAll(r.Context(), username); err != nil {
+		slog.Error("Error ending sessions", slog.String("username", username), slog.Any("error", err))
+	}
+	slog.Info("Password reset", slog.String("username", username), slog.String("by", h.username(r)))
+	h.renderUsers(w, r, http.StatusOK, "Password for "+username+" was reset.", "")
+}
+
+func (h *UsersHandler) HandleDisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+func (h *UsersHandler) HandleEnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *UsersHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	if h.authType != config.AuthTypeStatic {
+		h.render404(w)
+		return
+	}
+	username := r.PathValue("username")
+	if disabled && username == h.username(r) {
+		h.renderUsers(w, r, http.StatusBadRequest, "", "You cannot disable your own account.")
+		return
+	}
+	if err := h.staticAuth.SetDisabled(r.Context(), username, disabled); err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			h.render404(w)
+			return
+		}
+		slog.Error("Error updating user", slog.String("username", username), slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if disabled {
+		if err := h.sessions.DestroyAll(r.Context(), username); err != nil {
+			slog.Error("Error ending sessions", slog.String("username", username), slog.Any("error", err))
+		}
+	}
+	slog.Info("User updated", slog.String("username", username), slog.Bool("disabled", disabled), slog.String("by", h.username(r)))
+	http.Redirect(w, r, "/admin/users/", http.StatusFound)
+}
+
+func (h *UsersHandler) renderUsers(w http.ResponseWriter, r *http.Request, status int, message, errorMessage string) {
+	list, err := h.staticAuth.ListUsers(r.Context())
+	if err != nil {
+		slog.Error("Error listing users", slog.Any("error", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	h.renderTemplate(w, "admin_users.html", UsersData{
+		Static:   true,
+		Username: h.username(r),
+		Users:    list,
+		Message:  message,
+		Error:    errorMessage,
+	})
+}
+
+// passwordError returns the message to show for passwords that were rejected
+// by the policy, and false for any other error.
+func passwordError(err error) (string, bool) {
+	switch {
+	case errors.Is(err, static.ErrPasswordReused):
+		return "The new password must differ from the current one.", true
+	case errors.Is(err, static.ErrPasswordPolicy):
+		_, problems, _ := strings.Cut(err.Error(), ": ")
+		return "The password " + strings.ReplaceAll(problems, "; ", ", ") + ".", true
+	}
+	return "", false
+}
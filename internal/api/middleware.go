@@ -2,9 +2,17 @@ package api
 
 import (
 	"context"
+	"github.com/frodejac/globster/internal/proxy"
 	"github.com/frodejac/globster/internal/random"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -23,6 +31,84 @@ func RequestIdMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ProxyHeadersMiddleware trusts X-Forwarded-For/Forwarded and the related
+// proto and host headers, but only on requests arriving from a trusted proxy.
+// The client address replaces r.RemoteAddr so logs and rate limits see it.
+func ProxyHeadersMiddleware(resolver *proxy.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fwd := resolver.Resolve(r); fwd != nil {
+				r = r.WithContext(proxy.WithForwarded(r.Context(), fwd))
+				r.RemoteAddr = fwd.ClientIP.String()
+				if fwd.Host != "" {
+					r.Host = fwd.Host
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder captures the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// TracingMiddleware starts a server span for each request, continuing any
+// W3C trace context sent by an upstream proxy. Must run after RequestIdMiddleware.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.StartServer(
+			ctx,
+			r.Method,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("client.address", r.RemoteAddr),
+			attribute.String("user_agent.original", r.UserAgent()),
+			attribute.String("globster.request_id", requestId(r)),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		// The mux sets the matched pattern on the request once routed
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
 // LoggingMiddleWare logs the incoming requests
 func LoggingMiddleWare(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -33,6 +119,7 @@ func LoggingMiddleWare(next http.Handler) http.Handler {
 			slog.String("path", r.URL.Path),
 			slog.String("remote_addr", r.RemoteAddr),
 			slog.Any("request_id", r.Context().Value(RequestIDKey{})),
+			slog.String("trace_id", traceId(r)),
 		)
 		next.ServeHTTP(w, r)
 		slog.Info(
@@ -41,6 +128,7 @@ func LoggingMiddleWare(next http.Handler) http.Handler {
 			slog.String("path", r.URL.Path),
 			slog.String("remote_addr", r.RemoteAddr),
 			slog.Any("request_id", r.Context().Value(RequestIDKey{})),
+			slog.String("trace_id", traceId(r)),
 			slog.Duration("duration", time.Since(t0)),
 		)
 	})
@@ -52,7 +140,7 @@ func SecurityHeadersMiddleware(useHsts bool) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Content Security Policy
 			w.Header().Set("Content-Security-Policy",
-				"default-src 'self'; script-src 'self'; object-src 'none'; img-src 'self'; style-src 'self'; connect-src 'self'; font-src 'self'; frame-ancestors 'none'; form-action 'self'; base-uri 'self';")
+				"default-src 'self'; script-src 'self'; object-src 'none'; img-src 'self' data:; style-src 'self'; connect-src 'self'; font-src 'self'; frame-ancestors 'none'; form-action 'self'; base-uri 'self';")
 
 			// Prevent browsers from MIME-sniffing
 			w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -78,3 +166,64 @@ func SecurityHeadersMiddleware(useHsts bool) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDKey{}).(string)
+	return id
+}
+
+func traceId(r *http.Request) string {
+	spanCtx := trace.SpanContextFromContext(r.Context())
+	if !spanCtx.HasTraceID() {
+		return ""
+	}
+	return spanCtx.TraceID().String()
+}
+
+// DeadlineMiddleware overrides the server-wide read and write timeouts for a
+// single route, so large uploads and downloads aren't cut off by limits sized
+// for ordinary page loads. A zero duration leaves the server default in place.
+func DeadlineMiddleware(read, write time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			if read > 0 {
+				if err := rc.SetReadDeadline(time.Now().Add(read)); err != nil {
+					slog.Warn("Failed to set read deadline", slog.Any("error", err), slog.String("request_id", requestId(r)))
+				}
+			}
+			if write > 0 {
+				if err := rc.SetWriteDeadline(time.Now().Add(write)); err != nil {
+					slog.Warn("Failed to set write deadline", slog.Any("error", err), slog.String("request_id", requestId(r)))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireClientCertMiddleware rejects requests that did not present a client
+// certificate verified against the configured CA pool
+func RequireClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			slog.Warn(
+				"Missing or invalid client certificate",
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("request_id", requestId(r)),
+			)
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HTTPSRedirectHandler redirects plain HTTP requests to the same path under baseUrl
+func HTTPSRedirectHandler(baseUrl string) http.Handler {
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, baseUrl+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
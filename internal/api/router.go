@@ -7,28 +7,56 @@ import (
 	"github.com/frodejac/globster/internal/auth/static"
 	"github.com/frodejac/globster/internal/config"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/policies"
 	"github.com/frodejac/globster/internal/downloads"
 	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/health"
+	"github.com/frodejac/globster/internal/janitor"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"github.com/frodejac/globster/internal/scanner"
+	"github.com/frodejac/globster/internal/templates"
 	"github.com/frodejac/globster/internal/uploads"
 	"golang.org/x/time/rate"
-	"html/template"
 	"net/http"
+	"time"
 )
 
 type Config struct {
-	AuthType            config.AuthType
-	StaticAuthRateLimit rate.Limit
-	BaseUrl             string
-	StaticPath          string
-	UploadPath          string
+	AuthType             config.AuthType
+	StaticAuthRateLimit  rate.Limit
+	StaticAuthRateBurst  int
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	TokenMaxFailures     int
+	TokenBanDuration     time.Duration
+	LinkAccess           *auth.LinkAccessConfig
+	BaseUrl              string
+	StaticPath           string
+	UploadPath           string
+	UploadTimeout        time.Duration
+	DownloadTimeout      time.Duration
+	RequireClientCert    bool
+	CookieSecure         bool
 }
 
+// limiterIdleTTL is how long a per-key rate limiter is kept after its last use
+const limiterIdleTTL = 10 * time.Minute
+
+// pendingLoginLifetime is how long a user has to finish the steps after the password
+const pendingLoginLifetime = 5 * time.Minute
+
 type handlers struct {
-	admin    *h.AdminHandler
-	auth     *h.AuthHandler
-	home     *h.HomeHandler
-	upload   *h.UploadHandler
-	download *h.DownloadHandler
+	account    *h.AccountHandler
+	admin      *h.AdminHandler
+	auth       *h.AuthHandler
+	home       *h.HomeHandler
+	upload     *h.UploadHandler
+	download   *h.DownloadHandler
+	health     *h.HealthHandler
+	users      *h.UsersHandler
+	sessions   *h.SessionsHandler
+	trash      *h.TrashHandler
+	quarantine *h.QuarantineHandler
 }
 
 type Router struct {
@@ -38,24 +66,51 @@ type Router struct {
 }
 
 func NewRouter(
-	templates *template.Template,
+	templates *templates.Set,
 	sessions *auth.SessionService,
 	links *links.Store,
+	policies *policies.Store,
 	staticAuth *static.Auth,
 	googleAuth *google.Auth,
 	uploadService *uploads.UploadService,
 	downloadService *downloads.DownloadService,
 	fileService *files.FileService,
+	trashService *files.TrashService,
+	scanService *scanner.Service,
+	healthService *health.HealthService,
+	totpService *auth.TOTPService,
+	passkeyService *auth.PasskeyService,
+	janitor *janitor.Janitor,
 	config *Config,
 ) *Router {
+	tokenGuard := ratelimit.NewGuard(config.TokenMaxFailures, config.TokenBanDuration)
+	linkAccess := auth.NewLinkAccessService(config.LinkAccess)
 	router := &Router{
 		config: config,
 		handlers: &handlers{
-			admin:    h.NewAdminHandler(config.AuthType, config.BaseUrl, sessions, templates, links, uploadService, downloadService, fileService),
-			auth:     h.NewAuthHandler(config.AuthType, config.StaticAuthRateLimit, sessions, templates, googleAuth, staticAuth),
-			home:     h.NewHomeHandler(config.AuthType, sessions, templates),
-			upload:   h.NewUploadHandler(config.AuthType, sessions, templates, uploadService),
-			download: h.NewDownloadHandler(config.AuthType, sessions, templates, downloadService, fileService),
+			account: h.NewAccountHandler(config.AuthType, sessions, templates, totpService, passkeyService),
+			admin:   h.NewAdminHandler(config.AuthType, config.BaseUrl, sessions, templates, links, policies, uploadService, downloadService, fileService, trashService, tokenGuard, janitor),
+			auth: h.NewAuthHandler(
+				config.AuthType,
+				ratelimit.NewKeyedLimiter(config.StaticAuthRateLimit, config.StaticAuthRateBurst, limiterIdleTTL),
+				ratelimit.NewKeyedLimiter(config.StaticAuthRateLimit, config.StaticAuthRateBurst, limiterIdleTTL),
+				ratelimit.NewLockout(config.LoginMaxFailures, config.LoginLockoutDuration),
+				sessions,
+				templates,
+				googleAuth,
+				staticAuth,
+				totpService,
+				passkeyService,
+				auth.NewPendingLoginService(pendingLoginLifetime, config.CookieSecure),
+			),
+			home:       h.NewHomeHandler(config.AuthType, sessions, templates, passkeyService.Available()),
+			upload:     h.NewUploadHandler(config.AuthType, sessions, templates, tokenGuard, linkAccess, uploadService),
+			download:   h.NewDownloadHandler(config.AuthType, sessions, templates, tokenGuard, linkAccess, downloadService, fileService),
+			health:     h.NewHealthHandler(healthService),
+			users:      h.NewUsersHandler(config.AuthType, sessions, templates, staticAuth),
+			sessions:   h.NewSessionsHandler(config.AuthType, sessions, templates),
+			trash:      h.NewTrashHandler(config.AuthType, sessions, templates, trashService, janitor),
+			quarantine: h.NewQuarantineHandler(config.AuthType, sessions, templates, scanService),
 		},
 		sessions: sessions,
 	}
@@ -63,30 +118,85 @@ func NewRouter(
 }
 
 func (r *Router) SetupRoutes(mux *http.ServeMux) {
+	// Uploads need a long read deadline, downloads a long write deadline
+	uploadDeadline := DeadlineMiddleware(r.config.UploadTimeout, r.config.UploadTimeout)
+	downloadDeadline := DeadlineMiddleware(0, r.config.DownloadTimeout)
+
 	// Public routes
 	mux.HandleFunc("/{$}", r.handlers.home.HandleHome)
 	mux.HandleFunc("/login", r.handlers.auth.HandleLogin)
+	mux.HandleFunc("/login/2fa", r.handlers.auth.HandleSecondFactor)
+	mux.HandleFunc("/login/password", r.handlers.auth.HandleChangePassword)
+	mux.HandleFunc("POST /login/2fa/passkey/begin", r.handlers.auth.HandlePasskeySecondFactorBegin)
+	mux.HandleFunc("POST /login/2fa/passkey/finish", r.handlers.auth.HandlePasskeySecondFactorFinish)
+	mux.HandleFunc("POST /login/passkey/begin", r.handlers.auth.HandlePasskeyLoginBegin)
+	mux.HandleFunc("POST /login/passkey/finish", r.handlers.auth.HandlePasskeyLoginFinish)
 	mux.HandleFunc("GET /logout", r.handlers.auth.HandleLogout)
 	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir(r.config.StaticPath))))
 	mux.HandleFunc("GET /oauth/callback", r.handlers.auth.HandleGoogleOAuthCallback)
 	mux.HandleFunc("GET /upload/{token}", r.handlers.upload.HandleGetUpload)
-	mux.HandleFunc("POST /upload/{token}", r.handlers.upload.HandlePostUpload)
+	mux.Handle("POST /upload/{token}", uploadDeadline(http.HandlerFunc(r.handlers.upload.HandlePostUpload)))
+	mux.HandleFunc("POST /upload/{token}/unlock", r.handlers.upload.HandleUnlock)
 	mux.HandleFunc("GET /upload/success", r.handlers.upload.HandleSuccess)
 	mux.HandleFunc("GET /upload/error", r.handlers.upload.HandleError)
 	mux.HandleFunc("GET /download/{token}/{$}", r.handlers.download.HandleGetDirectory)
-	mux.HandleFunc("GET /download/{token}/{file}", r.handlers.download.HandleGetFile)
+	mux.HandleFunc("GET /download/{token}/{subdir}/{$}", r.handlers.download.HandleGetDirectory)
+	// Hidden names can't be files, so the manifest never hides a file
+	mux.Handle("GET /download/{token}/.SHA256SUMS", downloadDeadline(http.HandlerFunc(r.handlers.download.HandleChecksums)))
+	mux.Handle("GET /download/{token}/{file}", downloadDeadline(http.HandlerFunc(r.handlers.download.HandleGetFile)))
+	mux.HandleFunc("POST /download/{token}/unlock", r.handlers.download.HandleUnlock)
+	mux.HandleFunc("POST /download/{token}/verify", r.handlers.download.HandleVerify)
+	mux.HandleFunc("GET /healthz", r.handlers.health.HandleLiveness)
+	mux.HandleFunc("GET /readyz", r.handlers.health.HandleReadiness)
 
 	// Admin routes
 	adminRoutes := http.NewServeMux()
 	adminRoutes.HandleFunc("GET /admin/files/{$}", r.handlers.admin.HandleListDirectories)
 	adminRoutes.HandleFunc("GET /admin/files/{directory}/{$}", r.handlers.admin.HandleListDirectory)
-	adminRoutes.HandleFunc("GET /admin/files/{directory}/{filename}", r.handlers.admin.HandleDownloadFile)
+	adminRoutes.Handle("GET /admin/files/{directory}/{filename}", downloadDeadline(http.HandlerFunc(r.handlers.admin.HandleDownloadFile)))
+	adminRoutes.HandleFunc("GET /admin/files/{directory}/{filename}/manage", r.handlers.admin.HandleManageFile)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/{filename}/delete", r.handlers.admin.HandleDeleteFile)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/{filename}/rename", r.handlers.admin.HandleRenameFile)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/{filename}/move", r.handlers.admin.HandleMoveFile)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/mkdir", r.handlers.admin.HandleCreateDirectory)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/delete", r.handlers.admin.HandleDeleteDirectory)
 	adminRoutes.HandleFunc("POST /admin/files/{directory}/share", r.handlers.admin.HandleShareDirectory)
+	adminRoutes.HandleFunc("POST /admin/files/{directory}/policy", r.handlers.admin.HandleSetPolicy)
 	adminRoutes.HandleFunc("POST /admin/files/{directory}/unshare", r.handlers.admin.HandleUnshareDirectory)
-	adminRoutes.HandleFunc("POST /admin/files/{directory}/upload", r.handlers.admin.HandlePostUpload)
+	adminRoutes.Handle("POST /admin/files/{directory}/upload", uploadDeadline(http.HandlerFunc(r.handlers.admin.HandlePostUpload)))
+	adminRoutes.HandleFunc("DELETE /admin/api/files/{directory}", r.handlers.admin.HandleApiDeleteDirectory)
+	adminRoutes.HandleFunc("DELETE /admin/api/files/{directory}/{filename}", r.handlers.admin.HandleApiDeleteFile)
+	adminRoutes.HandleFunc("POST /admin/api/files/{directory}/{filename}/rename", r.handlers.admin.HandleApiRenameFile)
+	adminRoutes.HandleFunc("POST /admin/api/files/{directory}/{filename}/move", r.handlers.admin.HandleApiMoveFile)
 	adminRoutes.HandleFunc("GET /admin/home/{$}", r.handlers.admin.HandleHome)
+	adminRoutes.HandleFunc("POST /admin/janitor/run", r.handlers.admin.HandleRunJanitor)
 	adminRoutes.HandleFunc("POST /admin/links/new", r.handlers.admin.HandleCreateLink)
 	adminRoutes.HandleFunc("POST /admin/links/deactivate", r.handlers.admin.HandleDeactivateLink)
+	adminRoutes.HandleFunc("GET /admin/users/{$}", r.handlers.users.HandleListUsers)
+	adminRoutes.HandleFunc("POST /admin/users/new", r.handlers.users.HandleCreateUser)
+	adminRoutes.HandleFunc("POST /admin/users/{username}/password", r.handlers.users.HandleResetPassword)
+	adminRoutes.HandleFunc("POST /admin/users/{username}/disable", r.handlers.users.HandleDisableUser)
+	adminRoutes.HandleFunc("POST /admin/users/{username}/enable", r.handlers.users.HandleEnableUser)
+	adminRoutes.HandleFunc("GET /admin/sessions/{$}", r.handlers.sessions.HandleListSessions)
+	adminRoutes.HandleFunc("POST /admin/sessions/revoke", r.handlers.sessions.HandleRevokeSession)
+	adminRoutes.HandleFunc("POST /admin/sessions/logout-everywhere", r.handlers.sessions.HandleLogoutEverywhere)
+	adminRoutes.HandleFunc("GET /admin/trash/{$}", r.handlers.trash.HandleListTrash)
+	adminRoutes.HandleFunc("POST /admin/trash/{id}/restore", r.handlers.trash.HandleRestore)
+	adminRoutes.HandleFunc("POST /admin/trash/{id}/purge", r.handlers.trash.HandlePurge)
+	adminRoutes.HandleFunc("GET /admin/quarantine/{$}", r.handlers.quarantine.HandleListQuarantine)
+	adminRoutes.HandleFunc("POST /admin/quarantine/{id}/delete", r.handlers.quarantine.HandleDelete)
+	adminRoutes.HandleFunc("GET /admin/account/{$}", r.handlers.account.HandleAccount)
+	adminRoutes.HandleFunc("POST /admin/account/2fa/enroll", r.handlers.account.HandleEnroll)
+	adminRoutes.HandleFunc("POST /admin/account/2fa/confirm", r.handlers.account.HandleConfirm)
+	adminRoutes.HandleFunc("POST /admin/account/2fa/recovery-codes", r.handlers.account.HandleRegenerateRecoveryCodes)
+	adminRoutes.HandleFunc("POST /admin/account/2fa/disable", r.handlers.account.HandleDisable)
+	adminRoutes.HandleFunc("POST /admin/account/passkeys/register/begin", r.handlers.account.HandlePasskeyRegisterBegin)
+	adminRoutes.HandleFunc("POST /admin/account/passkeys/register/finish", r.handlers.account.HandlePasskeyRegisterFinish)
+	adminRoutes.HandleFunc("POST /admin/account/passkeys/delete", r.handlers.account.HandlePasskeyDelete)
 
-	mux.Handle("/admin/", r.sessions.RequireAuth(adminRoutes))
+	adminHandler := r.sessions.RequireAuth(adminRoutes)
+	if r.config.RequireClientCert {
+		adminHandler = RequireClientCertMiddleware(adminHandler)
+	}
+	mux.Handle("/admin/", adminHandler)
 }
@@ -8,8 +8,18 @@ import (
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/option"
+	"net/http"
 	"os"
 	"strings"
+	"time"
+)
+
+const (
+	// readyTimeout bounds a readiness check of the issuer
+	readyTimeout = 5 * time.Second
+	// readyCacheTTL is how long a readiness check result is reused, so
+	// frequent probes don't all reach the issuer
+	readyCacheTTL = 30 * time.Second
 )
 
 func (c *Config) Validate() error {
@@ -82,7 +92,39 @@ func NewAuthFromConfig(config *Config) (*Auth, error) {
 			Endpoint:     google.Endpoint,
 		},
 		oidcProvider: provider,
+		issuer:       strings.TrimSuffix(config.Issuer, "/"),
 	}
 
 	return auth, nil
 }
+
+// Ready reports whether the issuer's discovery document can be fetched, so
+// logins can be completed. The result is cached for readyCacheTTL.
+func (a *Auth) Ready(ctx context.Context) error {
+	a.readyMu.Lock()
+	defer a.readyMu.Unlock()
+	if time.Since(a.readyCheckedAt) < readyCacheTTL {
+		return a.readyErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	a.readyErr = a.fetchDiscovery(ctx)
+	a.readyCheckedAt = time.Now()
+	return a.readyErr
+}
+
+func (a *Auth) fetchDiscovery(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch oidc discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch oidc discovery document: %s", resp.Status)
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 	admin "google.golang.org/api/admin/directory/v1"
+	"sync"
+	"time"
 )
 
 type Config struct {
@@ -25,6 +27,11 @@ type Auth struct {
 	cookieSecure   bool
 	oauthConfig    *oauth2.Config
 	oidcProvider   *oidc.Provider
+	issuer         string
+
+	readyMu        sync.Mutex
+	readyCheckedAt time.Time
+	readyErr       error
 }
 
 type UserInfo struct {
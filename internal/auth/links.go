@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"time"
+)
+
+const (
+	linkAccessCookieName    = "link_access"
+	linkRecipientCookieName = "link_recipient"
+)
+
+var (
+	ErrLinkPasswordIncorrect = errors.New("incorrect link password")
+	ErrLinkPasswordLocked    = errors.New("too many incorrect link passwords")
+)
+
+type LinkAccessConfig struct {
+	Lifetime        time.Duration
+	Secure          bool
+	MaxFailures     int
+	LockoutDuration time.Duration
+}
+
+// LinkAccessService checks passphrases on protected upload and download links
+// and issues short-lived cookies, scoped to the link's path, once unlocked. It
+// also issues the cookies that remember verified recipients of a link.
+type LinkAccessService struct {
+	config  *LinkAccessConfig
+	cookies *cookieSigner
+	lockout *ratelimit.Lockout
+}
+
+// NewLinkAccessService creates a LinkAccessService. Cookies are signed with a
+// key generated at startup, so a restart requires links to be unlocked again.
+func NewLinkAccessService(config *LinkAccessConfig) *LinkAccessService {
+	return &LinkAccessService{
+		config:  config,
+		cookies: newCookieSigner(config.Secure),
+		lockout: ratelimit.NewLockout(config.MaxFailures, config.LockoutDuration),
+	}
+}
+
+// HashLinkPassword hashes the optional passphrase of a new link. Links without
+// one get an empty hash.
+func HashLinkPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Unlock checks password against passwordHash and on success sets an access
+// cookie for token limited to cookiePath. Wrong attempts are counted per link
+// and client, and lock that pair out for a while once the limit is reached.
+func (s *LinkAccessService) Unlock(w http.ResponseWriter, token, clientIp, cookiePath, passwordHash, password string) error {
+	key := token + "|" + clientIp
+	if _, locked := s.lockout.LockedUntil(key); locked {
+		return ErrLinkPasswordLocked
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		if s.lockout.Failure(key) {
+			return ErrLinkPasswordLocked
+		}
+		return ErrLinkPasswordIncorrect
+	}
+	s.lockout.Reset(key)
+	s.cookies.set(w, linkAccessCookieName, token, "", cookiePath, s.config.Lifetime)
+	return nil
+}
+
+// HasAccess reports whether r carries a valid, unexpired access cookie for token.
+func (s *LinkAccessService) HasAccess(r *http.Request, token string) bool {
+	_, ok := s.cookies.read(r, linkAccessCookieName, token)
+	return ok
+}
+
+// GrantRecipient sets a cookie recording that email was verified for token.
+func (s *LinkAccessService) GrantRecipient(w http.ResponseWriter, token, email, cookiePath string) {
+	s.cookies.set(w, linkRecipientCookieName, token, email, cookiePath, s.config.Lifetime)
+}
+
+// Recipient returns the verified email address carried by r for token, if any.
+func (s *LinkAccessService) Recipient(r *http.Request, token string) (string, bool) {
+	return s.cookies.read(r, linkRecipientCookieName, token)
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/passkeys"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const passkeyCeremonyCookieName = "passkey_ceremony"
+
+var (
+	ErrPasskeyInvalid      = errors.New("passkey verification failed")
+	ErrPasskeyNotAvailable = errors.New("passkeys are not enabled")
+)
+
+type PasskeyConfig struct {
+	Enabled bool
+	// RPID is the relying party id, the host name passkeys are bound to
+	RPID          string
+	RPDisplayName string
+	// Origins lists the origins ceremonies may be performed from
+	Origins []string
+	// CeremonyTimeout bounds the time between starting and finishing a ceremony
+	CeremonyTimeout time.Duration
+	CookieSecure    bool
+}
+
+// PasskeyService runs WebAuthn registration and login ceremonies. Passkeys
+// can be used to log in on their own or as the second login step after a
+// password. Ceremony state is kept in a short-lived signed cookie, and the
+// challenges still outstanding are kept here, so each can be answered once.
+type PasskeyService struct {
+	store    *passkeys.Store
+	config   *PasskeyConfig
+	webauthn *webauthn.WebAuthn
+	cookies  *cookieSigner
+
+	mu sync.Mutex
+	// challenges maps each outstanding challenge to when it expires
+	challenges map[string]time.Time
+	lastSweep  time.Time
+}
+
+// passkeyUser adapts a user and their stored credentials to webauthn.User.
+type passkeyUser struct {
+	handle      []byte
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return u.handle }
+func (u *passkeyUser) WebAuthnName() string                       { return u.username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func NewPasskeyService(store *passkeys.Store, config *PasskeyConfig) (*PasskeyService, error) {
+	s := &PasskeyService{
+		store:      store,
+		config:     config,
+		cookies:    newCookieSigner(config.CookieSecure),
+		challenges: make(map[string]time.Time),
+		lastSweep:  time.Now(),
+	}
+	if !config.Enabled {
+		return s, nil
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigins:     config.Origins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure webauthn: %v", err)
+	}
+	s.webauthn = w
+	return s, nil
+}
+
+// Available reports whether passkeys can be registered and used.
+func (s *PasskeyService) Available() bool {
+	return s.webauthn != nil
+}
+
+func (s *PasskeyService) ListCredentials(ctx context.Context, username string) ([]passkeys.Credential, error) {
+	credentials, err := s.store.ListCredentials(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %v", err)
+	}
+	return credentials, nil
+}
+
+// HasCredentials reports whether username has registered a passkey, in which
+// case the passkey can be used as their second login step.
+func (s *PasskeyService) HasCredentials(ctx context.Context, username string) (bool, error) {
+	if !s.Available() {
+		return false, nil
+	}
+	count, err := s.store.CountCredentials(ctx, username)
+	if err != nil {
+		return false, fmt.Errorf("failed to count passkeys: %v", err)
+	}
+	return count > 0, nil
+}
+
+func (s *PasskeyService) DeleteCredential(ctx context.Context, username, id string) error {
+	if err := s.store.DeleteCredential(ctx, username, id); err != nil {
+		return fmt.Errorf("failed to delete passkey: %v", err)
+	}
+	return nil
+}
+
+// BeginRegistration starts registering a new discoverable passkey for username.
+func (s *PasskeyService) BeginRegistration(ctx context.Context, w http.ResponseWriter, username, cookiePath string) (*protocol.CredentialCreation, error) {
+	if !s.Available() {
+		return nil, ErrPasskeyNotAvailable
+	}
+	user, err := s.user(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := s.webauthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey registration: %v", err)
+	}
+	if err := s.saveCeremony(w, "register", cookiePath, session); err != nil {
+		return nil, err
+	}
+	return creation, nil
+}
+
+// FinishRegistration verifies the authenticator's response in r and stores
+// the new passkey under name.
+func (s *PasskeyService) FinishRegistration(ctx context.Context, w http.ResponseWriter, r *http.Request, username, name, cookiePath string) error {
+	if !s.Available() {
+		return ErrPasskeyNotAvailable
+	}
+	session, err := s.loadCeremony(w, r, "register", cookiePath)
+	if err != nil {
+		return err
+	}
+	user, err := s.user(ctx, username)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(session.UserID, user.handle) {
+		return fmt.Errorf("%w: ceremony was started for another user", ErrPasskeyInvalid)
+	}
+	credential, err := s.webauthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to encode passkey: %v", err)
+	}
+	if err := s.store.AddCredential(ctx, credentialId(credential), username, name, string(data)); err != nil {
+		return fmt.Errorf("failed to save passkey: %v", err)
+	}
+	return nil
+}
+
+// BeginLogin starts a standalone passkey login. Any discoverable passkey may
+// answer, and user verification is required since no password is checked.
+func (s *PasskeyService) BeginLogin(w http.ResponseWriter, cookiePath string) (*protocol.CredentialAssertion, error) {
+	if !s.Available() {
+		return nil, ErrPasskeyNotAvailable
+	}
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %v", err)
+	}
+	if err := s.saveCeremony(w, "login", cookiePath, session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// FinishLogin verifies a standalone passkey login and returns the user the
+// passkey belongs to.
+func (s *PasskeyService) FinishLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, cookiePath string) (string, error) {
+	if !s.Available() {
+		return "", ErrPasskeyNotAvailable
+	}
+	session, err := s.loadCeremony(w, r, "login", cookiePath)
+	if err != nil {
+		return "", err
+	}
+	var user *passkeyUser
+	credential, err := s.webauthn.FinishDiscoverableLogin(func(rawId, userHandle []byte) (webauthn.User, error) {
+		username, err := s.store.Username(ctx, userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err = s.user(ctx, username)
+		return user, err
+	}, *session, r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+	if err := s.recordUse(ctx, credential); err != nil {
+		return "", err
+	}
+	return user.username, nil
+}
+
+// BeginSecondFactor starts a passkey check for a user who already passed the
+// password step. Only that user's passkeys are allowed.
+func (s *PasskeyService) BeginSecondFactor(ctx context.Context, w http.ResponseWriter, username, cookiePath string) (*protocol.CredentialAssertion, error) {
+	if !s.Available() {
+		return nil, ErrPasskeyNotAvailable
+	}
+	user, err := s.user(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	assertion, session, err := s.webauthn.BeginLogin(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %v", err)
+	}
+	if err := s.saveCeremony(w, "second-factor", cookiePath, session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+func (s *PasskeyService) FinishSecondFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, username, cookiePath string) error {
+	if !s.Available() {
+		return ErrPasskeyNotAvailable
+	}
+	session, err := s.loadCeremony(w, r, "second-factor", cookiePath)
+	if err != nil {
+		return err
+	}
+	user, err := s.user(ctx, username)
+	if err != nil {
+		return err
+	}
+	credential, err := s.webauthn.FinishLogin(user, *session, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+	return s.recordUse(ctx, credential)
+}
+
+// recordUse stores the updated signature counter of a credential, refusing
+// credentials whose counter went backwards as they may have been cloned.
+func (s *PasskeyService) recordUse(ctx context.Context, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return fmt.Errorf("%w: signature counter went backwards, the authenticator may be cloned", ErrPasskeyInvalid)
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to encode passkey: %v", err)
+	}
+	if err := s.store.UpdateCredential(ctx, credentialId(credential), string(data)); err != nil {
+		return fmt.Errorf("failed to update passkey: %v", err)
+	}
+	return nil
+}
+
+// user loads username with their stored credentials, creating a random user
+// handle the first time a user is seen.
+func (s *PasskeyService) user(ctx context.Context, username string) (*passkeyUser, error) {
+	newHandle := make([]byte, 64)
+	if _, err := rand.Read(newHandle); err != nil {
+		return nil, fmt.Errorf("failed to generate user handle: %v", err)
+	}
+	handle, err := s.store.UserHandle(ctx, username, newHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user handle: %v", err)
+	}
+	stored, err := s.store.ListCredentials(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %v", err)
+	}
+	user := &passkeyUser{handle: handle, username: username}
+	for _, c := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(c.Data), &credential); err != nil {
+			return nil, fmt.Errorf("failed to decode passkey %s: %v", c.Id, err)
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, nil
+}
+
+func (s *PasskeyService) saveCeremony(w http.ResponseWriter, ceremony, cookiePath string, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode passkey ceremony: %v", err)
+	}
+	s.addChallenge(session.Challenge)
+	s.cookies.set(w, passkeyCeremonyCookieName, ceremony, string(data), cookiePath, s.config.CeremonyTimeout)
+	return nil
+}
+
+// loadCeremony reads the state saved when the ceremony began and clears the
+// cookie, so the browser starts the next ceremony from scratch. The challenge
+// is used up, so a captured cookie and response cannot be replayed.
+func (s *PasskeyService) loadCeremony(w http.ResponseWriter, r *http.Request, ceremony, cookiePath string) (*webauthn.SessionData, error) {
+	data, ok := s.cookies.read(r, passkeyCeremonyCookieName, ceremony)
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s ceremony in progress", ErrPasskeyInvalid, ceremony)
+	}
+	s.cookies.clear(w, passkeyCeremonyCookieName, cookiePath)
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to decode passkey ceremony: %v", err)
+	}
+	if !s.useChallenge(session.Challenge) {
+		return nil, fmt.Errorf("%w: %s challenge already used or expired", ErrPasskeyInvalid, ceremony)
+	}
+	return &session, nil
+}
+
+// addChallenge records a challenge that has been handed out.
+func (s *PasskeyService) addChallenge(challenge string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	s.challenges[challenge] = now.Add(s.config.CeremonyTimeout)
+}
+
+// useChallenge removes a challenge and reports whether it was outstanding.
+func (s *PasskeyService) useChallenge(challenge string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.challenges[challenge]
+	delete(s.challenges, challenge)
+	return ok && time.Now().Before(expiresAt)
+}
+
+// sweep evicts challenges that were never answered, at most once per ceremony
+// timeout. Must hold s.mu.
+func (s *PasskeyService) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.config.CeremonyTimeout {
+		return
+	}
+	s.lastSweep = now
+	for challenge, expiresAt := range s.challenges {
+		if now.After(expiresAt) {
+			delete(s.challenges, challenge)
+		}
+	}
+}
+
+func credentialId(credential *webauthn.Credential) string {
+	return base64.RawURLEncoding.EncodeToString(credential.ID)
+}
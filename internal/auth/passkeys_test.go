@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadCeremonyReplay(t *testing.T) {
+	s, err := NewPasskeyService(nil, &PasskeyConfig{CeremonyTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	if err := s.saveCeremony(rec, "login", "/login/", &webauthn.SessionData{Challenge: "challenge"}); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/login/passkey/finish", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	session, err := s.loadCeremony(httptest.NewRecorder(), r, "login", "/login/")
+	if err != nil {
+		t.Fatalf("loadCeremony = %v", err)
+	}
+	if session.Challenge != "challenge" {
+		t.Errorf("loadCeremony challenge = %q, want %q", session.Challenge, "challenge")
+	}
+	// The cookie is still valid, but its challenge has been used
+	if _, err := s.loadCeremony(httptest.NewRecorder(), r, "login", "/login/"); !errors.Is(err, ErrPasskeyInvalid) {
+		t.Errorf("replayed loadCeremony = %v, want ErrPasskeyInvalid", err)
+	}
+}
+
+func TestUseChallengeExpired(t *testing.T) {
+	s, err := NewPasskeyService(nil, &PasskeyConfig{CeremonyTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.challenges["expired"] = time.Now().Add(-time.Second)
+	if s.useChallenge("expired") {
+		t.Error("useChallenge accepted an expired challenge")
+	}
+	if s.useChallenge("unknown") {
+		t.Error("useChallenge accepted a challenge that was never handed out")
+	}
+}
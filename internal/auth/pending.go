@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	pendingLoginCookieName = "login_pending"
+	pendingLoginPath       = "/login/"
+)
+
+// LoginStage is a step a login has to pass after the password check, before
+// a session is created.
+type LoginStage string
+
+const (
+	LoginStageSecondFactor   LoginStage = "second-factor"
+	LoginStagePasswordChange LoginStage = "password-change"
+)
+
+// PendingLoginService remembers which user is part way through logging in and
+// which stage they are at, so later stages need not ask for the password again.
+type PendingLoginService struct {
+	lifetime time.Duration
+	cookies  *cookieSigner
+}
+
+func NewPendingLoginService(lifetime time.Duration, secure bool) *PendingLoginService {
+	return &PendingLoginService{
+		lifetime: lifetime,
+		cookies:  newCookieSigner(secure),
+	}
+}
+
+// Begin records that username reached stage, replacing any earlier stage.
+func (s *PendingLoginService) Begin(w http.ResponseWriter, stage LoginStage, username string) {
+	s.cookies.set(w, pendingLoginCookieName, string(stage), username, pendingLoginPath, s.lifetime)
+}
+
+// Get returns the user waiting at stage, if any.
+func (s *PendingLoginService) Get(r *http.Request, stage LoginStage) (string, bool) {
+	return s.cookies.read(r, pendingLoginCookieName, string(stage))
+}
+
+func (s *PendingLoginService) End(w http.ResponseWriter) {
+	s.cookies.clear(w, pendingLoginCookieName, pendingLoginPath)
+}
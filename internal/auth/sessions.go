@@ -1,10 +1,17 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/frodejac/globster/internal/database/sessions"
+	"github.com/frodejac/globster/internal/proxy"
 	"github.com/frodejac/globster/internal/random"
+	"log/slog"
 	"net/http"
 	"time"
 )
@@ -15,9 +22,18 @@ type SessionCookieConfig struct {
 	HttpOnly bool
 	Secure   bool
 	SameSite http.SameSite
+	// Lifetime is the absolute maximum age of a session
 	Lifetime time.Duration
+	// IdleTimeout, when set, expires sessions that have not been used for
+	// that long. Each use moves the expiry forward, up to Lifetime.
+	IdleTimeout time.Duration
 }
 
+var ErrSessionNotFound = errors.New("session not found")
+
+// touchInterval limits how often the last use of a session is written
+const touchInterval = time.Minute
+
 type SessionService struct {
 	store  *sessions.Store
 	cookie *SessionCookieConfig
@@ -34,6 +50,7 @@ func (s *SessionService) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		valid, err := s.Validate(r)
 		if err != nil {
+			slog.Error("Error validating session", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
@@ -46,51 +63,117 @@ func (s *SessionService) RequireAuth(next http.Handler) http.Handler {
 }
 
 func (s *SessionService) Validate(r *http.Request) (bool, error) {
-	id, err := s.getSessionId(r)
+	session, err := s.current(r)
 	if err != nil {
-		return false, fmt.Errorf("failed to get session ID: %w", err)
+		return false, err
 	}
-	if id == "" {
+	if session == nil {
 		return false, nil
 	}
+	if err := s.touch(r, session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Username returns the user the request's session was created for. It is empty
+// when there is no valid session.
+func (s *SessionService) Username(r *http.Request) (string, error) {
+	session, err := s.current(r)
+	if err != nil || session == nil {
+		return "", err
+	}
+	return session.Username, nil
+}
+
+// CurrentId returns the ID of the request's session, or an empty string.
+func (s *SessionService) CurrentId(r *http.Request) (string, error) {
+	session, err := s.current(r)
+	if err != nil || session == nil {
+		return "", err
+	}
+	return session.Id, nil
+}
 
-	// Check if session exists
-	session, err := s.store.Get(id)
+// current returns the request's session, or nil if it has none or it expired.
+func (s *SessionService) current(r *http.Request) (*sessions.Session, error) {
+	id, err := s.getSessionId(r)
 	if err != nil {
-		return false, fmt.Errorf("failed to get session: %w", err)
+		return nil, fmt.Errorf("failed to get session ID: %w", err)
 	}
-	if session == nil {
-		return false, nil
+	if id == "" {
+		return nil, nil
 	}
-	// Check if session is expired
-	if session.ExpiresAt.Before(time.Now()) {
-		// Cleanup
-		if err := s.store.Delete(id); err != nil {
-			return false, fmt.Errorf("failed to delete expired session: %w", err)
+	session, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		// Sessions are deleted on logout, on revocation and when a user is disabled
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
 		}
-		return false, nil
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
-	// Session is valid
-	return true, nil
+	if !session.ExpiresAt.After(time.Now()) {
+		// Expired sessions are left for the sweeper
+		return nil, nil
+	}
+	return session, nil
 }
 
-func (s *SessionService) Create(w http.ResponseWriter) (string, error) {
-	id := random.String(32)
-	expiresAt := time.Now().Add(s.cookie.Lifetime)
-	if err := s.store.Create(id, time.Now(), expiresAt); err != nil {
+// touch records the use of session, at most once per touchInterval, and
+// slides its expiry when an idle timeout is configured.
+func (s *SessionService) touch(r *http.Request, session *sessions.Session) error {
+	now := time.Now()
+	interval := touchInterval
+	if s.cookie.IdleTimeout > 0 {
+		// Short idle timeouts must be extended before they run out
+		interval = min(interval, s.cookie.IdleTimeout/2)
+	}
+	if now.Sub(session.LastSeenAt) < interval {
+		return nil
+	}
+	expiresAt := session.ExpiresAt
+	if s.cookie.IdleTimeout > 0 {
+		expiresAt = now.Add(s.cookie.IdleTimeout)
+		if limit := session.CreatedAt.Add(s.cookie.Lifetime); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	if err := s.store.Touch(r.Context(), session.Id, proxy.ClientIP(r), now, expiresAt); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionService) Create(w http.ResponseWriter, r *http.Request, username string) (string, error) {
+	now := time.Now()
+	session := &sessions.Session{
+		Id:         random.String(32),
+		Username:   username,
+		UserAgent:  r.UserAgent(),
+		ClientIp:   proxy.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.cookie.Lifetime),
+	}
+	if s.cookie.IdleTimeout > 0 && s.cookie.IdleTimeout < s.cookie.Lifetime {
+		session.ExpiresAt = now.Add(s.cookie.IdleTimeout)
+	}
+	if err := s.store.Create(r.Context(), session); err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
 	}
+	// The cookie lives for the absolute lifetime, the idle timeout is
+	// enforced on the server
 	cookie := &http.Cookie{
 		Name:     s.cookie.Name,
-		Value:    id,
-		Expires:  expiresAt,
+		Value:    session.Id,
+		Expires:  now.Add(s.cookie.Lifetime),
 		Path:     s.cookie.Path,
 		HttpOnly: s.cookie.HttpOnly,
 		Secure:   s.cookie.Secure,
 		SameSite: s.cookie.SameSite,
 	}
 	http.SetCookie(w, cookie)
-	return id, nil
+	return session.Id, nil
 }
 
 func (s *SessionService) Destroy(w http.ResponseWriter, r *http.Request) error {
@@ -101,7 +184,7 @@ func (s *SessionService) Destroy(w http.ResponseWriter, r *http.Request) error {
 	if id == "" {
 		return nil // No session to destroy
 	}
-	if err := s.store.Delete(id); err != nil {
+	if err := s.store.Delete(r.Context(), id); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
 	cookie := &http.Cookie{
@@ -136,3 +219,65 @@ func (s *SessionService) getSessionId(r *http.Request) (string, error) {
 	}
 	return id, nil
 }
+
+// DestroyAll removes every session of username, logging them out everywhere.
+func (s *SessionService) DestroyAll(ctx context.Context, username string) error {
+	if err := s.store.DeleteByUsername(ctx, username); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+// List returns every active session.
+func (s *SessionService) List(ctx context.Context) ([]sessions.Session, error) {
+	list, err := s.store.List(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return list, nil
+}
+
+// SessionHandle identifies a session in pages and forms without revealing
+// its ID, which is all it takes to use the session.
+func SessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Revoke ends the active session with the given handle.
+func (s *SessionService) Revoke(ctx context.Context, handle string) error {
+	list, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, session := range list {
+		if subtle.ConstantTimeCompare([]byte(SessionHandle(session.Id)), []byte(handle)) == 1 {
+			if err := s.store.Delete(ctx, session.Id); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// Sweep deletes expired sessions every interval until ctx is cancelled.
+func (s *SessionService) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.store.DeleteExpired(ctx, time.Now())
+			if err != nil {
+				slog.Error("Failed to delete expired sessions", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				slog.Info("Deleted expired sessions", "count", deleted)
+			}
+		}
+	}
+}
@@ -1,15 +1,54 @@
 package static
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/users"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (a *Auth) Validate(username, password string) bool {
-	hash, _ := a.Users[username]
+// Validate checks username and password and returns the user. Unknown,
+// disabled and wrong credentials all return ErrInvalidCredentials.
+func (a *Auth) Validate(ctx context.Context, username, password string) (*users.User, error) {
+	user, err := a.store.Get(ctx, username)
+	if err != nil && !errors.Is(err, users.ErrUserNotFound) {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	hash := a.dummyHash
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+
+	// We want to do a constant time comparison to prevent timing attacks, even if the user does not exist
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || user == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// Active returns the user if it exists and is not disabled, for logins that do
+// not check a password.
+func (a *Auth) Active(ctx context.Context, username string) (*users.User, error) {
+	user, err := a.store.Get(ctx, username)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	if user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
 
-	// We want to do a constant time comparison to prevent timing attacks, even if the password is empty
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
-		return false
+func (a *Auth) RecordLogin(ctx context.Context, username string) error {
+	if err := a.store.RecordLogin(ctx, username); err != nil {
+		return fmt.Errorf("failed to record login: %v", err)
 	}
-	return true
+	return nil
 }
@@ -0,0 +1,55 @@
+package static
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything past 72 bytes
+const maxPasswordBytes = 72
+
+// Check returns an error wrapping ErrPasswordPolicy that lists every
+// requirement password fails.
+func (p *PasswordPolicy) Check(username, password string) error {
+	var problems []string
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", maxPasswordBytes))
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problems = append(problems, "must not contain the username")
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("must use at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrPasswordPolicy, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
@@ -1,22 +1,85 @@
 package static
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/frodejac/globster/internal/database/users"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
 	"os"
 )
 
-func NewAuthFromConfig(config *Config) (*Auth, error) {
-	data, err := os.ReadFile(config.UsersJsonPath)
+func NewAuthFromConfig(store *users.Store, config *Config) (*Auth, error) {
+	// Compared against when a user does not exist, so unknown users take as
+	// long to reject as wrong passwords
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("globster"), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read static auth config: %v", err)
+		return nil, fmt.Errorf("failed to generate dummy hash: %v", err)
 	}
-	auth := &Auth{}
-	if err := json.Unmarshal(data, &auth.Users); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal static auth config: %v", err)
+	auth := &Auth{
+		store:     store,
+		dummyHash: dummyHash,
 	}
-	if len(auth.Users) == 0 {
-		return nil, fmt.Errorf("no users found in static auth config")
+	count, err := auth.load(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		slog.Warn("No users exist, create one with `globster user add <username>`")
 	}
 	return auth, nil
 }
+
+// Reload applies a new password policy and retries the import of the users
+// file if no users have been created yet. Users already in the database are
+// managed there and are not affected.
+func (a *Auth) Reload(ctx context.Context, config *Config) error {
+	_, err := a.load(ctx, config)
+	return err
+}
+
+func (a *Auth) load(ctx context.Context, config *Config) (int, error) {
+	a.policy.Store(config.Policy)
+	count, err := a.store.Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %v", err)
+	}
+	if count == 0 && config.UsersJsonPath != "" {
+		imported, err := a.importJson(ctx, config.UsersJsonPath)
+		if err != nil {
+			return 0, err
+		}
+		if imported > 0 {
+			slog.Info("Imported users from static auth config, the file is no longer read and can be removed", slog.String("path", config.UsersJsonPath), slog.Int("users", imported))
+			count = imported
+		}
+	}
+	return count, nil
+}
+
+// importJson copies users from the legacy users file, a JSON object mapping
+// usernames to bcrypt hashes. A missing file is not an error.
+func (a *Auth) importJson(ctx context.Context, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read static auth config: %v", err)
+	}
+	var hashes map[string]string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal static auth config: %v", err)
+	}
+	for username, hash := range hashes {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return 0, fmt.Errorf("invalid bcrypt hash for user %s: %v", username, err)
+		}
+	}
+	if err := a.store.Import(ctx, hashes); err != nil {
+		return 0, fmt.Errorf("failed to import users: %v", err)
+	}
+	return len(hashes), nil
+}
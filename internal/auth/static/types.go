@@ -1,9 +1,35 @@
 package static
 
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/database/users"
+	"sync/atomic"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrPasswordPolicy     = errors.New("password does not meet the policy")
+	ErrPasswordReused     = errors.New("new password must differ from the current one")
+	ErrInvalidUsername    = errors.New("username must not be empty or contain whitespace")
+)
+
+// Auth authenticates static users against the users table and manages them.
 type Auth struct {
-	Users map[string]string `json:"users"`
+	store     *users.Store
+	policy    atomic.Pointer[PasswordPolicy]
+	dummyHash []byte
 }
 
 type Config struct {
+	// UsersJsonPath is the legacy users file, imported once into an empty users table
 	UsersJsonPath string
+	Policy        *PasswordPolicy
+}
+
+// PasswordPolicy lists the requirements for passwords set through Globster.
+// Hashes imported from the legacy users file are not checked.
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols must be used
+	MinClasses int
 }
@@ -0,0 +1,77 @@
+package static
+
+import (
+	"context"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/users"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+func (a *Auth) ListUsers(ctx context.Context) ([]users.User, error) {
+	list, err := a.store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	return list, nil
+}
+
+// AddUser creates a user. With mustChangePassword the password is treated as
+// temporary and has to be changed on first login.
+func (a *Auth) AddUser(ctx context.Context, username, password string, mustChangePassword bool) error {
+	username = strings.TrimSpace(username)
+	if username == "" || strings.ContainsAny(username, " \t\r\n") {
+		return ErrInvalidUsername
+	}
+	hash, err := a.hashPassword(username, password)
+	if err != nil {
+		return err
+	}
+	if err := a.store.Create(ctx, username, hash, mustChangePassword); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+// SetPassword replaces the password of username, as done by an administrator.
+func (a *Auth) SetPassword(ctx context.Context, username, password string, mustChangePassword bool) error {
+	hash, err := a.hashPassword(username, password)
+	if err != nil {
+		return err
+	}
+	if err := a.store.SetPassword(ctx, username, hash, mustChangePassword); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	return nil
+}
+
+// ChangePassword is a user replacing their own password, which clears any
+// pending forced change. The new password must differ from the current one.
+func (a *Auth) ChangePassword(ctx context.Context, username, password string) error {
+	user, err := a.store.Get(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil {
+		return ErrPasswordReused
+	}
+	return a.SetPassword(ctx, username, password, false)
+}
+
+func (a *Auth) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	if err := a.store.SetDisabled(ctx, username, disabled); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
+}
+
+func (a *Auth) hashPassword(username, password string) (string, error) {
+	if err := a.policy.Load().Check(username, password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,385 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/twofactor"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"html/template"
+	"image/png"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPInvalidCode  = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled  = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPRequired     = errors.New("two-factor authentication is required")
+	ErrTOTPNotAvailable = errors.New("two-factor authentication is not configured")
+)
+
+type TOTPConfig struct {
+	// Issuer is shown next to the account name in authenticator apps
+	Issuer string
+	// EncryptionKey is the 32 byte AES key secrets are encrypted with at rest.
+	// Without it two-factor authentication is unavailable.
+	EncryptionKey []byte
+	// Required forces every static user to enroll before they can log in
+	Required bool
+}
+
+// TOTPService manages time-based one-time passwords as a second login factor
+// for static users, along with their single-use recovery codes.
+type TOTPService struct {
+	store  *twofactor.Store
+	config *TOTPConfig
+	aead   cipher.AEAD
+}
+
+// Enrollment is a freshly generated secret waiting to be confirmed with a code.
+type Enrollment struct {
+	Secret string
+	URL    string
+	QRCode template.URL
+}
+
+// TOTPStatus describes the two-factor state of a user for display.
+type TOTPStatus struct {
+	Enrolled      bool
+	RecoveryCodes int
+}
+
+func NewTOTPService(store *twofactor.Store, config *TOTPConfig) (*TOTPService, error) {
+	s := &TOTPService{
+		store:  store,
+		config: config,
+	}
+	if len(config.EncryptionKey) == 0 {
+		if config.Required {
+			return nil, fmt.Errorf("an encryption key is required when two-factor authentication is required")
+		}
+		return s, nil
+	}
+	block, err := aes.NewCipher(config.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid two-factor encryption key: %v", err)
+	}
+	s.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return s, nil
+}
+
+// Available reports whether users can enroll in two-factor authentication.
+func (s *TOTPService) Available() bool {
+	return s.aead != nil
+}
+
+// Required reports whether every static user must use two-factor authentication.
+func (s *TOTPService) Required() bool {
+	return s.config.Required
+}
+
+// Status reports whether username is enrolled. It reads the store even when
+// no encryption key is configured, so enrolled users fail closed rather than
+// silently losing their second factor.
+func (s *TOTPService) Status(ctx context.Context, username string) (*TOTPStatus, error) {
+	secret, err := s.store.GetSecret(ctx, username)
+	if err != nil {
+		if errors.Is(err, twofactor.ErrSecretNotFound) {
+			return &TOTPStatus{}, nil
+		}
+		return nil, fmt.Errorf("failed to get totp secret: %v", err)
+	}
+	if !secret.Confirmed() {
+		return &TOTPStatus{}, nil
+	}
+	count, err := s.store.CountRecoveryCodes(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recovery codes: %v", err)
+	}
+	return &TOTPStatus{Enrolled: true, RecoveryCodes: count}, nil
+}
+
+// NeedsSecondFactor reports whether username has to pass the second login
+// step, either because they are enrolled or because enrollment is required.
+func (s *TOTPService) NeedsSecondFactor(ctx context.Context, username string) (bool, error) {
+	if s.config.Required {
+		return true, nil
+	}
+	status, err := s.Status(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	return status.Enrolled, nil
+}
+
+// BeginEnrollment generates a new secret for username and stores it,
+// encrypted, until it is confirmed with ConfirmEnrollment.
+func (s *TOTPService) BeginEnrollment(ctx context.Context, username string) (*Enrollment, error) {
+	if !s.Available() {
+		return nil, ErrTOTPNotAvailable
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.config.Issuer,
+		AccountName: username,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %v", err)
+	}
+	encrypted, err := s.encrypt(username, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SavePendingSecret(ctx, username, encrypted); err != nil {
+		return nil, fmt.Errorf("failed to save totp secret: %v", err)
+	}
+	return newEnrollment(key)
+}
+
+// PendingEnrollment returns the unconfirmed secret of username again, so a
+// mistyped confirmation code does not require scanning a new QR code.
+func (s *TOTPService) PendingEnrollment(ctx context.Context, username string) (*Enrollment, error) {
+	secret, err := s.secret(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Confirmed() {
+		return nil, fmt.Errorf("two-factor authentication is already enrolled")
+	}
+	plain, err := s.decrypt(username, secret.Secret)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(plain)
+	if err != nil {
+		return nil, fmt.Errorf("malformed totp secret: %v", err)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.config.Issuer,
+		AccountName: username,
+		Period:      totpPeriod,
+		Secret:      raw,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore totp secret: %v", err)
+	}
+	return newEnrollment(key)
+}
+
+// ConfirmEnrollment checks code against the pending secret of username and
+// enables two-factor authentication. It returns the new recovery codes, which
+// are only stored hashed and cannot be shown again.
+func (s *TOTPService) ConfirmEnrollment(ctx context.Context, username, code string) ([]string, error) {
+	secret, err := s.secret(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Confirmed() {
+		return nil, fmt.Errorf("two-factor authentication is already enrolled")
+	}
+	step, err := s.matchStep(username, secret, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.ConfirmSecret(ctx, username, step, hashes); err != nil {
+		return nil, fmt.Errorf("failed to confirm totp secret: %v", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the authenticator app, or failing that an unused
+// recovery code. Each app code is accepted only once.
+func (s *TOTPService) Verify(ctx context.Context, username, code string) error {
+	secret, err := s.secret(ctx, username)
+	if err != nil {
+		return err
+	}
+	if !secret.Confirmed() {
+		return ErrTOTPNotEnrolled
+	}
+	step, err := s.matchStep(username, secret, code)
+	if err == nil {
+		ok, err := s.store.UseStep(ctx, username, step)
+		if err != nil {
+			return fmt.Errorf("failed to record totp step: %v", err)
+		}
+		if !ok {
+			return ErrTOTPInvalidCode
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrTOTPInvalidCode) {
+		return err
+	}
+	ok, err := s.store.UseRecoveryCode(ctx, username, hashRecoveryCode(username, code))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %v", err)
+	}
+	if !ok {
+		return ErrTOTPInvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of an enrolled user.
+func (s *TOTPService) RegenerateRecoveryCodes(ctx context.Context, username string) ([]string, error) {
+	status, err := s.Status(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enrolled {
+		return nil, ErrTOTPNotEnrolled
+	}
+	codes, hashes, err := generateRecoveryCodes(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.ReplaceRecoveryCodes(ctx, username, hashes); err != nil {
+		return nil, fmt.Errorf("failed to replace recovery codes: %v", err)
+	}
+	return codes, nil
+}
+
+// Disable removes the secret and recovery codes of username after checking a
+// current code. It is refused while two-factor authentication is required.
+func (s *TOTPService) Disable(ctx context.Context, username, code string) error {
+	if s.config.Required {
+		return ErrTOTPRequired
+	}
+	if err := s.Verify(ctx, username, code); err != nil {
+		return err
+	}
+	if err := s.store.DeleteSecret(ctx, username); err != nil {
+		return fmt.Errorf("failed to delete totp secret: %v", err)
+	}
+	return nil
+}
+
+func (s *TOTPService) secret(ctx context.Context, username string) (*twofactor.Secret, error) {
+	if !s.Available() {
+		return nil, ErrTOTPNotAvailable
+	}
+	secret, err := s.store.GetSecret(ctx, username)
+	if err != nil {
+		if errors.Is(err, twofactor.ErrSecretNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, fmt.Errorf("failed to get totp secret: %v", err)
+	}
+	return secret, nil
+}
+
+// matchStep returns the time step code is valid for, allowing one step of
+// clock drift in either direction.
+func (s *TOTPService) matchStep(username string, secret *twofactor.Secret, code string) (int64, error) {
+	plain, err := s.decrypt(username, secret.Secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	now := time.Now()
+	for _, skew := range []int64{0, -1, 1} {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(plain, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to generate totp code: %v", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return t.Unix() / totpPeriod, nil
+		}
+	}
+	return 0, ErrTOTPInvalidCode
+}
+
+// encrypt seals a secret with AES-GCM, bound to the username it belongs to.
+func (s *TOTPService) encrypt(username, plain string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), []byte(username))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *TOTPService) decrypt(username, encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", fmt.Errorf("malformed totp secret")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, []byte(username))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt totp secret: %v", err)
+	}
+	return string(plain), nil
+}
+
+func newEnrollment(key *otp.Key) (*Enrollment, error) {
+	img, err := key.Image(240, 240)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render qr code: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode qr code: %v", err)
+	}
+	return &Enrollment{
+		Secret: key.Secret(),
+		URL:    key.URL(),
+		QRCode: template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())),
+	}, nil
+}
+
+// generateRecoveryCodes returns new recovery codes formatted as xxxxx-xxxxx
+// together with the hashes to store.
+func generateRecoveryCodes(username string) ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(username, code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code after normalizing case and separators.
+// Recovery codes are random, so a plain salted hash is sufficient.
+func hashRecoveryCode(username, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(username + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,15 @@
+package certs
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+	"time"
+)
+
+type Reloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	certMod  time.Time
+	keyMod   time.Time
+}
@@ -1,15 +1,23 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/frodejac/globster/internal/auth/google"
 	"github.com/frodejac/globster/internal/auth/static"
+	"github.com/frodejac/globster/internal/inspect"
+	"github.com/frodejac/globster/internal/janitor"
+	"github.com/frodejac/globster/internal/mail"
+	"github.com/frodejac/globster/internal/proxy"
+	"github.com/frodejac/globster/internal/scanner"
+	"github.com/frodejac/globster/internal/tracing"
 	"golang.org/x/time/rate"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -29,16 +37,56 @@ const (
 )
 
 type AuthConfig struct {
-	Type      AuthType
-	RateLimit rate.Limit
-	Google    *google.Config
-	Static    *static.Config
+	Type            AuthType
+	RateLimit       rate.Limit
+	RateBurst       int
+	MaxFailures     int
+	LockoutDuration time.Duration
+	Google          *google.Config
+	Static          *static.Config
+	TOTP            *TOTPConfig
+	Passkeys        *PasskeyConfig
+}
+
+type PasskeyConfig struct {
+	Enabled       bool
+	RPID          string
+	RPDisplayName string
+	Origins       []string
+}
+
+type TOTPConfig struct {
+	Issuer        string
+	EncryptionKey []byte
+	Required      bool
 }
 
 type ServerConfig struct {
 	Port               string
 	UseHsts            bool
 	UseSecurityHeaders bool
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownTimeout    time.Duration
+	UploadTimeout      time.Duration
+	DownloadTimeout    time.Duration
+	TLS                *TLSConfig
+	TrustedProxies     []netip.Prefix
+}
+
+type TLSConfig struct {
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string
+	RedirectPort      string
+	ReloadInterval    time.Duration
+	RequireClientCert bool
+}
+
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
 }
 
 type DatabaseConfig struct {
@@ -54,15 +102,33 @@ type SessionCookieConfig struct {
 }
 
 type SessionConfig struct {
-	Lifetime time.Duration
-	Cookie   *SessionCookieConfig
+	Lifetime      time.Duration
+	IdleTimeout   time.Duration
+	SweepInterval time.Duration
+	Cookie        *SessionCookieConfig
+}
+
+type LinkPasswordConfig struct {
+	AccessLifetime  time.Duration
+	MaxFailures     int
+	LockoutDuration time.Duration
 }
 
 type UploadConfig struct {
-	Path              string
-	MaxFileSize       int64
-	AllowedMimeTypes  []string
-	AllowedExtensions []string
+	Path                  string
+	TokenMaxFailures      int
+	TokenBanDuration      time.Duration
+	LinkPassword          *LinkPasswordConfig
+	RecipientCodeLifetime time.Duration
+	MaxFileSize           int64
+	AllowedMimeTypes      []string
+	AllowedExtensions     []string
+	// ComputeMd5 records an MD5 checksum next to the SHA-256 of each upload
+	ComputeMd5 bool
+}
+
+type HealthConfig struct {
+	MinFreeDiskBytes uint64
 }
 
 type LoggerConfig struct {
@@ -81,144 +147,233 @@ type Config struct {
 	Session       *SessionConfig
 	Upload        *UploadConfig
 	Auth          *AuthConfig
+	Tracing       *tracing.Config
+	Health        *HealthConfig
+	Mail          *mail.Config
+	Janitor       *janitor.Config
+	Scanner       *scanner.Config
 }
 
+// LoadConfig loads the configuration from the file named by CONFIG_FILE, if
+// set, with environment variables taking precedence over the file.
 func LoadConfig() (*Config, error) {
-	allowedDomainsStr := os.Getenv("ALLOWED_DOMAINS")
-	if allowedDomainsStr == "" {
-		allowedDomainsStr = "*"
-	}
-	allowedDomains := []string{}
-	if allowedDomainsStr != "*" {
-		allowedDomains = strings.Split(allowedDomainsStr, ",")
-	}
-	allowedGroupsStr := os.Getenv("ALLOWED_GROUPS")
-	if allowedGroupsStr == "" {
-		allowedGroupsStr = "*"
-	}
-	allowedGroups := []string{}
-	if allowedGroupsStr != "*" {
-		allowedGroups = strings.Split(allowedGroupsStr, ",")
-	}
+	cfg, _, err := Load(os.Getenv("CONFIG_FILE"))
+	return cfg, err
+}
 
-	allowedMimeTypesStr := os.Getenv("ALLOWED_MIME_TYPES")
-	if allowedMimeTypesStr == "" {
-		allowedMimeTypesStr = "text/plain"
-	}
-	allowedMimeTypes := strings.Split(allowedMimeTypesStr, ",")
+// Load loads the configuration from the config file at path, which may be
+// empty, and the environment. It returns the raw settings along with the
+// configuration, and an error listing every invalid setting.
+func Load(path string) (*Config, []Setting, error) {
+	l := newLoader(path)
 
-	allowedExtensionsStr := os.Getenv("ALLOWED_EXTENSIONS")
-	if allowedExtensionsStr == "" {
-		allowedExtensionsStr = ".txt"
-	}
-	allowedExtensions := strings.Split(allowedExtensionsStr, ",")
-	authTypeStr := os.Getenv("AUTH_TYPE")
-	if authTypeStr == "" {
-		authTypeStr = "static"
+	allowedDomains := l.list("ALLOWED_DOMAINS", "*", ",")
+	if len(allowedDomains) == 1 && allowedDomains[0] == "*" {
+		allowedDomains = []string{}
+	}
+	allowedGroups := l.list("ALLOWED_GROUPS", "*", ",")
+	if len(allowedGroups) == 1 && allowedGroups[0] == "*" {
+		allowedGroups = []string{}
+	}
+	allowedMimeTypes := l.list("ALLOWED_MIME_TYPES", "text/plain", ",")
+	for _, mimeType := range allowedMimeTypes {
+		if !inspect.ValidPattern(mimeType) {
+			l.errorf("ALLOWED_MIME_TYPES: invalid MIME type %q, must be type/subtype, type/* or */*", mimeType)
+		}
 	}
-	authType := AuthType(authTypeStr)
+	allowedExtensions := l.list("ALLOWED_EXTENSIONS", ".txt", ",")
+
+	authType := AuthType(l.string("AUTH_TYPE", string(AuthTypeStatic)))
 	if authType != AuthTypeStatic && authType != AuthTypeGoogle {
-		return nil, fmt.Errorf("invalid AUTH_TYPE: %s", authTypeStr)
+		l.errorf("AUTH_TYPE: must be %s or %s, got %q", AuthTypeStatic, AuthTypeGoogle, authType)
 	}
 
-	baseURL := os.Getenv("BASE_URL")
-	cookieSecure := os.Getenv("COOKIE_SECURE") == "true"
-	databasePath := os.Getenv("DATABASE_PATH")
-	if databasePath == "" {
-		databasePath = "globster.db"
-	}
-	isDevelopment := os.Getenv("ENVIRONMENT") == "development"
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	googleServiceAccountConfigJsonPath := os.Getenv("GOOGLE_SERVICE_ACCOUNT_CONFIG_JSON_PATH")
-	host := os.Getenv("HOST")
-	if host == "" {
-		host = "localhost"
+	tlsCertFile := l.string("TLS_CERT_FILE", "")
+	tlsKeyFile := l.string("TLS_KEY_FILE", "")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		l.errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
-
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	if logLevelStr == "" {
-		logLevelStr = "info"
+	tlsEnabled := tlsCertFile != "" && tlsKeyFile != ""
+	tlsClientCAFile := l.string("TLS_CLIENT_CA_FILE", "")
+	if tlsClientCAFile != "" && !tlsEnabled {
+		l.errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
 	}
-	logLevel, _ := getLogLevel(logLevelStr)
-	logFormatStr := os.Getenv("LOG_FORMAT")
-	if logFormatStr == "" {
-		logFormatStr = "text"
+	tlsRedirectPort := l.string("TLS_REDIRECT_PORT", "")
+	l.port("TLS_REDIRECT_PORT", tlsRedirectPort)
+	if tlsRedirectPort != "" && !tlsEnabled {
+		l.errorf("TLS_REDIRECT_PORT requires TLS_CERT_FILE and TLS_KEY_FILE")
 	}
-	logFormat := LogFormat(logFormatStr)
-	if logFormat != LogFormatText && logFormat != LogFormatJSON {
-		logFormat = LogFormatText
+	tlsReloadInterval := l.duration("TLS_RELOAD_INTERVAL", time.Minute)
+	if tlsReloadInterval == 0 {
+		l.errorf("TLS_RELOAD_INTERVAL: must be positive")
 	}
 
-	maxFileSizeStr := os.Getenv("MAX_FILE_SIZE_BYTES")
-	if maxFileSizeStr == "" {
-		maxFileSizeStr = "10485760" // 10 MB
-	}
-	maxFileSize, err := strconv.ParseInt(maxFileSizeStr, 10, 64)
+	trustedProxies, err := proxy.ParseTrustedProxies(l.lookup("TRUSTED_PROXIES", "", ",", false))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse MAX_FILE_SIZE_BYTES: %v", err)
+		l.errorf("TRUSTED_PROXIES: %v", err)
 	}
 
-	scopes := os.Getenv("SCOPES")
-	if scopes == "" {
-		scopes = fmt.Sprintf(
-			"%s %s %s",
-			oidc.ScopeOpenID,
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		)
-	}
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = "8080"
-	}
-	serverUseHstsStr := os.Getenv("USE_HSTS")
-	if serverUseHstsStr == "" {
-		serverUseHstsStr = "false"
-	}
-	serverUseHsts, err := strconv.ParseBool(serverUseHstsStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse USE_HSTS: %v", err)
-	}
-	serverUseSecurityHeadersStr := os.Getenv("USE_SECURITY_HEADERS")
-	if serverUseSecurityHeadersStr == "" {
-		serverUseSecurityHeadersStr = "false"
-	}
-	serverUseSecurityHeaders, err := strconv.ParseBool(serverUseSecurityHeadersStr)
+	// Secure cookies and HSTS by default when serving TLS ourselves
+	cookieSecure := l.bool("COOKIE_SECURE", tlsEnabled)
+	databasePath := l.string("DATABASE_PATH", "globster.db")
+	isDevelopment := l.string("ENVIRONMENT", "") == "development"
+	googleClientID := l.string("GOOGLE_CLIENT_ID", "")
+	googleClientSecret := l.secret("GOOGLE_CLIENT_SECRET")
+	googleServiceAccountConfigJsonPath := l.string("GOOGLE_SERVICE_ACCOUNT_CONFIG_JSON_PATH", "")
+
+	logLevelStr := l.string("LOG_LEVEL", "info")
+	logLevel, err := getLogLevel(logLevelStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse USE_SECURITY_HEADERS: %v", err)
+		l.errorf("LOG_LEVEL: %v", err)
 	}
-	sessionLifetimeStr := os.Getenv("SESSION_LIFETIME")
-	if sessionLifetimeStr == "" {
-		sessionLifetimeStr = "8h"
+	logFormat := LogFormat(l.string("LOG_FORMAT", string(LogFormatText)))
+	if logFormat != LogFormatText && logFormat != LogFormatJSON {
+		l.errorf("LOG_FORMAT: must be %s or %s, got %q", LogFormatText, LogFormatJSON, logFormat)
 	}
-	staticAuthPath := os.Getenv("STATIC_AUTH_PATH")
-	if staticAuthPath == "" {
-		staticAuthPath = "users.json"
+
+	maxFileSize := l.int64("MAX_FILE_SIZE_BYTES", 10<<20)
+	l.atLeast("MAX_FILE_SIZE_BYTES", maxFileSize, 1)
+	minFreeDisk := l.uint64("READY_MIN_FREE_DISK_BYTES", 100<<20)
+
+	tokenMaxFailures := l.int("TOKEN_MAX_FAILURES", 10)
+	l.atLeast("TOKEN_MAX_FAILURES", int64(tokenMaxFailures), 0)
+	tokenBanDuration := l.duration("TOKEN_BAN_DURATION", time.Hour)
+
+	linkAccessLifetime := l.duration("LINK_ACCESS_LIFETIME", time.Hour)
+	linkPasswordMaxFailures := l.int("LINK_PASSWORD_MAX_FAILURES", 5)
+	l.atLeast("LINK_PASSWORD_MAX_FAILURES", int64(linkPasswordMaxFailures), 0)
+	linkPasswordLockout := l.duration("LINK_PASSWORD_LOCKOUT_DURATION", 15*time.Minute)
+
+	scopes := l.list("SCOPES", strings.Join([]string{
+		oidc.ScopeOpenID,
+		"https://www.googleapis.com/auth/userinfo.email",
+		"https://www.googleapis.com/auth/userinfo.profile",
+	}, " "), " ")
+	serverPort := l.string("SERVER_PORT", "8080")
+	l.port("SERVER_PORT", serverPort)
+	serverUseHsts := l.bool("USE_HSTS", tlsEnabled)
+	serverUseSecurityHeaders := l.bool("USE_SECURITY_HEADERS", false)
+	serverTimeouts := []struct {
+		name string
+		def  time.Duration
+	}{
+		{"SERVER_READ_HEADER_TIMEOUT", 10 * time.Second},
+		{"SERVER_READ_TIMEOUT", 30 * time.Second},
+		{"SERVER_WRITE_TIMEOUT", 30 * time.Second},
+		{"SERVER_IDLE_TIMEOUT", 120 * time.Second},
+		{"SERVER_SHUTDOWN_TIMEOUT", 30 * time.Second},
+		{"UPLOAD_TIMEOUT", time.Hour},
+		{"DOWNLOAD_TIMEOUT", time.Hour},
+	}
+	timeouts := make(map[string]time.Duration, len(serverTimeouts))
+	for _, timeout := range serverTimeouts {
+		timeouts[timeout.name] = l.duration(timeout.name, timeout.def)
+	}
+	sessionLifetime := l.duration("SESSION_LIFETIME", 8*time.Hour)
+	if sessionLifetime == 0 {
+		l.errorf("SESSION_LIFETIME: must be positive")
+	}
+	// Zero keeps sessions valid for their whole lifetime regardless of use
+	sessionIdleTimeout := l.duration("SESSION_IDLE_TIMEOUT", 0)
+	sessionSweepInterval := l.duration("SESSION_SWEEP_INTERVAL", time.Hour)
+	if sessionSweepInterval == 0 {
+		l.errorf("SESSION_SWEEP_INTERVAL: must be positive")
+	}
+	staticAuthPath := l.string("STATIC_AUTH_PATH", "users.json")
+	passwordMinLength := l.int("PASSWORD_MIN_LENGTH", 12)
+	l.atLeast("PASSWORD_MIN_LENGTH", int64(passwordMinLength), 1)
+	passwordMinClasses := l.int("PASSWORD_MIN_CLASSES", 2)
+	if passwordMinClasses < 1 || passwordMinClasses > 4 {
+		l.errorf("PASSWORD_MIN_CLASSES: must be between 1 and 4, got %d", passwordMinClasses)
+	}
+	// Zero or less means no rate limit
+	staticAuthRateLimit := rate.Limit(l.float("STATIC_AUTH_RATE_LIMIT", 0))
+	if staticAuthRateLimit <= 0 {
+		staticAuthRateLimit = rate.Inf
 	}
-	staticAuthRateLimitStr := os.Getenv("STATIC_AUTH_RATE_LIMIT")
-	if staticAuthRateLimitStr == "" {
-		staticAuthRateLimitStr = "0" // no rate limit
+	staticAuthRateBurst := l.int("STATIC_AUTH_RATE_BURST", 1)
+	l.atLeast("STATIC_AUTH_RATE_BURST", int64(staticAuthRateBurst), 1)
+	loginMaxFailures := l.int("LOGIN_MAX_FAILURES", 5)
+	l.atLeast("LOGIN_MAX_FAILURES", int64(loginMaxFailures), 0)
+	loginLockoutDuration := l.duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	staticPath := l.string("STATIC_PATH", "web/static")
+	tracingEnabled := l.bool("TRACING_ENABLED", false)
+	tracingSampleRatio := l.float("TRACING_SAMPLE_RATIO", 1)
+	if tracingSampleRatio < 0 || tracingSampleRatio > 1 {
+		l.errorf("TRACING_SAMPLE_RATIO: must be between 0 and 1, got %v", tracingSampleRatio)
+	}
+	tracingServiceName := l.string("OTEL_SERVICE_NAME", "globster")
+	totpIssuer := l.string("TOTP_ISSUER", "Globster")
+	var totpEncryptionKey []byte
+	if totpEncryptionKeyStr := l.secret("TOTP_ENCRYPTION_KEY"); totpEncryptionKeyStr != "" {
+		totpEncryptionKey, err = base64.StdEncoding.DecodeString(totpEncryptionKeyStr)
+		if err != nil || len(totpEncryptionKey) != 32 {
+			l.errorf("TOTP_ENCRYPTION_KEY: must be 32 bytes, base64 encoded")
+		}
 	}
-	staticAuthRateLimitValue, err := strconv.ParseFloat(staticAuthRateLimitStr, 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse STATIC_AUTH_RATE_LIMIT: %v", err)
+	totpRequired := l.bool("TOTP_REQUIRED", false)
+	if totpRequired && authType == AuthTypeStatic && totpEncryptionKey == nil {
+		l.errorf("TOTP_ENCRYPTION_KEY is required when TOTP_REQUIRED is true")
+	}
+	smtpHost := l.string("SMTP_HOST", "")
+	smtpPort := l.string("SMTP_PORT", "587")
+	l.port("SMTP_PORT", smtpPort)
+	smtpUsername := l.string("SMTP_USERNAME", "")
+	smtpPassword := l.secret("SMTP_PASSWORD")
+	smtpFrom := l.string("SMTP_FROM", "")
+	if smtpHost != "" && smtpFrom == "" {
+		l.errorf("SMTP_FROM is required when SMTP_HOST is set")
+	}
+	recipientCodeLifetime := l.duration("RECIPIENT_CODE_LIFETIME", 10*time.Minute)
+	templatePath := l.string("TEMPLATE_PATH", "web/templates")
+	uploadPath := l.string("UPLOAD_PATH", "uploads")
+
+	janitorInterval := l.duration("JANITOR_INTERVAL", time.Hour)
+	if janitorInterval == 0 {
+		l.errorf("JANITOR_INTERVAL: must be positive")
+	}
+	linkRetentionDays := l.int("LINK_RETENTION_DAYS", 30)
+	l.atLeast("LINK_RETENTION_DAYS", int64(linkRetentionDays), 0)
+	linkRetentionAction := l.string("LINK_RETENTION_ACTION", "archive")
+	if linkRetentionAction != "archive" && linkRetentionAction != "purge" {
+		l.errorf("LINK_RETENTION_ACTION: must be archive or purge, got %q", linkRetentionAction)
+	}
+	fileRetentionDays := l.int("FILE_RETENTION_DAYS", 0)
+	l.atLeast("FILE_RETENTION_DAYS", int64(fileRetentionDays), 0)
+	trashRetentionDays := l.int("TRASH_RETENTION_DAYS", 30)
+	l.atLeast("TRASH_RETENTION_DAYS", int64(trashRetentionDays), 0)
+	janitorCfg := &janitor.Config{
+		BaseDir:         uploadPath,
+		Interval:        janitorInterval,
+		LinkRetention:   time.Duration(linkRetentionDays) * 24 * time.Hour,
+		ArchiveLinks:    linkRetentionAction == "archive",
+		FileRetention:   time.Duration(fileRetentionDays) * 24 * time.Hour,
+		TrashRetention:  time.Duration(trashRetentionDays) * 24 * time.Hour,
+		RemoveEmptyDirs: l.bool("JANITOR_REMOVE_EMPTY_DIRS", true),
+		DryRun:          l.bool("JANITOR_DRY_RUN", false),
 	}
-	staticAuthRateLimit := rate.Limit(staticAuthRateLimitValue)
-	if staticAuthRateLimit <= 0 {
-		staticAuthRateLimit = rate.Inf
+
+	scannerType := l.string("SCANNER", scanner.TypeNone)
+	switch scannerType {
+	case scanner.TypeNone, scanner.TypeClamd, scanner.TypeFake:
+	default:
+		l.errorf("SCANNER: must be none, clamd or fake, got %q", scannerType)
 	}
-	staticPath := os.Getenv("STATIC_PATH")
-	if staticPath == "" {
-		staticPath = "web/static"
+	scannerTimeout := l.duration("SCANNER_TIMEOUT", time.Minute)
+	if scannerTimeout == 0 {
+		l.errorf("SCANNER_TIMEOUT: must be positive")
 	}
-	templatePath := os.Getenv("TEMPLATE_PATH")
-	if templatePath == "" {
-		templatePath = "web/templates"
+	scannerNotifyEmails := l.list("SCANNER_NOTIFY_EMAILS", "", ",")
+	if len(scannerNotifyEmails) > 0 && smtpHost == "" {
+		l.errorf("SMTP_HOST is required when SCANNER_NOTIFY_EMAILS is set")
 	}
-	uploadPath := os.Getenv("UPLOAD_PATH")
-	if uploadPath == "" {
-		uploadPath = "uploads"
+	scannerCfg := &scanner.Config{
+		Type:         scannerType,
+		ClamdAddress: l.string("CLAMD_ADDRESS", "localhost:3310"),
+		Timeout:      scannerTimeout,
+		FailOpen:     l.bool("SCANNER_FAIL_OPEN", false),
+		BaseDir:      uploadPath,
+		NotifyEmails: scannerNotifyEmails,
 	}
 
 	googleAuth := &google.Config{
@@ -227,37 +382,64 @@ func LoadConfig() (*Config, error) {
 		Issuer:                       "https://accounts.google.com",
 		ClientID:                     googleClientID,
 		ClientSecret:                 googleClientSecret,
+		CookieSecure:                 cookieSecure,
 		ServiceAccountConfigJsonPath: googleServiceAccountConfigJsonPath,
-		Scopes:                       strings.Split(scopes, " "),
+		Scopes:                       scopes,
 	}
 	if authType == AuthTypeGoogle {
 		if err := googleAuth.Validate(); err != nil {
-			return nil, fmt.Errorf("failed to validate Google auth config: %v", err)
+			l.errorf("failed to validate Google auth config: %v", err)
 		}
 	}
 	server := &ServerConfig{
 		Port:               serverPort,
 		UseHsts:            serverUseHsts,
 		UseSecurityHeaders: serverUseSecurityHeaders,
+		ReadHeaderTimeout:  timeouts["SERVER_READ_HEADER_TIMEOUT"],
+		ReadTimeout:        timeouts["SERVER_READ_TIMEOUT"],
+		WriteTimeout:       timeouts["SERVER_WRITE_TIMEOUT"],
+		IdleTimeout:        timeouts["SERVER_IDLE_TIMEOUT"],
+		ShutdownTimeout:    timeouts["SERVER_SHUTDOWN_TIMEOUT"],
+		UploadTimeout:      timeouts["UPLOAD_TIMEOUT"],
+		DownloadTimeout:    timeouts["DOWNLOAD_TIMEOUT"],
+		TrustedProxies:     trustedProxies,
+		TLS: &TLSConfig{
+			CertFile:          tlsCertFile,
+			KeyFile:           tlsKeyFile,
+			ClientCAFile:      tlsClientCAFile,
+			RedirectPort:      tlsRedirectPort,
+			ReloadInterval:    tlsReloadInterval,
+			RequireClientCert: tlsClientCAFile != "",
+		},
 	}
 	database := &DatabaseConfig{
 		Path: databasePath,
 	}
 	auth := &AuthConfig{
-		Type:      authType,
-		RateLimit: staticAuthRateLimit,
-		Google:    googleAuth,
+		Type:            authType,
+		RateLimit:       staticAuthRateLimit,
+		RateBurst:       staticAuthRateBurst,
+		MaxFailures:     loginMaxFailures,
+		LockoutDuration: loginLockoutDuration,
+		Google:          googleAuth,
 		Static: &static.Config{
 			UsersJsonPath: staticAuthPath,
+			Policy: &static.PasswordPolicy{
+				MinLength:  passwordMinLength,
+				MinClasses: passwordMinClasses,
+			},
+		},
+		TOTP: &TOTPConfig{
+			Issuer:        totpIssuer,
+			EncryptionKey: totpEncryptionKey,
+			Required:      totpRequired,
 		},
 	}
 
-	sessionLifetime, err := time.ParseDuration(sessionLifetimeStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse SESSION_LIFETIME: %v", err)
-	}
 	session := &SessionConfig{
-		Lifetime: sessionLifetime,
+		Lifetime:      sessionLifetime,
+		IdleTimeout:   sessionIdleTimeout,
+		SweepInterval: sessionSweepInterval,
 		Cookie: &SessionCookieConfig{
 			Name:     "session",
 			Path:     "/",
@@ -268,19 +450,52 @@ func LoadConfig() (*Config, error) {
 	}
 
 	upload := &UploadConfig{
-		Path:              uploadPath,
-		MaxFileSize:       maxFileSize,
-		AllowedMimeTypes:  allowedMimeTypes,
-		AllowedExtensions: allowedExtensions,
+		Path:             uploadPath,
+		TokenMaxFailures: tokenMaxFailures,
+		TokenBanDuration: tokenBanDuration,
+		LinkPassword: &LinkPasswordConfig{
+			AccessLifetime:  linkAccessLifetime,
+			MaxFailures:     linkPasswordMaxFailures,
+			LockoutDuration: linkPasswordLockout,
+		},
+		RecipientCodeLifetime: recipientCodeLifetime,
+		MaxFileSize:           maxFileSize,
+		AllowedMimeTypes:      allowedMimeTypes,
+		AllowedExtensions:     allowedExtensions,
+		ComputeMd5:            l.bool("UPLOAD_COMPUTE_MD5", false),
 	}
 
-	if baseURL == "" {
-		baseURL = "http://localhost:" + serverPort
+	tracingCfg := &tracing.Config{
+		Enabled:     tracingEnabled,
+		ServiceName: tracingServiceName,
+		SampleRatio: tracingSampleRatio,
+	}
+
+	scheme := "http"
+	if tlsEnabled {
+		scheme = "https"
+	}
+	baseURL := strings.TrimSuffix(l.string("BASE_URL", scheme+"://localhost:"+serverPort), "/")
+
+	// Passkeys are bound to the host of the base URL unless configured otherwise
+	parsedBaseURL, err := url.Parse(baseURL)
+	if err != nil || parsedBaseURL.Host == "" {
+		l.errorf("BASE_URL: invalid URL %q", baseURL)
+		parsedBaseURL = &url.URL{}
+	}
+	webauthnRPID := l.string("WEBAUTHN_RP_ID", parsedBaseURL.Hostname())
+	webauthnRPName := l.string("WEBAUTHN_RP_NAME", "Globster")
+	webauthnOrigins := l.list("WEBAUTHN_ORIGINS", parsedBaseURL.Scheme+"://"+parsedBaseURL.Host, ",")
+	auth.Passkeys = &PasskeyConfig{
+		Enabled:       l.bool("WEBAUTHN_ENABLED", false),
+		RPID:          webauthnRPID,
+		RPDisplayName: webauthnRPName,
+		Origins:       webauthnOrigins,
 	}
 
 	logger := &LoggerConfig{
 		Level:  logLevel,
-		Format: LogFormatText,
+		Format: logFormat,
 	}
 
 	cfg := &Config{
@@ -294,8 +509,24 @@ func LoadConfig() (*Config, error) {
 		Session:       session,
 		Upload:        upload,
 		Auth:          auth,
+		Tracing:       tracingCfg,
+		Health: &HealthConfig{
+			MinFreeDiskBytes: minFreeDisk,
+		},
+		Mail: &mail.Config{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: smtpUsername,
+			Password: smtpPassword,
+			From:     smtpFrom,
+		},
+		Janitor: janitorCfg,
+		Scanner: scannerCfg,
+	}
+	if err := l.err(); err != nil {
+		return nil, l.settings, err
 	}
-	return cfg, nil
+	return cfg, l.settings, nil
 }
 
 func getLogLevel(levelStr string) (slog.Level, error) {
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources a setting can come from, in increasing order of precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// Setting is a raw configuration value and where it came from.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// loader looks up settings in the environment, then the config file, then
+// the default. Parse and validation errors are collected instead of returned
+// so they can all be reported at once.
+type loader struct {
+	file     map[string]any
+	used     map[string]bool
+	settings []Setting
+	errs     []error
+}
+
+func newLoader(path string) *loader {
+	l := &loader{
+		file: map[string]any{},
+		used: map[string]bool{},
+	}
+	if path != "" {
+		l.readFile(path)
+	}
+	return l
+}
+
+// readFile reads a YAML file of settings named like the environment
+// variables, in any case. Lists may be written as YAML sequences.
+func (l *loader) readFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errorf("failed to read config file: %v", err)
+		return
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		l.errorf("failed to parse config file %s: %v", path, err)
+		return
+	}
+	for key, value := range values {
+		name := strings.ToUpper(key)
+		switch v := value.(type) {
+		case nil:
+			l.file[name] = ""
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			l.file[name] = items
+		case map[string]any:
+			l.errorf("%s: expected a value or a list, not a mapping", name)
+		default:
+			l.file[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+func (l *loader) errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// err returns every error collected, including settings in the config file
+// that were never looked up.
+func (l *loader) err() error {
+	var unknown []string
+	for name := range l.file {
+		if !l.used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		l.errorf("%s: unknown setting in config file", name)
+	}
+	return errors.Join(l.errs...)
+}
+
+func (l *loader) lookup(name, def, sep string, secret bool) string {
+	l.used[name] = true
+	value, source := def, SourceDefault
+	switch v := l.file[name].(type) {
+	case string:
+		if v != "" {
+			value, source = v, SourceFile
+		}
+	case []string:
+		if sep == "" {
+			l.errorf("%s: expected a single value, not a list", name)
+		}
+		if len(v) > 0 {
+			value, source = strings.Join(v, sep), SourceFile
+		}
+	}
+	// Empty values are treated as unset, so they keep the default
+	if v := os.Getenv(name); v != "" {
+		value, source = v, SourceEnv
+	}
+	l.settings = append(l.settings, Setting{Name: name, Value: value, Source: source, Secret: secret})
+	return value
+}
+
+func (l *loader) string(name, def string) string {
+	return l.lookup(name, def, "", false)
+}
+
+func (l *loader) secret(name string) string {
+	return l.lookup(name, "", "", true)
+}
+
+// list splits the setting on sep. The result is nil when the setting is empty.
+func (l *loader) list(name, def, sep string) []string {
+	value := l.lookup(name, def, sep, false)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, sep)
+}
+
+func (l *loader) int(name string, def int) int {
+	value := l.string(name, strconv.Itoa(def))
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		l.errorf("%s: invalid integer %q", name, value)
+		return def
+	}
+	return v
+}
+
+func (l *loader) int64(name string, def int64) int64 {
+	value := l.string(name, strconv.FormatInt(def, 10))
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		l.errorf("%s: invalid integer %q", name, value)
+		return def
+	}
+	return v
+}
+
+func (l *loader) uint64(name string, def uint64) uint64 {
+	value := l.string(name, strconv.FormatUint(def, 10))
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		l.errorf("%s: invalid non-negative integer %q", name, value)
+		return def
+	}
+	return v
+}
+
+func (l *loader) float(name string, def float64) float64 {
+	value := l.string(name, strconv.FormatFloat(def, 'f', -1, 64))
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.errorf("%s: invalid number %q", name, value)
+		return def
+	}
+	return v
+}
+
+func (l *loader) bool(name string, def bool) bool {
+	value := l.string(name, strconv.FormatBool(def))
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errorf("%s: invalid boolean %q", name, value)
+		return def
+	}
+	return v
+}
+
+func (l *loader) duration(name string, def time.Duration) time.Duration {
+	value := l.string(name, def.String())
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		l.errorf("%s: invalid duration %q", name, value)
+		return def
+	}
+	if v < 0 {
+		l.errorf("%s: must not be negative", name)
+	}
+	return v
+}
+
+// atLeast records an error if an integer setting is below min.
+func (l *loader) atLeast(name string, value, min int64) {
+	if value < min {
+		l.errorf("%s: must be at least %d, got %d", name, min, value)
+	}
+}
+
+func (l *loader) port(name, value string) {
+	if value == "" {
+		return
+	}
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		l.errorf("%s: invalid port %q", name, value)
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+)
+
+// RestartRequired lists the settings that differ between current and next but
+// are only read at startup. Reloading applies the allowed upload types, the
+// templates and the static auth settings; everything else needs a restart.
+func RestartRequired(current, next *Config) []string {
+	var changed []string
+	compare := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	compare("base_url", current.BaseUrl, next.BaseUrl)
+	compare("static_path", current.StaticPath, next.StaticPath)
+	compare("development", current.IsDevelopment, next.IsDevelopment)
+	compare("logger", current.Logger, next.Logger)
+	compare("server", current.Server, next.Server)
+	compare("database", current.Database, next.Database)
+	compare("session", current.Session, next.Session)
+	compare("upload", withoutReloadableUpload(current.Upload), withoutReloadableUpload(next.Upload))
+	compare("auth", withoutReloadableAuth(current.Auth), withoutReloadableAuth(next.Auth))
+	compare("tracing", current.Tracing, next.Tracing)
+	compare("health", current.Health, next.Health)
+	compare("mail", current.Mail, next.Mail)
+	compare("janitor", current.Janitor, next.Janitor)
+	compare("scanner", current.Scanner, next.Scanner)
+	return changed
+}
+
+func withoutReloadableUpload(c *UploadConfig) UploadConfig {
+	upload := *c
+	upload.AllowedExtensions = nil
+	upload.AllowedMimeTypes = nil
+	return upload
+}
+
+func withoutReloadableAuth(c *AuthConfig) AuthConfig {
+	auth := *c
+	auth.Static = nil
+	return auth
+}
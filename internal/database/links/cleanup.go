@@ -0,0 +1,154 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"time"
+)
+
+// Kinds of links kept in the archive
+const (
+	KindUpload   = "upload"
+	KindDownload = "download"
+)
+
+// InactiveLink is a link that can no longer be used and has not been used
+// or valid since a cutoff.
+type InactiveLink struct {
+	Kind  string
+	Token string
+	Dir   string
+}
+
+// ListInactiveLinks returns the upload and download links that expired before
+// the cutoff, or that were used up and last used before it.
+func (ls *Store) ListInactiveLinks(ctx context.Context, before time.Time) (_ []InactiveLink, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ListInactiveLinks")
+	defer tracing.End(span, &err)
+	rows, err := ls.db.QueryContext(
+		ctx,
+		`SELECT ?, token, dir FROM upload_links
+			WHERE expires_at < ? OR (remaining_uses <= 0 AND COALESCE(last_used_at, created_at) < ?)
+		UNION ALL
+		SELECT ?, token, dir FROM download_links
+			WHERE expires_at < ? OR (remaining_uses <= 0 AND COALESCE(last_used_at, created_at) < ?)`,
+		KindUpload, before, before,
+		KindDownload, before, before,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch inactive links: %v", err)
+	}
+	defer rows.Close()
+	links := make([]InactiveLink, 0)
+	for rows.Next() {
+		var link InactiveLink
+		if err := rows.Scan(&link.Kind, &link.Token, &link.Dir); err != nil {
+			return nil, fmt.Errorf("failed to scan inactive link: %v", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over inactive links: %v", err)
+	}
+	return links, nil
+}
+
+// PurgeLink deletes a link along with the recipients and download history
+// of download links.
+func (ls *Store) PurgeLink(ctx context.Context, kind, token string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.PurgeLink")
+	defer tracing.End(span, &err)
+	return ls.removeLink(ctx, kind, token, false)
+}
+
+// ArchiveLink moves a link to the archived_links table. The download history
+// of download links is kept.
+func (ls *Store) ArchiveLink(ctx context.Context, kind, token string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ArchiveLink")
+	defer tracing.End(span, &err)
+	return ls.removeLink(ctx, kind, token, true)
+}
+
+func (ls *Store) removeLink(ctx context.Context, kind, token string, archive bool) error {
+	table, err := linkTable(kind)
+	if err != nil {
+		return err
+	}
+	tx, err := ls.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if archive {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO archived_links (kind, token, dir, created_at, last_used_at, expires_at, remaining_uses, archived_at)
+			SELECT ?, token, dir, created_at, last_used_at, expires_at, remaining_uses, ? FROM `+table+` WHERE token = ?`,
+			kind,
+			time.Now(),
+			token,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to archive link: %v", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE token = ?", token); err != nil {
+		return fmt.Errorf("failed to delete link: %v", err)
+	}
+	if kind == KindDownload {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM download_link_recipients WHERE token = ?", token); err != nil {
+			return fmt.Errorf("failed to delete recipients: %v", err)
+		}
+		if !archive {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM download_link_accesses WHERE token = ?", token); err != nil {
+				return fmt.Errorf("failed to delete download history: %v", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// ActiveDirs returns the directories that usable links point to.
+func (ls *Store) ActiveDirs(ctx context.Context) (_ map[string]bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ActiveDirs")
+	defer tracing.End(span, &err)
+	now := time.Now()
+	rows, err := ls.db.QueryContext(
+		ctx,
+		`SELECT dir FROM upload_links WHERE remaining_uses > 0 AND expires_at > ?
+		UNION
+		SELECT dir FROM download_links WHERE remaining_uses > 0 AND expires_at > ?`,
+		now,
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch link directories: %v", err)
+	}
+	defer rows.Close()
+	dirs := make(map[string]bool)
+	for rows.Next() {
+		var dir string
+		if err := rows.Scan(&dir); err != nil {
+			return nil, fmt.Errorf("failed to scan link directory: %v", err)
+		}
+		dirs[dir] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over link directories: %v", err)
+	}
+	return dirs, nil
+}
+
+func linkTable(kind string) (string, error) {
+	switch kind {
+	case KindUpload:
+		return "upload_links", nil
+	case KindDownload:
+		return "download_links", nil
+	}
+	return "", fmt.Errorf("unknown link kind %q", kind)
+}
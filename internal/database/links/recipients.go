@@ -0,0 +1,189 @@
+package links
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"time"
+)
+
+var ErrRecipientNotFound = errors.New("recipient not found")
+
+func (ls *Store) AddDownloadLinkRecipients(ctx context.Context, token string, emails []string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.AddDownloadLinkRecipients")
+	defer tracing.End(span, &err)
+	tx, err := ls.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, email := range emails {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT OR IGNORE INTO download_link_recipients (token, email) VALUES (?, ?)",
+			token,
+			email,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add recipient: %v", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit recipients: %v", err)
+	}
+	return nil
+}
+
+func (ls *Store) ListDownloadLinkRecipients(ctx context.Context, token string) (_ []Recipient, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ListDownloadLinkRecipients")
+	defer tracing.End(span, &err)
+	recipients := make([]Recipient, 0)
+	rows, err := ls.db.QueryContext(
+		ctx,
+		"SELECT email, code_hash, code_expires_at, code_attempts, verified_at FROM download_link_recipients WHERE token = ? ORDER BY email",
+		token,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recipients: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var recipient Recipient
+		if err := rows.Scan(&recipient.Email, &recipient.CodeHash, &recipient.CodeExpiresAt, &recipient.CodeAttempts, &recipient.VerifiedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan recipient: %v", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over recipients: %v", err)
+	}
+	return recipients, nil
+}
+
+func (ls *Store) GetDownloadLinkRecipient(ctx context.Context, token, email string) (_ *Recipient, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.GetDownloadLinkRecipient")
+	defer tracing.End(span, &err)
+	var recipient Recipient
+	err = ls.db.QueryRowContext(
+		ctx,
+		"SELECT email, code_hash, code_expires_at, code_attempts, verified_at FROM download_link_recipients WHERE token = ? AND email = ?",
+		token,
+		email,
+	).Scan(&recipient.Email, &recipient.CodeHash, &recipient.CodeExpiresAt, &recipient.CodeAttempts, &recipient.VerifiedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecipientNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch recipient: %v", err)
+	}
+	return &recipient, nil
+}
+
+// SetDownloadLinkRecipientCode stores a new one-time code and resets the attempt counter.
+func (ls *Store) SetDownloadLinkRecipientCode(ctx context.Context, token, email, codeHash string, expiresAt time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.SetDownloadLinkRecipientCode")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
+		"UPDATE download_link_recipients SET code_hash = ?, code_expires_at = ?, code_attempts = 0 WHERE token = ? AND email = ?",
+		codeHash,
+		expiresAt,
+		token,
+		email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set recipient code: %v", err)
+	}
+	return nil
+}
+
+// IncrementDownloadLinkRecipientAttempts counts a guess of the recipient's
+// code with the given hash. It reports false, without counting it, when the
+// code has been replaced, maxAttempts guesses of this code have already been
+// made, or maxTotalAttempts guesses have been made since the recipient was
+// last verified, so concurrent guesses cannot exceed either limit.
+func (ls *Store) IncrementDownloadLinkRecipientAttempts(ctx context.Context, token, email, codeHash string, maxAttempts, maxTotalAttempts int) (_ bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.IncrementDownloadLinkRecipientAttempts")
+	defer tracing.End(span, &err)
+	res, err := ls.db.ExecContext(
+		ctx,
+		"UPDATE download_link_recipients SET code_attempts = code_attempts + 1, total_attempts = total_attempts + 1 WHERE token = ? AND email = ? AND code_hash = ? AND code_attempts < ? AND total_attempts < ?",
+		token,
+		email,
+		codeHash,
+		maxAttempts,
+		maxTotalAttempts,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update recipient attempts: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update recipient attempts: %v", err)
+	}
+	return n > 0, nil
+}
+
+// VerifyDownloadLinkRecipient marks the recipient as verified, clears the code
+// and resets the attempt counters.
+func (ls *Store) VerifyDownloadLinkRecipient(ctx context.Context, token, email string, verifiedAt time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.VerifyDownloadLinkRecipient")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
+		"UPDATE download_link_recipients SET code_hash = '', code_expires_at = NULL, code_attempts = 0, total_attempts = 0, verified_at = ? WHERE token = ? AND email = ?",
+		verifiedAt,
+		token,
+		email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify recipient: %v", err)
+	}
+	return nil
+}
+
+func (ls *Store) RecordDownloadLinkAccess(ctx context.Context, token, email, filename, clientIp string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.RecordDownloadLinkAccess")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
+		"INSERT INTO download_link_accesses (token, email, filename, client_ip, accessed_at) VALUES (?, ?, ?, ?, ?)",
+		token,
+		email,
+		filename,
+		clientIp,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record download access: %v", err)
+	}
+	return nil
+}
+
+func (ls *Store) ListDownloadLinkAccesses(ctx context.Context, token string) (_ []Access, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ListDownloadLinkAccesses")
+	defer tracing.End(span, &err)
+	accesses := make([]Access, 0)
+	rows, err := ls.db.QueryContext(
+		ctx,
+		"SELECT email, filename, client_ip, accessed_at FROM download_link_accesses WHERE token = ? ORDER BY accessed_at DESC",
+		token,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch download accesses: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var access Access
+		if err := rows.Scan(&access.Email, &access.Filename, &access.ClientIp, &access.AccessedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan download access: %v", err)
+		}
+		accesses = append(accesses, access)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over download accesses: %v", err)
+	}
+	return accesses, nil
+}
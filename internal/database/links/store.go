@@ -1,9 +1,12 @@
 package links
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/tracing"
 	"time"
 )
 
@@ -36,28 +39,79 @@ func (ls *Store) initialize() error {
 			remaining_uses INTEGER NOT NULL DEFAULT 1
 		)
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"upload_links", "download_links"} {
+		if err := database.AddColumn(ls.db, table, "password_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	_, err = ls.db.Exec(`
+		CREATE TABLE IF NOT EXISTS download_link_recipients (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token TEXT NOT NULL,
+			email TEXT NOT NULL,
+			code_hash TEXT NOT NULL DEFAULT '',
+			code_expires_at TIMESTAMP,
+			code_attempts INTEGER NOT NULL DEFAULT 0,
+			verified_at TIMESTAMP,
+			UNIQUE (token, email)
+		);
+		CREATE TABLE IF NOT EXISTS download_link_accesses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token TEXT NOT NULL,
+			email TEXT NOT NULL,
+			filename TEXT NOT NULL,
+			client_ip TEXT NOT NULL,
+			accessed_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_download_link_accesses_token ON download_link_accesses (token);
+		CREATE INDEX IF NOT EXISTS idx_upload_links_expires_at ON upload_links (expires_at);
+		CREATE INDEX IF NOT EXISTS idx_download_links_expires_at ON download_links (expires_at);
+		CREATE TABLE IF NOT EXISTS archived_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			token TEXT NOT NULL,
+			dir TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			remaining_uses INTEGER NOT NULL,
+			archived_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	// Unlike code_attempts, this isn't reset when a new code is sent
+	return database.AddColumn(ls.db, "download_link_recipients", "total_attempts", "INTEGER NOT NULL DEFAULT 0")
 }
 
-func (ls *Store) ListActiveUploadLinks() ([]UploadLink, error) {
-	return ls.ListUploadLinks(true)
+func (ls *Store) ListActiveUploadLinks(ctx context.Context) ([]UploadLink, error) {
+	return ls.ListUploadLinks(ctx, true)
 }
 
-func (ls *Store) ListUploadLinks(active bool) ([]UploadLink, error) {
+func (ls *Store) ListUploadLinks(ctx context.Context, active bool) (_ []UploadLink, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ListUploadLinks")
+	defer tracing.End(span, &err)
 	links := make([]UploadLink, 0)
-	rows, err := ls.db.Query("SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at FROM upload_links")
+	query := "SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at, password_hash FROM upload_links"
+	var args []any
+	if active {
+		query += " WHERE remaining_uses > 0 AND expires_at > ?"
+		args = append(args, time.Now())
+	}
+	rows, err := ls.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch upload links: %v", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var link UploadLink
-		if err := rows.Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt); err != nil {
+		if err := rows.Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt, &link.PasswordHash); err != nil {
 			return nil, fmt.Errorf("failed to scan upload link: %v", err)
 		}
-		if active && (link.RemainingUses <= 0 || link.ExpiresAt.Before(time.Now())) {
-			continue
-		}
 		link.Url = fmt.Sprintf("/upload/%s", link.Token)
 		links = append(links, link)
 	}
@@ -67,14 +121,18 @@ func (ls *Store) ListUploadLinks(active bool) ([]UploadLink, error) {
 	return links, nil
 }
 
-func (ls *Store) CreateUploadLink(token, dir string, expiresAt time.Time, remainingUses int) error {
-	_, err := ls.db.Exec(
-		"INSERT INTO upload_links (token, dir, expires_at, remaining_uses, created_at) VALUES (?, ?, ?, ?, ?)",
+func (ls *Store) CreateUploadLink(ctx context.Context, token, dir string, expiresAt time.Time, remainingUses int, passwordHash string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.CreateUploadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
+		"INSERT INTO upload_links (token, dir, expires_at, remaining_uses, created_at, password_hash) VALUES (?, ?, ?, ?, ?, ?)",
 		token,
 		dir,
 		expiresAt,
 		remainingUses,
 		time.Now(),
+		passwordHash,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create upload link: %v", err)
@@ -82,12 +140,15 @@ func (ls *Store) CreateUploadLink(token, dir string, expiresAt time.Time, remain
 	return nil
 }
 
-func (ls *Store) DeactivateUploadLink(token string) error {
-	return ls.UpdateUploadLink(token, 0, time.Now())
+func (ls *Store) DeactivateUploadLink(ctx context.Context, token string) error {
+	return ls.UpdateUploadLink(ctx, token, 0, time.Now())
 }
 
-func (ls *Store) DeleteUploadLink(token string) error {
-	_, err := ls.db.Exec(
+func (ls *Store) DeleteUploadLink(ctx context.Context, token string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.DeleteUploadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
 		"DELETE FROM upload_links WHERE token = ?",
 		token,
 	)
@@ -97,15 +158,18 @@ func (ls *Store) DeleteUploadLink(token string) error {
 	return nil
 }
 
-func (ls *Store) GetUploadLink(token string) (*UploadLink, error) {
+func (ls *Store) GetUploadLink(ctx context.Context, token string) (_ *UploadLink, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.GetUploadLink")
+	defer tracing.End(span, &err)
 	var link UploadLink
-	err := ls.db.QueryRow(
-		"SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at FROM upload_links WHERE token = ?",
+	err = ls.db.QueryRowContext(
+		ctx,
+		"SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at, password_hash FROM upload_links WHERE token = ?",
 		token,
-	).Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt)
+	).Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt, &link.PasswordHash)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("upload link not found")
+			return nil, fmt.Errorf("upload %w", ErrLinkNotFound)
 		}
 		return nil, fmt.Errorf("failed to fetch upload link: %v", err)
 	}
@@ -113,8 +177,11 @@ func (ls *Store) GetUploadLink(token string) (*UploadLink, error) {
 	return &link, nil
 }
 
-func (ls *Store) UpdateUploadLink(token string, remainingUses int, lastUsed time.Time) error {
-	_, err := ls.db.Exec(
+func (ls *Store) UpdateUploadLink(ctx context.Context, token string, remainingUses int, lastUsed time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.UpdateUploadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
 		"UPDATE upload_links SET remaining_uses = ?, last_used_at = ? WHERE token = ?",
 		remainingUses,
 		lastUsed,
@@ -126,25 +193,30 @@ func (ls *Store) UpdateUploadLink(token string, remainingUses int, lastUsed time
 	return nil
 }
 
-func (ls *Store) ListActiveDownloadLinks() ([]DownloadLink, error) {
-	return ls.ListDownloadLinks(true)
+func (ls *Store) ListActiveDownloadLinks(ctx context.Context) ([]DownloadLink, error) {
+	return ls.ListDownloadLinks(ctx, true)
 }
 
-func (ls *Store) ListDownloadLinks(active bool) ([]DownloadLink, error) {
+func (ls *Store) ListDownloadLinks(ctx context.Context, active bool) (_ []DownloadLink, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.ListDownloadLinks")
+	defer tracing.End(span, &err)
 	links := make([]DownloadLink, 0)
-	rows, err := ls.db.Query("SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at FROM download_links")
+	query := "SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at, password_hash, (SELECT COUNT(*) FROM download_link_recipients r WHERE r.token = download_links.token) FROM download_links"
+	var args []any
+	if active {
+		query += " WHERE remaining_uses > 0 AND expires_at > ?"
+		args = append(args, time.Now())
+	}
+	rows, err := ls.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch download links: %v", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var link DownloadLink
-		if err := rows.Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt); err != nil {
+		if err := rows.Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt, &link.PasswordHash, &link.RecipientCount); err != nil {
 			return nil, fmt.Errorf("failed to scan download link: %v", err)
 		}
-		if active && (link.RemainingUses <= 0 || link.ExpiresAt.Before(time.Now())) {
-			continue
-		}
 		link.Url = fmt.Sprintf("/download/%s/", link.Token)
 		links = append(links, link)
 	}
@@ -154,14 +226,18 @@ func (ls *Store) ListDownloadLinks(active bool) ([]DownloadLink, error) {
 	return links, nil
 }
 
-func (ls *Store) CreateDownloadLink(token, dir string, expiresAt time.Time, remainingUses int) error {
-	_, err := ls.db.Exec(
-		"INSERT INTO download_links (token, dir, expires_at, remaining_uses, created_at) VALUES (?, ?, ?, ?, ?)",
+func (ls *Store) CreateDownloadLink(ctx context.Context, token, dir string, expiresAt time.Time, remainingUses int, passwordHash string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.CreateDownloadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
+		"INSERT INTO download_links (token, dir, expires_at, remaining_uses, created_at, password_hash) VALUES (?, ?, ?, ?, ?, ?)",
 		token,
 		dir,
 		expiresAt,
 		remainingUses,
 		time.Now(),
+		passwordHash,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create download link: %v", err)
@@ -169,12 +245,15 @@ func (ls *Store) CreateDownloadLink(token, dir string, expiresAt time.Time, rema
 	return nil
 }
 
-func (ls *Store) DeactivateDownloadLink(token string) error {
-	return ls.UpdateDownloadLink(token, 0, time.Now())
+func (ls *Store) DeactivateDownloadLink(ctx context.Context, token string) error {
+	return ls.UpdateDownloadLink(ctx, token, 0, time.Now())
 }
 
-func (ls *Store) DeleteDownloadLink(token string) error {
-	_, err := ls.db.Exec(
+func (ls *Store) DeleteDownloadLink(ctx context.Context, token string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.DeleteDownloadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
 		"DELETE FROM download_links WHERE token = ?",
 		token,
 	)
@@ -184,15 +263,18 @@ func (ls *Store) DeleteDownloadLink(token string) error {
 	return nil
 }
 
-func (ls *Store) GetDownloadLink(token string) (*DownloadLink, error) {
+func (ls *Store) GetDownloadLink(ctx context.Context, token string) (_ *DownloadLink, err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.GetDownloadLink")
+	defer tracing.End(span, &err)
 	var link DownloadLink
-	err := ls.db.QueryRow(
-		"SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at FROM download_links WHERE token = ?",
+	err = ls.db.QueryRowContext(
+		ctx,
+		"SELECT remaining_uses, token, dir, expires_at, created_at, last_used_at, password_hash, (SELECT COUNT(*) FROM download_link_recipients r WHERE r.token = download_links.token) FROM download_links WHERE token = ?",
 		token,
-	).Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt)
+	).Scan(&link.RemainingUses, &link.Token, &link.Dir, &link.ExpiresAt, &link.CreatedAt, &link.LastUsedAt, &link.PasswordHash, &link.RecipientCount)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("download link not found")
+			return nil, fmt.Errorf("download %w", ErrLinkNotFound)
 		}
 		return nil, fmt.Errorf("failed to fetch download link: %v", err)
 	}
@@ -200,8 +282,11 @@ func (ls *Store) GetDownloadLink(token string) (*DownloadLink, error) {
 	return &link, nil
 }
 
-func (ls *Store) UpdateDownloadLink(token string, remainingUses int, lastUsed time.Time) error {
-	_, err := ls.db.Exec(
+func (ls *Store) UpdateDownloadLink(ctx context.Context, token string, remainingUses int, lastUsed time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "links.UpdateDownloadLink")
+	defer tracing.End(span, &err)
+	_, err = ls.db.ExecContext(
+		ctx,
 		"UPDATE download_links SET remaining_uses = ?, last_used_at = ? WHERE token = ?",
 		remainingUses,
 		lastUsed,
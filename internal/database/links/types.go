@@ -2,9 +2,12 @@ package links
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 )
 
+var ErrLinkNotFound = errors.New("link not found")
+
 type Store struct {
 	db *sql.DB
 }
@@ -18,15 +21,52 @@ type UploadLink struct {
 	CreatedAt     time.Time
 	LastUsedAt    *time.Time
 	Url           string
+	PasswordHash  string
 }
 
 type DownloadLink struct {
-	Id            int
-	RemainingUses int
-	Token         string
-	Dir           string
-	ExpiresAt     time.Time
-	CreatedAt     time.Time
-	LastUsedAt    *time.Time
-	Url           string
+	Id             int
+	RemainingUses  int
+	Token          string
+	Dir            string
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+	LastUsedAt     *time.Time
+	Url            string
+	PasswordHash   string
+	RecipientCount int
+	Recipients     []Recipient
+	Accesses       []Access
+}
+
+// Recipient is an email address allowed to open a restricted download link
+type Recipient struct {
+	Email         string
+	CodeHash      string
+	CodeExpiresAt *time.Time
+	CodeAttempts  int
+	VerifiedAt    *time.Time
+}
+
+// Access records a file downloaded by a verified recipient
+type Access struct {
+	Email      string
+	Filename   string
+	ClientIp   string
+	AccessedAt time.Time
+}
+
+// Protected reports whether the link requires a passphrase
+func (l *UploadLink) Protected() bool {
+	return l.PasswordHash != ""
+}
+
+// Protected reports whether the link requires a passphrase
+func (l *DownloadLink) Protected() bool {
+	return l.PasswordHash != ""
+}
+
+// Restricted reports whether the link only opens for verified recipients
+func (l *DownloadLink) Restricted() bool {
+	return l.RecipientCount > 0
 }
@@ -0,0 +1,207 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/tracing"
+	"unicode/utf8"
+)
+
+func NewMetadataStore(db *sql.DB) (*Store, error) {
+	ms := &Store{db: db}
+	if err := ms.initialize(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func (ms *Store) initialize() error {
+	_, err := ms.db.Exec(`
+		CREATE TABLE IF NOT EXISTS files (
+			directory TEXT NOT NULL,
+			filename TEXT NOT NULL,
+			original_name TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			content_type TEXT NOT NULL DEFAULT '',
+			sha256 TEXT NOT NULL DEFAULT '',
+			upload_token TEXT NOT NULL DEFAULT '',
+			uploaded_by TEXT NOT NULL DEFAULT '',
+			uploaded_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (directory, filename)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	if err := database.AddColumn(ms.db, "files", "md5", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return database.AddColumn(ms.db, "files", "scan_verdict", "TEXT NOT NULL DEFAULT ''")
+}
+
+// Create records a file, replacing any stale record for the same path.
+func (ms *Store) Create(ctx context.Context, file *File) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.Create")
+	defer tracing.End(span, &err)
+	_, err = ms.db.ExecContext(
+		ctx,
+		"INSERT OR REPLACE INTO files (directory, filename, original_name, size, content_type, sha256, md5, scan_verdict, upload_token, uploaded_by, uploaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		file.Directory,
+		file.Filename,
+		file.OriginalName,
+		file.Size,
+		file.ContentType,
+		file.Sha256,
+		file.Md5,
+		file.ScanVerdict,
+		file.UploadToken,
+		file.UploadedBy,
+		file.UploadedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create file metadata: %v", err)
+	}
+	return nil
+}
+
+func (ms *Store) Get(ctx context.Context, directory, filename string) (_ *File, err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.Get")
+	defer tracing.End(span, &err)
+	var file File
+	err = ms.db.QueryRowContext(
+		ctx,
+		"SELECT directory, filename, original_name, size, content_type, sha256, md5, scan_verdict, upload_token, uploaded_by, uploaded_at FROM files WHERE directory = ? AND filename = ?",
+		directory,
+		filename,
+	).Scan(&file.Directory, &file.Filename, &file.OriginalName, &file.Size, &file.ContentType, &file.Sha256, &file.Md5, &file.ScanVerdict, &file.UploadToken, &file.UploadedBy, &file.UploadedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file metadata: %v", err)
+	}
+	return &file, nil
+}
+
+// List returns the files recorded in a directory, keyed by filename. Files
+// in its subdirectories are not included.
+func (ms *Store) List(ctx context.Context, directory string) (_ map[string]File, err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.List")
+	defer tracing.End(span, &err)
+	return ms.list(ctx, func(file File) string { return file.Filename }, "SELECT directory, filename, original_name, size, content_type, sha256, md5, scan_verdict, upload_token, uploaded_by, uploaded_at FROM files WHERE directory = ?", directory)
+}
+
+// ListAll returns every recorded file, keyed by directory and filename
+// joined with a slash.
+func (ms *Store) ListAll(ctx context.Context) (_ map[string]File, err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.ListAll")
+	defer tracing.End(span, &err)
+	return ms.list(ctx, func(file File) string { return file.Directory + "/" + file.Filename }, "SELECT directory, filename, original_name, size, content_type, sha256, md5, scan_verdict, upload_token, uploaded_by, uploaded_at FROM files")
+}
+
+func (ms *Store) list(ctx context.Context, key func(File) string, query string, args ...any) (map[string]File, error) {
+	rows, err := ms.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file metadata: %v", err)
+	}
+	defer rows.Close()
+	files := make(map[string]File)
+	for rows.Next() {
+		var file File
+		if err := rows.Scan(&file.Directory, &file.Filename, &file.OriginalName, &file.Size, &file.ContentType, &file.Sha256, &file.Md5, &file.ScanVerdict, &file.UploadToken, &file.UploadedBy, &file.UploadedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %v", err)
+		}
+		files[key(file)] = file
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over file metadata: %v", err)
+	}
+	return files, nil
+}
+
+// Rename changes the name a file is stored and shown as.
+func (ms *Store) Rename(ctx context.Context, directory, filename, newFilename, originalName string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.Rename")
+	defer tracing.End(span, &err)
+	_, err = ms.db.ExecContext(
+		ctx,
+		"UPDATE files SET filename = ?, original_name = ? WHERE directory = ? AND filename = ?",
+		newFilename,
+		originalName,
+		directory,
+		filename,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename file metadata: %v", err)
+	}
+	return nil
+}
+
+// Move follows a file that was moved on disk.
+func (ms *Store) Move(ctx context.Context, directory, filename, newDirectory, newFilename string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.Move")
+	defer tracing.End(span, &err)
+	_, err = ms.db.ExecContext(
+		ctx,
+		"UPDATE files SET directory = ?, filename = ? WHERE directory = ? AND filename = ?",
+		newDirectory,
+		newFilename,
+		directory,
+		filename,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move file metadata: %v", err)
+	}
+	return nil
+}
+
+// MoveDirectory follows a directory that was moved on disk, including the
+// files in its subdirectories. SQLite counts characters rather than bytes,
+// which is why the prefix length is counted in runes.
+func (ms *Store) MoveDirectory(ctx context.Context, directory, newDirectory string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.MoveDirectory")
+	defer tracing.End(span, &err)
+	_, err = ms.db.ExecContext(
+		ctx,
+		`UPDATE files SET directory = ? || substr(directory, ?)
+		WHERE directory = ? OR substr(directory, 1, ?) = ?`,
+		newDirectory,
+		utf8.RuneCountInString(directory)+1,
+		directory,
+		utf8.RuneCountInString(directory)+1,
+		directory+"/",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move directory metadata: %v", err)
+	}
+	return nil
+}
+
+func (ms *Store) Delete(ctx context.Context, directory, filename string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.Delete")
+	defer tracing.End(span, &err)
+	if _, err := ms.db.ExecContext(ctx, "DELETE FROM files WHERE directory = ? AND filename = ?", directory, filename); err != nil {
+		return fmt.Errorf("failed to delete file metadata: %v", err)
+	}
+	return nil
+}
+
+// DeleteDirectory forgets every file in a directory and its subdirectories.
+func (ms *Store) DeleteDirectory(ctx context.Context, directory string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "metadata.DeleteDirectory")
+	defer tracing.End(span, &err)
+	_, err = ms.db.ExecContext(
+		ctx,
+		"DELETE FROM files WHERE directory = ? OR substr(directory, 1, ?) = ?",
+		directory,
+		utf8.RuneCountInString(directory)+1,
+		directory+"/",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete directory metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrFileNotFound = errors.New("file metadata not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+// File describes a file stored on disk. Directory and Filename locate it
+// below the upload directory; everything else is recorded when it is uploaded.
+type File struct {
+	Directory string
+	Filename  string
+	// OriginalName is the name the file is shown and downloaded as
+	OriginalName string
+	Size         int64
+	ContentType  string
+	Sha256       string
+	// Md5 is only recorded when enabled in the configuration
+	Md5 string
+	// ScanVerdict is the malware scanner's verdict, empty if not scanned
+	ScanVerdict string
+	// UploadToken is the upload link the file came through, if any
+	UploadToken string
+	// UploadedBy is the admin who uploaded the file, if any
+	UploadedBy string
+	UploadedAt time.Time
+}
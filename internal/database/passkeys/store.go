@@ -0,0 +1,161 @@
+package passkeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/frodejac/globster/internal/tracing"
+	"time"
+)
+
+func NewPasskeyStore(db *sql.DB) (*Store, error) {
+	ps := &Store{db: db}
+	if err := ps.initialize(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *Store) initialize() error {
+	_, err := ps.db.Exec(`
+		CREATE TABLE IF NOT EXISTS webauthn_users (
+			username TEXT PRIMARY KEY,
+			user_handle BLOB NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS webauthn_credentials (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL REFERENCES webauthn_users(username),
+			name TEXT NOT NULL,
+			credential TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_username ON webauthn_credentials(username);
+	`)
+	return err
+}
+
+// UserHandle returns the WebAuthn user handle of username, storing newHandle
+// as the handle if the user has none yet.
+func (ps *Store) UserHandle(ctx context.Context, username string, newHandle []byte) (_ []byte, err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.UserHandle")
+	defer tracing.End(span, &err)
+	_, err = ps.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO webauthn_users (username, user_handle, created_at)
+		VALUES (?, ?, ?)
+	`, username, newHandle, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var handle []byte
+	err = ps.db.QueryRowContext(ctx, `
+		SELECT user_handle
+		FROM webauthn_users
+		WHERE username = ?
+	`, username).Scan(&handle)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// Username returns the user a WebAuthn user handle belongs to.
+func (ps *Store) Username(ctx context.Context, handle []byte) (_ string, err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.Username")
+	defer tracing.End(span, &err)
+	var username string
+	err = ps.db.QueryRowContext(ctx, `
+		SELECT username
+		FROM webauthn_users
+		WHERE user_handle = ?
+	`, handle).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrCredentialNotFound
+		}
+		return "", err
+	}
+	return username, nil
+}
+
+func (ps *Store) ListCredentials(ctx context.Context, username string) (_ []Credential, err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.ListCredentials")
+	defer tracing.End(span, &err)
+	rows, err := ps.db.QueryContext(ctx, `
+		SELECT id, username, name, credential, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE username = ?
+		ORDER BY created_at
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var credentials []Credential
+	for rows.Next() {
+		var credential Credential
+		if err := rows.Scan(&credential.Id, &credential.Username, &credential.Name, &credential.Data, &credential.CreatedAt, &credential.LastUsedAt); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (ps *Store) CountCredentials(ctx context.Context, username string) (_ int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.CountCredentials")
+	defer tracing.End(span, &err)
+	var count int
+	err = ps.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM webauthn_credentials
+		WHERE username = ?
+	`, username).Scan(&count)
+	return count, err
+}
+
+func (ps *Store) AddCredential(ctx context.Context, id, username, name, data string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.AddCredential")
+	defer tracing.End(span, &err)
+	_, err = ps.db.ExecContext(ctx, `
+		INSERT INTO webauthn_credentials (id, username, name, credential, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, username, name, data, time.Now())
+	return err
+}
+
+// UpdateCredential stores the credential record after a successful login,
+// which carries the authenticator's new signature counter.
+func (ps *Store) UpdateCredential(ctx context.Context, id, data string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.UpdateCredential")
+	defer tracing.End(span, &err)
+	_, err = ps.db.ExecContext(ctx, `
+		UPDATE webauthn_credentials
+		SET credential = ?, last_used_at = ?
+		WHERE id = ?
+	`, data, time.Now(), id)
+	return err
+}
+
+func (ps *Store) DeleteCredential(ctx context.Context, username, id string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "passkeys.DeleteCredential")
+	defer tracing.End(span, &err)
+	res, err := ps.db.ExecContext(ctx, `
+		DELETE FROM webauthn_credentials
+		WHERE username = ? AND id = ?
+	`, username, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package passkeys
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrCredentialNotFound = errors.New("passkey credential not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+// Credential is a WebAuthn credential registered by a user. Data holds the
+// credential record as serialized by the caller.
+type Credential struct {
+	Id         string
+	Username   string
+	Name       string
+	Data       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
@@ -0,0 +1,102 @@
+package policies
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"time"
+)
+
+func NewPolicyStore(db *sql.DB) (*Store, error) {
+	ps := &Store{db: db}
+	if err := ps.initialize(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *Store) initialize() error {
+	_, err := ps.db.Exec(`
+		CREATE TABLE IF NOT EXISTS directory_policies (
+			dir TEXT PRIMARY KEY,
+			retention_days INTEGER NOT NULL DEFAULT 0,
+			keep_forever BOOLEAN NOT NULL DEFAULT 0,
+			legal_hold BOOLEAN NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL,
+			updated_by TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	return err
+}
+
+// Get returns the policy of a directory, which is the zero policy for the
+// directory if none has been set.
+func (ps *Store) Get(ctx context.Context, dir string) (_ Policy, err error) {
+	ctx, span := tracing.StartQuery(ctx, "policies.Get")
+	defer tracing.End(span, &err)
+	policy := Policy{Dir: dir}
+	err = ps.db.QueryRowContext(
+		ctx,
+		"SELECT retention_days, keep_forever, legal_hold, updated_at, updated_by FROM directory_policies WHERE dir = ?",
+		dir,
+	).Scan(&policy.RetentionDays, &policy.KeepForever, &policy.LegalHold, &policy.UpdatedAt, &policy.UpdatedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policy, nil
+	}
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to fetch directory policy: %v", err)
+	}
+	return policy, nil
+}
+
+// List returns every stored policy by directory.
+func (ps *Store) List(ctx context.Context) (_ map[string]Policy, err error) {
+	ctx, span := tracing.StartQuery(ctx, "policies.List")
+	defer tracing.End(span, &err)
+	rows, err := ps.db.QueryContext(ctx, "SELECT dir, retention_days, keep_forever, legal_hold, updated_at, updated_by FROM directory_policies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch directory policies: %v", err)
+	}
+	defer rows.Close()
+	policies := make(map[string]Policy)
+	for rows.Next() {
+		var policy Policy
+		if err := rows.Scan(&policy.Dir, &policy.RetentionDays, &policy.KeepForever, &policy.LegalHold, &policy.UpdatedAt, &policy.UpdatedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan directory policy: %v", err)
+		}
+		policies[policy.Dir] = policy
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over directory policies: %v", err)
+	}
+	return policies, nil
+}
+
+// Set stores the policy of a directory, recording who changed it.
+func (ps *Store) Set(ctx context.Context, policy Policy, username string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "policies.Set")
+	defer tracing.End(span, &err)
+	_, err = ps.db.ExecContext(
+		ctx,
+		`INSERT INTO directory_policies (dir, retention_days, keep_forever, legal_hold, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (dir) DO UPDATE SET
+			retention_days = excluded.retention_days,
+			keep_forever = excluded.keep_forever,
+			legal_hold = excluded.legal_hold,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by`,
+		policy.Dir,
+		policy.RetentionDays,
+		policy.KeepForever,
+		policy.LegalHold,
+		time.Now(),
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store directory policy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package policies
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// Policy controls how long files in an upload directory are kept. A
+// directory without a stored policy uses the global file retention.
+type Policy struct {
+	Dir string
+	// RetentionDays overrides the global file retention when positive
+	RetentionDays int
+	KeepForever   bool
+	// LegalHold blocks every deletion in the directory, including cleanup
+	LegalHold bool
+	UpdatedAt time.Time
+	UpdatedBy string
+}
+
+// Retention returns how long files in the directory are kept given the
+// global default, or zero if they are kept forever.
+func (p Policy) Retention(def time.Duration) time.Duration {
+	switch {
+	case p.LegalHold, p.KeepForever:
+		return 0
+	case p.RetentionDays > 0:
+		return time.Duration(p.RetentionDays) * 24 * time.Hour
+	}
+	return def
+}
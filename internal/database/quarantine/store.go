@@ -0,0 +1,111 @@
+package quarantine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+)
+
+func NewQuarantineStore(db *sql.DB) (*Store, error) {
+	qs := &Store{db: db}
+	if err := qs.initialize(); err != nil {
+		return nil, err
+	}
+	return qs, nil
+}
+
+func (qs *Store) initialize() error {
+	_, err := qs.db.Exec(`
+		CREATE TABLE IF NOT EXISTS quarantine_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			directory TEXT NOT NULL,
+			original_name TEXT NOT NULL,
+			quarantine_name TEXT UNIQUE NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			sha256 TEXT NOT NULL DEFAULT '',
+			signature TEXT NOT NULL DEFAULT '',
+			upload_token TEXT NOT NULL DEFAULT '',
+			uploaded_by TEXT NOT NULL DEFAULT '',
+			quarantined_at TIMESTAMP NOT NULL
+		);
+	`)
+	return err
+}
+
+func (qs *Store) Create(ctx context.Context, item *Item) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "quarantine.Create")
+	defer tracing.End(span, &err)
+	result, err := qs.db.ExecContext(
+		ctx,
+		"INSERT INTO quarantine_items (directory, original_name, quarantine_name, size, sha256, signature, upload_token, uploaded_by, quarantined_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		item.Directory,
+		item.OriginalName,
+		item.QuarantineName,
+		item.Size,
+		item.Sha256,
+		item.Signature,
+		item.UploadToken,
+		item.UploadedBy,
+		item.QuarantinedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine item: %v", err)
+	}
+	item.Id, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get quarantine item id: %v", err)
+	}
+	return nil
+}
+
+func (qs *Store) Get(ctx context.Context, id int64) (_ *Item, err error) {
+	ctx, span := tracing.StartQuery(ctx, "quarantine.Get")
+	defer tracing.End(span, &err)
+	var item Item
+	err = qs.db.QueryRowContext(
+		ctx,
+		"SELECT id, directory, original_name, quarantine_name, size, sha256, signature, upload_token, uploaded_by, quarantined_at FROM quarantine_items WHERE id = ?",
+		id,
+	).Scan(&item.Id, &item.Directory, &item.OriginalName, &item.QuarantineName, &item.Size, &item.Sha256, &item.Signature, &item.UploadToken, &item.UploadedBy, &item.QuarantinedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quarantine item: %v", err)
+	}
+	return &item, nil
+}
+
+// List returns the quarantined items, most recent first.
+func (qs *Store) List(ctx context.Context) (_ []Item, err error) {
+	ctx, span := tracing.StartQuery(ctx, "quarantine.List")
+	defer tracing.End(span, &err)
+	rows, err := qs.db.QueryContext(ctx, "SELECT id, directory, original_name, quarantine_name, size, sha256, signature, upload_token, uploaded_by, quarantined_at FROM quarantine_items ORDER BY quarantined_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quarantine items: %v", err)
+	}
+	defer rows.Close()
+	items := make([]Item, 0)
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.Id, &item.Directory, &item.OriginalName, &item.QuarantineName, &item.Size, &item.Sha256, &item.Signature, &item.UploadToken, &item.UploadedBy, &item.QuarantinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan quarantine item: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over quarantine items: %v", err)
+	}
+	return items, nil
+}
+
+func (qs *Store) Delete(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "quarantine.Delete")
+	defer tracing.End(span, &err)
+	if _, err := qs.db.ExecContext(ctx, "DELETE FROM quarantine_items WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete quarantine item: %v", err)
+	}
+	return nil
+}
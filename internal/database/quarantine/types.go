@@ -0,0 +1,31 @@
+package quarantine
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrItemNotFound = errors.New("quarantine item not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+// Item is an upload that the malware scanner found to be infected. Its
+// contents are kept under QuarantineName in the quarantine directory until
+// an admin deletes it.
+type Item struct {
+	Id int64
+	// Directory is where the file was uploaded to
+	Directory      string
+	OriginalName   string
+	QuarantineName string
+	Size           int64
+	Sha256         string
+	// Signature is the name of the malware the scanner reported
+	Signature     string
+	UploadToken   string
+	UploadedBy    string
+	QuarantinedAt time.Time
+}
@@ -1,7 +1,10 @@
 package sessions
 
 import (
+	"context"
 	"database/sql"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/tracing"
 	"time"
 )
 
@@ -21,34 +24,130 @@ func (ls *Store) initialize() error {
 			expires_at TIMESTAMP NOT NULL
 		);
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+	columns := []struct{ name, definition string }{
+		{"username", "TEXT NOT NULL DEFAULT ''"},
+		{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+		{"client_ip", "TEXT NOT NULL DEFAULT ''"},
+		{"last_seen_at", "TIMESTAMP"},
+	}
+	for _, column := range columns {
+		if err := database.AddColumn(ls.db, "sessions", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (ss *Store) Create(sessionId string, createdAt, expiresAt time.Time) error {
-	_, err := ss.db.Exec(`
-		INSERT INTO sessions (id, created_at, expires_at)
-		VALUES (?, ?, ?)
-	`, sessionId, createdAt, expiresAt)
+func (ss *Store) Create(ctx context.Context, session *Session) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.Create")
+	defer tracing.End(span, &err)
+	_, err = ss.db.ExecContext(ctx, `
+		INSERT INTO sessions (id, username, user_agent, client_ip, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.Id, session.Username, session.UserAgent, session.ClientIp, session.CreatedAt, session.LastSeenAt, session.ExpiresAt)
 	return err
 }
 
-func (ss *Store) Get(sessionId string) (*Session, error) {
+func (ss *Store) Get(ctx context.Context, sessionId string) (_ *Session, err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.Get")
+	defer tracing.End(span, &err)
 	var session Session
-	err := ss.db.QueryRow(`
-		SELECT id, created_at, expires_at
+	var lastSeenAt sql.NullTime
+	err = ss.db.QueryRowContext(ctx, `
+		SELECT id, username, user_agent, client_ip, created_at, last_seen_at, expires_at
 		FROM sessions
 		WHERE id = ?
-	`, sessionId).Scan(&session.Id, &session.CreatedAt, &session.ExpiresAt)
+	`, sessionId).Scan(&session.Id, &session.Username, &session.UserAgent, &session.ClientIp, &session.CreatedAt, &lastSeenAt, &session.ExpiresAt)
 	if err != nil {
 		return nil, err
 	}
+	session.LastSeenAt = lastSeenOrCreated(lastSeenAt, session.CreatedAt)
 	return &session, nil
 }
 
-func (ss *Store) Delete(sessionId string) error {
-	_, err := ss.db.Exec(`
+// List returns the unexpired sessions, most recently seen first.
+func (ss *Store) List(ctx context.Context, now time.Time) (_ []Session, err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.List")
+	defer tracing.End(span, &err)
+	rows, err := ss.db.QueryContext(ctx, `
+		SELECT id, username, user_agent, client_ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE expires_at > ?
+		ORDER BY COALESCE(last_seen_at, created_at) DESC
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Session
+	for rows.Next() {
+		var session Session
+		var lastSeenAt sql.NullTime
+		if err := rows.Scan(&session.Id, &session.Username, &session.UserAgent, &session.ClientIp, &session.CreatedAt, &lastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = lastSeenOrCreated(lastSeenAt, session.CreatedAt)
+		list = append(list, session)
+	}
+	return list, rows.Err()
+}
+
+// Touch records that the session was used and moves its expiry.
+func (ss *Store) Touch(ctx context.Context, sessionId, clientIp string, lastSeenAt, expiresAt time.Time) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.Touch")
+	defer tracing.End(span, &err)
+	_, err = ss.db.ExecContext(ctx, `
+		UPDATE sessions
+		SET client_ip = ?, last_seen_at = ?, expires_at = ?
+		WHERE id = ?
+	`, clientIp, lastSeenAt, expiresAt, sessionId)
+	return err
+}
+
+// DeleteExpired removes the sessions that expired before now and returns how
+// many were removed.
+func (ss *Store) DeleteExpired(ctx context.Context, now time.Time) (_ int64, err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.DeleteExpired")
+	defer tracing.End(span, &err)
+	result, err := ss.db.ExecContext(ctx, `
+		DELETE FROM sessions
+		WHERE expires_at <= ?
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (ss *Store) Delete(ctx context.Context, sessionId string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.Delete")
+	defer tracing.End(span, &err)
+	_, err = ss.db.ExecContext(ctx, `
 		DELETE FROM sessions
 		WHERE id = ?
 	`, sessionId)
 	return err
 }
+
+// DeleteByUsername removes every session of username.
+func (ss *Store) DeleteByUsername(ctx context.Context, username string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "sessions.DeleteByUsername")
+	defer tracing.End(span, &err)
+	_, err = ss.db.ExecContext(ctx, `
+		DELETE FROM sessions
+		WHERE username = ?
+	`, username)
+	return err
+}
+
+// lastSeenOrCreated falls back to the creation time for sessions created
+// before last use was recorded.
+func lastSeenOrCreated(lastSeenAt sql.NullTime, createdAt time.Time) time.Time {
+	if lastSeenAt.Valid {
+		return lastSeenAt.Time
+	}
+	return createdAt
+}
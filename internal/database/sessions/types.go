@@ -10,7 +10,11 @@ type Store struct {
 }
 
 type Session struct {
-	Id        string
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	Id         string
+	Username   string
+	UserAgent  string
+	ClientIp   string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
 }
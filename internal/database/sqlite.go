@@ -14,10 +14,44 @@ func Open(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 	// Check we can connect
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
+	if err := Ping(context.Background(), db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Ping checks that the database answers within five seconds.
+func Ping(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(5*time.Second))
 	defer cancel()
 	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		return fmt.Errorf("failed to ping database: %w", err)
 	}
-	return db, nil
+	return nil
+}
+
+// AddColumn adds a column to an existing table unless it is already present,
+// so stores can extend tables created by earlier versions.
+func AddColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
 }
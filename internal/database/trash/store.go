@@ -0,0 +1,107 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+)
+
+func NewTrashStore(db *sql.DB) (*Store, error) {
+	ts := &Store{db: db}
+	if err := ts.initialize(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *Store) initialize() error {
+	_, err := ts.db.Exec(`
+		CREATE TABLE IF NOT EXISTS trash_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			directory TEXT NOT NULL,
+			filename TEXT NOT NULL DEFAULT '',
+			trash_name TEXT UNIQUE NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			deleted_at TIMESTAMP NOT NULL,
+			deleted_by TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	return err
+}
+
+func (ts *Store) Create(ctx context.Context, item *Item) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "trash.Create")
+	defer tracing.End(span, &err)
+	result, err := ts.db.ExecContext(
+		ctx,
+		"INSERT INTO trash_items (kind, directory, filename, trash_name, size, deleted_at, deleted_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		item.Kind,
+		item.Directory,
+		item.Filename,
+		item.TrashName,
+		item.Size,
+		item.DeletedAt,
+		item.DeletedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create trash item: %v", err)
+	}
+	item.Id, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get trash item id: %v", err)
+	}
+	return nil
+}
+
+func (ts *Store) Get(ctx context.Context, id int64) (_ *Item, err error) {
+	ctx, span := tracing.StartQuery(ctx, "trash.Get")
+	defer tracing.End(span, &err)
+	var item Item
+	err = ts.db.QueryRowContext(
+		ctx,
+		"SELECT id, kind, directory, filename, trash_name, size, deleted_at, deleted_by FROM trash_items WHERE id = ?",
+		id,
+	).Scan(&item.Id, &item.Kind, &item.Directory, &item.Filename, &item.TrashName, &item.Size, &item.DeletedAt, &item.DeletedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash item: %v", err)
+	}
+	return &item, nil
+}
+
+// List returns the items in the trash, most recently deleted first.
+func (ts *Store) List(ctx context.Context) (_ []Item, err error) {
+	ctx, span := tracing.StartQuery(ctx, "trash.List")
+	defer tracing.End(span, &err)
+	rows, err := ts.db.QueryContext(ctx, "SELECT id, kind, directory, filename, trash_name, size, deleted_at, deleted_by FROM trash_items ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash items: %v", err)
+	}
+	defer rows.Close()
+	items := make([]Item, 0)
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.Id, &item.Kind, &item.Directory, &item.Filename, &item.TrashName, &item.Size, &item.DeletedAt, &item.DeletedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan trash item: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over trash items: %v", err)
+	}
+	return items, nil
+}
+
+func (ts *Store) Delete(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "trash.Delete")
+	defer tracing.End(span, &err)
+	if _, err := ts.db.ExecContext(ctx, "DELETE FROM trash_items WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete trash item: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package trash
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrItemNotFound = errors.New("trash item not found")
+
+// Kinds of items in the trash
+const (
+	KindFile      = "file"
+	KindDirectory = "directory"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// Item is a deleted file or directory. Its contents are kept under
+// TrashName in the trash directory until it is restored or purged.
+type Item struct {
+	Id        int64
+	Kind      string
+	Directory string
+	// Filename is empty for directories
+	Filename  string
+	TrashName string
+	Size      int64
+	DeletedAt time.Time
+	DeletedBy string
+}
@@ -0,0 +1,202 @@
+package twofactor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"time"
+)
+
+func NewTwoFactorStore(db *sql.DB) (*Store, error) {
+	ts := &Store{db: db}
+	if err := ts.initialize(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *Store) initialize() error {
+	_, err := ts.db.Exec(`
+		CREATE TABLE IF NOT EXISTS totp_secrets (
+			username TEXT PRIMARY KEY,
+			secret TEXT NOT NULL,
+			last_step INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			confirmed_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP,
+			UNIQUE(username, code_hash)
+		);
+	`)
+	return err
+}
+
+func (ts *Store) GetSecret(ctx context.Context, username string) (_ *Secret, err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.GetSecret")
+	defer tracing.End(span, &err)
+	var secret Secret
+	err = ts.db.QueryRowContext(ctx, `
+		SELECT username, secret, last_step, created_at, confirmed_at
+		FROM totp_secrets
+		WHERE username = ?
+	`, username).Scan(&secret.Username, &secret.Secret, &secret.LastStep, &secret.CreatedAt, &secret.ConfirmedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// SavePendingSecret stores a new, unconfirmed secret for username. It replaces
+// an earlier unconfirmed secret but never a confirmed one.
+func (ts *Store) SavePendingSecret(ctx context.Context, username, secret string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.SavePendingSecret")
+	defer tracing.End(span, &err)
+	_, err = ts.db.ExecContext(ctx, `
+		INSERT INTO totp_secrets (username, secret, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			secret = excluded.secret,
+			last_step = 0,
+			created_at = excluded.created_at
+		WHERE confirmed_at IS NULL
+	`, username, secret, time.Now())
+	return err
+}
+
+// ConfirmSecret marks the secret of username as enrolled and replaces its
+// recovery codes, all in one transaction.
+func (ts *Store) ConfirmSecret(ctx context.Context, username string, step int64, recoveryCodeHashes []string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.ConfirmSecret")
+	defer tracing.End(span, &err)
+	tx, err := ts.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE totp_secrets
+		SET confirmed_at = ?, last_step = ?
+		WHERE username = ? AND confirmed_at IS NULL
+	`, time.Now(), step, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSecretNotFound
+	}
+	if err := replaceRecoveryCodes(ctx, tx, username, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep records step as the last accepted time step for username. It
+// reports false when a code from the same or a later step was already used.
+func (ts *Store) UseStep(ctx context.Context, username string, step int64) (_ bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.UseStep")
+	defer tracing.End(span, &err)
+	res, err := ts.db.ExecContext(ctx, `
+		UPDATE totp_secrets
+		SET last_step = ?
+		WHERE username = ? AND last_step < ?
+	`, step, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteSecret removes the secret and recovery codes of username.
+func (ts *Store) DeleteSecret(ctx context.Context, username string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.DeleteSecret")
+	defer tracing.End(span, &err)
+	tx, err := ts.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_secrets WHERE username = ?`, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE username = ?`, username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ts *Store) ReplaceRecoveryCodes(ctx context.Context, username string, codeHashes []string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.ReplaceRecoveryCodes")
+	defer tracing.End(span, &err)
+	tx, err := ts.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(ctx, tx, username, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false when
+// no such unused code exists.
+func (ts *Store) UseRecoveryCode(ctx context.Context, username, codeHash string) (_ bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.UseRecoveryCode")
+	defer tracing.End(span, &err)
+	res, err := ts.db.ExecContext(ctx, `
+		UPDATE totp_recovery_codes
+		SET used_at = ?
+		WHERE username = ? AND code_hash = ? AND used_at IS NULL
+	`, time.Now(), username, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (ts *Store) CountRecoveryCodes(ctx context.Context, username string) (_ int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "twofactor.CountRecoveryCodes")
+	defer tracing.End(span, &err)
+	var count int
+	err = ts.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM totp_recovery_codes
+		WHERE username = ? AND used_at IS NULL
+	`, username).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, username string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE username = ?`, username); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO totp_recovery_codes (username, code_hash)
+			VALUES (?, ?)
+		`, username, codeHash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package twofactor
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrSecretNotFound = errors.New("totp secret not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+// Secret is a user's TOTP secret. It is encrypted by the caller before it is
+// stored and only counts as enrolled once ConfirmedAt is set.
+type Secret struct {
+	Username    string
+	Secret      string
+	LastStep    int64
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+}
+
+func (s *Secret) Confirmed() bool {
+	return s.ConfirmedAt != nil
+}
@@ -0,0 +1,166 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/frodejac/globster/internal/tracing"
+	"github.com/mattn/go-sqlite3"
+	"time"
+)
+
+func NewUserStore(db *sql.DB) (*Store, error) {
+	us := &Store{db: db}
+	if err := us.initialize(); err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+func (us *Store) initialize() error {
+	_, err := us.db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			username TEXT PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			must_change_password BOOLEAN NOT NULL DEFAULT 0,
+			disabled BOOLEAN NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			last_login_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+func (us *Store) Create(ctx context.Context, username, passwordHash string, mustChangePassword bool) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.Create")
+	defer tracing.End(span, &err)
+	now := time.Now()
+	_, err = us.db.ExecContext(ctx, `
+		INSERT INTO users (username, password_hash, must_change_password, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, username, passwordHash, mustChangePassword, now, now)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrUserExists
+	}
+	return err
+}
+
+// Import creates users from a map of usernames to password hashes in a single
+// transaction, so an interrupted import can be retried.
+func (us *Store) Import(ctx context.Context, hashes map[string]string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.Import")
+	defer tracing.End(span, &err)
+	tx, err := us.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	for username, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO users (username, password_hash, created_at, updated_at)
+			VALUES (?, ?, ?, ?)
+		`, username, hash, now, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (us *Store) Get(ctx context.Context, username string) (_ *User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.Get")
+	defer tracing.End(span, &err)
+	var user User
+	err = us.db.QueryRowContext(ctx, `
+		SELECT username, password_hash, must_change_password, disabled, created_at, updated_at, last_login_at
+		FROM users
+		WHERE username = ?
+	`, username).Scan(&user.Username, &user.PasswordHash, &user.MustChangePassword, &user.Disabled, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (us *Store) List(ctx context.Context) (_ []User, err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.List")
+	defer tracing.End(span, &err)
+	rows, err := us.db.QueryContext(ctx, `
+		SELECT username, password_hash, must_change_password, disabled, created_at, updated_at, last_login_at
+		FROM users
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Username, &user.PasswordHash, &user.MustChangePassword, &user.Disabled, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (us *Store) Count(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.Count")
+	defer tracing.End(span, &err)
+	var count int
+	err = us.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+func (us *Store) SetPassword(ctx context.Context, username, passwordHash string, mustChangePassword bool) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.SetPassword")
+	defer tracing.End(span, &err)
+	res, err := us.db.ExecContext(ctx, `
+		UPDATE users
+		SET password_hash = ?, must_change_password = ?, updated_at = ?
+		WHERE username = ?
+	`, passwordHash, mustChangePassword, time.Now(), username)
+	return rowAffected(res, err)
+}
+
+func (us *Store) SetDisabled(ctx context.Context, username string, disabled bool) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.SetDisabled")
+	defer tracing.End(span, &err)
+	res, err := us.db.ExecContext(ctx, `
+		UPDATE users
+		SET disabled = ?, updated_at = ?
+		WHERE username = ?
+	`, disabled, time.Now(), username)
+	return rowAffected(res, err)
+}
+
+func (us *Store) RecordLogin(ctx context.Context, username string) (err error) {
+	ctx, span := tracing.StartQuery(ctx, "users.RecordLogin")
+	defer tracing.End(span, &err)
+	res, err := us.db.ExecContext(ctx, `
+		UPDATE users
+		SET last_login_at = ?
+		WHERE username = ?
+	`, time.Now(), username)
+	return rowAffected(res, err)
+}
+
+func rowAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
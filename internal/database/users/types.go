@@ -0,0 +1,26 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+type User struct {
+	Username           string
+	PasswordHash       string
+	MustChangePassword bool
+	Disabled           bool
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	LastLoginAt        *time.Time
+}
@@ -0,0 +1,150 @@
+package downloads
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/links"
+	"log/slog"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCodeAttempts is how many wrong codes a recipient may enter before a
+	// new code has to be requested
+	maxCodeAttempts = 5
+	// maxTotalCodeAttempts is how many wrong codes a recipient may enter
+	// across all codes sent to them, after which the link stays closed to
+	// them; new codes would otherwise allow unlimited guesses
+	maxTotalCodeAttempts = 20
+)
+
+var ErrInvalidCode = errors.New("invalid or expired code")
+
+// RequestCode emails a one-time code to email if it is a recipient of link.
+// Addresses that aren't recipients are ignored without an error, and the mail
+// is sent in the background, so the response doesn't reveal who the link was
+// shared with.
+func (u *DownloadService) RequestCode(ctx context.Context, link *links.DownloadLink, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, err := u.store.GetDownloadLinkRecipient(ctx, link.Token, email); err != nil {
+		if errors.Is(err, links.ErrRecipientNotFound) {
+			slog.Warn("Code requested for unknown recipient", slog.String("dir", link.Dir))
+			return nil
+		}
+		return err
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return fmt.Errorf("failed to generate code: %v", err)
+	}
+	expiresAt := time.Now().Add(u.config.CodeLifetime)
+	if err := u.store.SetDownloadLinkRecipientCode(ctx, link.Token, email, hashCode(link.Token, email, code), expiresAt); err != nil {
+		return err
+	}
+	body := fmt.Sprintf(
+		"Your verification code is %s\n\nEnter it on the download page to access the shared files. The code expires in %s.\n\nIf you did not request this code, you can ignore this message.\n",
+		code,
+		u.config.CodeLifetime,
+	)
+	// Not waiting for the mail keeps recipients as quick to answer as others
+	u.mailer.SendBackground(ctx, email, "Your download verification code", body)
+	return nil
+}
+
+// VerifyCode checks a one-time code entered by email and marks the recipient
+// as verified on success. Each code can be guessed a limited number of times,
+// and so can all codes sent to a recipient together.
+func (u *DownloadService) VerifyCode(ctx context.Context, link *links.DownloadLink, email, code string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	recipient, err := u.store.GetDownloadLinkRecipient(ctx, link.Token, email)
+	if err != nil {
+		if errors.Is(err, links.ErrRecipientNotFound) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+	if recipient.CodeHash == "" || recipient.CodeExpiresAt == nil || recipient.CodeExpiresAt.Before(time.Now()) {
+		return ErrInvalidCode
+	}
+	// The attempt is claimed before the code is compared, and only if one is
+	// left, so parallel guesses cannot get past the limit
+	ok, err := u.store.IncrementDownloadLinkRecipientAttempts(ctx, link.Token, email, recipient.CodeHash, maxCodeAttempts, maxTotalCodeAttempts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	expected := hashCode(link.Token, email, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(recipient.CodeHash)) != 1 {
+		return ErrInvalidCode
+	}
+	return u.store.VerifyDownloadLinkRecipient(ctx, link.Token, email, time.Now())
+}
+
+// RecordAccess logs that a verified recipient downloaded a file through link.
+func (u *DownloadService) RecordAccess(ctx context.Context, link *links.DownloadLink, email, filename, clientIp string) error {
+	return u.store.RecordDownloadLinkAccess(ctx, link.Token, email, filename, clientIp)
+}
+
+// LoadRecipients fills in the recipients and their downloads for restricted links.
+func (u *DownloadService) LoadRecipients(ctx context.Context, link *links.DownloadLink) error {
+	if !link.Restricted() {
+		return nil
+	}
+	recipients, err := u.store.ListDownloadLinkRecipients(ctx, link.Token)
+	if err != nil {
+		return err
+	}
+	accesses, err := u.store.ListDownloadLinkAccesses(ctx, link.Token)
+	if err != nil {
+		return err
+	}
+	link.Recipients = recipients
+	link.Accesses = accesses
+	return nil
+}
+
+// normalizeRecipients parses a list of addresses, lowercases them and drops duplicates.
+func normalizeRecipients(recipients []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %v", recipient, err)
+		}
+		email := strings.ToLower(addr.Address)
+		if !seen[email] {
+			seen[email] = true
+			normalized = append(normalized, email)
+		}
+	}
+	return normalized, nil
+}
+
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashCode(token, email, code string) string {
+	sum := sha256.Sum256([]byte(token + "|" + email + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,23 +1,25 @@
 package downloads
 
 import (
+	"context"
 	"fmt"
+	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/mail"
 	"github.com/frodejac/globster/internal/random"
-	"os"
-	"path/filepath"
-	"regexp"
 	"time"
 )
 
-func NewDownloadService(store *links.Store, config *Config) *DownloadService {
+func NewDownloadService(store *links.Store, config *Config, mailer *mail.Mailer) *DownloadService {
 	return &DownloadService{
 		store:  store,
 		config: config,
+		mailer: mailer,
 	}
 }
 
-func (u *DownloadService) CreateLink(directory string, expiresAt time.Time, remainingUses int) error {
+func (u *DownloadService) CreateLink(ctx context.Context, directory string, expiresAt time.Time, remainingUses int, password string, recipients []string) error {
 	// Input validation
 	if directory == "" {
 		return fmt.Errorf("directory is required")
@@ -28,64 +30,80 @@ func (u *DownloadService) CreateLink(directory string, expiresAt time.Time, rema
 	if remainingUses <= 0 {
 		return fmt.Errorf("remaining uses must be greater than 0")
 	}
+	recipients, err := normalizeRecipients(recipients)
+	if err != nil {
+		return err
+	}
+	if len(recipients) > 0 && !u.mailer.Enabled() {
+		return fmt.Errorf("recipients require SMTP to be configured")
+	}
 	// Create a new upload token
 	token := random.String(32)
 
-	// Sanitize directory
-	directory = filepath.Clean(directory)
-	directory = filepath.Base(directory)
-	directory = regexp.MustCompile("[^a-zA-Z0-9\\-_]+").ReplaceAllString(directory, "")
+	// Sanitize directory, which may be a subfolder like "partner/outgoing"
+	directory = files.SanitizeDir(directory)
 
 	if directory == "" {
 		return fmt.Errorf("invalid directory name")
 	}
 
 	// Check that the directory exists
-	dirPath := filepath.Join(u.config.BaseDir, directory)
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+	if _, err := files.ResolveDir(u.config.BaseDir, directory); err != nil {
 		return fmt.Errorf("directory does not exist")
 	}
 
+	passwordHash, err := auth.HashLinkPassword(password)
+	if err != nil {
+		return err
+	}
+
 	// Insert the download link into the database
-	if err := u.store.CreateDownloadLink(token, directory, expiresAt, remainingUses); err != nil {
+	if err := u.store.CreateDownloadLink(ctx, token, directory, expiresAt, remainingUses, passwordHash); err != nil {
 		return fmt.Errorf("failed to create upload link: %v", err)
 	}
+	if len(recipients) > 0 {
+		if err := u.store.AddDownloadLinkRecipients(ctx, token, recipients); err != nil {
+			// Don't leave behind a link that would open for anyone
+			_ = u.store.DeleteDownloadLink(ctx, token)
+			return fmt.Errorf("failed to add recipients: %v", err)
+		}
+	}
 	return nil
 }
 
-func (u *DownloadService) DeactivateLink(token string) error {
+func (u *DownloadService) DeactivateLink(ctx context.Context, token string) error {
 	// Validate the token
 	if token == "" {
 		return fmt.Errorf("token is required")
 	}
 	// Deactivate the upload link
-	if err := u.store.DeactivateDownloadLink(token); err != nil {
+	if err := u.store.DeactivateDownloadLink(ctx, token); err != nil {
 		return fmt.Errorf("failed to deactivate upload link: %v", err)
 	}
 	return nil
 }
 
-func (u *DownloadService) DeleteLink(token string) error {
+func (u *DownloadService) DeleteLink(ctx context.Context, token string) error {
 	// Validate the token
 	if token == "" {
 		return fmt.Errorf("token is required")
 	}
 	// Delete the upload link
-	if err := u.store.DeleteDownloadLink(token); err != nil {
+	if err := u.store.DeleteDownloadLink(ctx, token); err != nil {
 		return fmt.Errorf("failed to delete upload link: %v", err)
 	}
 	return nil
 }
 
-func (u *DownloadService) ValidateToken(token string) (*links.DownloadLink, error) {
+func (u *DownloadService) ValidateToken(ctx context.Context, token string) (*links.DownloadLink, error) {
 	// Validate the token
 	if token == "" {
 		return nil, fmt.Errorf("no token provided")
 	}
 	// Check if the token exists and is not expired
-	link, err := u.store.GetDownloadLink(token)
+	link, err := u.store.GetDownloadLink(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get upload link: %v", err)
+		return nil, fmt.Errorf("failed to get download link: %w", err)
 	}
 	if link.RemainingUses <= 0 {
 		return nil, fmt.Errorf("token exhausted")
@@ -1,12 +1,18 @@
 package downloads
 
-import "github.com/frodejac/globster/internal/database/links"
+import (
+	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/mail"
+	"time"
+)
 
 type Config struct {
-	BaseDir string
+	BaseDir      string
+	CodeLifetime time.Duration
 }
 
 type DownloadService struct {
 	store  *links.Store
 	config *Config
+	mailer *mail.Mailer
 }
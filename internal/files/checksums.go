@@ -0,0 +1,104 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Checksum is the SHA-256 checksum of a file in a shared directory.
+type Checksum struct {
+	// Path is relative to the shared directory and ends in the name the
+	// file is downloaded as, so it matches the file once downloaded
+	Path   string
+	Sha256 string
+}
+
+// Checksums returns the checksum of every file in a directory and its
+// subdirectories. Files without a recorded checksum, which reconcile has not
+// yet seen, are read to calculate it.
+func (u *FileService) Checksums(ctx context.Context, directory string) (_ []Checksum, err error) {
+	ctx, span := tracing.Start(ctx, "files.Checksums", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	dirPath, err := ResolveDir(u.config.BaseDir, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make([]Checksum, 0)
+	listed := make(map[string]map[string]File)
+	err = filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && filePath != dirPath && !validName(entry.Name()) {
+			return filepath.SkipDir
+		}
+		// Symlinks are skipped, they could point anywhere
+		if !entry.Type().IsRegular() || !validName(entry.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dirPath, filepath.Dir(filePath))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fileDir := directory
+		if rel != "." {
+			fileDir = directory + "/" + rel
+		}
+		// Files are listed once per directory, to number files sharing a name
+		if _, ok := listed[fileDir]; !ok {
+			dir, err := u.ListFiles(ctx, fileDir)
+			if err != nil {
+				return err
+			}
+			listed[fileDir] = make(map[string]File, len(dir.Files))
+			for _, file := range dir.Files {
+				listed[fileDir][file.Name] = file
+			}
+		}
+		file, ok := listed[fileDir][entry.Name()]
+		if !ok {
+			// Uploaded since the directory was listed
+			return nil
+		}
+		if file.Sha256 == "" {
+			if file.Sha256, err = sha256File(filePath); err != nil {
+				return err
+			}
+		}
+		checksums = append(checksums, Checksum{
+			Path:   path.Join(rel, file.DownloadName),
+			Sha256: file.Sha256,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list checksums of %s: %v", directory, err)
+	}
+	return checksums, nil
+}
+
+func sha256File(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to calculate SHA-256: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,100 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestService creates a FileService with an empty shared directory and a
+// metadata store.
+func newTestService(t *testing.T) (*FileService, *metadata.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := metadata.NewMetadataStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDir := filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(filepath.Join(baseDir, "shared", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewFileService(&Config{BaseDir: baseDir, MaxFileSize: 1 << 20}, store), store
+}
+
+// addUpload writes a file as uploads name it and records its metadata.
+func addUpload(t *testing.T, service *FileService, store *metadata.Store, directory, filename, originalName, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(service.config.BaseDir, directory, filename), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Create(context.Background(), &metadata.File{
+		Directory:    directory,
+		Filename:     filename,
+		OriginalName: originalName,
+		Size:         int64(len(content)),
+		Sha256:       sha256Hex(content),
+		UploadedAt:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksumsDuplicateNames(t *testing.T) {
+	service, store := newTestService(t)
+	addUpload(t, service, store, "shared", "aaaaaaaaaaaaaaaa-11111111111111111111111111111111-report.txt", "report.txt", "first")
+	addUpload(t, service, store, "shared", "bbbbbbbbbbbbbbbb-22222222222222222222222222222222-report.txt", "report.txt", "second")
+	addUpload(t, service, store, "shared", "cccccccccccccccc-33333333333333333333333333333333-report (2).txt", "report (2).txt", "third")
+	addUpload(t, service, store, "shared/sub", "dddddddddddddddd-44444444444444444444444444444444-report.txt", "report.txt", "fourth")
+
+	checksums, err := service.Checksums(context.Background(), "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"report.txt":     "first",
+		"report (3).txt": "second",
+		"report (2).txt": "third",
+		"sub/report.txt": "fourth",
+	}
+	if len(checksums) != len(want) {
+		t.Fatalf("Checksums = %+v, want %d files", checksums, len(want))
+	}
+	for _, checksum := range checksums {
+		content, ok := want[checksum.Path]
+		if !ok {
+			t.Errorf("Checksums has unexpected path %q", checksum.Path)
+			continue
+		}
+		delete(want, checksum.Path)
+		if checksum.Sha256 != sha256Hex(content) {
+			t.Errorf("%s: checksum %s does not match its file", checksum.Path, checksum.Sha256)
+		}
+	}
+
+	// Each file is downloaded as the name the manifest lists it under
+	file, err := service.GetFile(context.Background(), "shared", "bbbbbbbbbbbbbbbb-22222222222222222222222222222222-report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.DownloadName != "report (3).txt" {
+		t.Errorf("GetFile DownloadName = %q, want %q", file.DownloadName, "report (3).txt")
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,151 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9\-_.]+`)
+	unsafeDirChars  = regexp.MustCompile(`[^a-zA-Z0-9\-_]+`)
+)
+
+// SanitizeName removes everything but letters, digits, dashes, underscores
+// and dots from the base name of a file.
+func SanitizeName(name string) string {
+	name = filepath.Base(filepath.Clean(name))
+	return unsafeNameChars.ReplaceAllString(name, "")
+}
+
+// SanitizeDir turns user input into a directory path of slash separated
+// names made of letters, digits, dashes and underscores. Empty names are
+// dropped, so the result is empty if nothing valid is left.
+func SanitizeDir(dir string) string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(filepath.ToSlash(dir), "/") {
+		if name = unsafeDirChars.ReplaceAllString(name, ""); name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// maxUploadDepth limits how many folders deep a file in a folder upload may be.
+const maxUploadDepth = 16
+
+// SplitUploadPath splits the relative path sent for a file in a folder upload
+// into its folders and its file name. Folder names are sanitized like in
+// SanitizeDir, but paths that are absolute, contain "." or "..", or are
+// nested deeper than maxUploadDepth are rejected rather than rewritten.
+func SplitUploadPath(name string) (dir, base string, err error) {
+	names := strings.Split(strings.ReplaceAll(name, "\\", "/"), "/")
+	if len(names) > maxUploadDepth+1 {
+		return "", "", fmt.Errorf("%w: %s is nested too deep", ErrInvalidName, name)
+	}
+	base = names[len(names)-1]
+	if base == "" || base == "." || base == ".." {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidName, name)
+	}
+	dirs := make([]string, 0, len(names)-1)
+	for _, d := range names[:len(names)-1] {
+		if d == "" || d == "." || d == ".." {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidName, name)
+		}
+		if d = unsafeDirChars.ReplaceAllString(d, ""); d == "" {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidName, name)
+		}
+		dirs = append(dirs, d)
+	}
+	return strings.Join(dirs, "/"), base, nil
+}
+
+// CleanDir validates a slash separated directory path relative to the base
+// directory. Every name in it must be valid, so it can neither climb out
+// with ".." nor reach hidden directories like the trash.
+func CleanDir(dir string) (string, error) {
+	if dir == "" {
+		return "", ErrInvalidName
+	}
+	for _, name := range strings.Split(dir, "/") {
+		if !validName(name) {
+			return "", ErrInvalidName
+		}
+	}
+	return dir, nil
+}
+
+// TopLevel returns the directory directly below the base directory that dir
+// is in. Retention policies and legal holds are set on these.
+func TopLevel(dir string) string {
+	top, _, _ := strings.Cut(dir, "/")
+	return top
+}
+
+// Parent returns the parent of a nested directory, or an empty string for a
+// top level directory.
+func Parent(dir string) string {
+	if parent := path.Dir(dir); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+// ResolveDir returns the path of an existing directory below baseDir.
+// Symlinks are never followed, so a link planted anywhere along the way
+// cannot lead outside baseDir.
+func ResolveDir(baseDir, dir string) (string, error) {
+	dir, err := CleanDir(dir)
+	if err != nil {
+		return "", err
+	}
+	dirPath := baseDir
+	for _, name := range strings.Split(dir, "/") {
+		dirPath = filepath.Join(dirPath, name)
+		info, err := os.Lstat(dirPath)
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stat directory %s: %v", dirPath, err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%w: %s is not a directory", ErrInvalidName, dir)
+		}
+	}
+	return dirPath, nil
+}
+
+// MakeDir creates a directory below baseDir along with any missing parents,
+// and returns its path. Like ResolveDir it never follows symlinks.
+func MakeDir(baseDir, dir string) (string, error) {
+	dir, err := CleanDir(dir)
+	if err != nil {
+		return "", err
+	}
+	dirPath := baseDir
+	for _, name := range strings.Split(dir, "/") {
+		dirPath = filepath.Join(dirPath, name)
+		if err := os.Mkdir(dirPath, 0755); err != nil && !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create directory: %v", err)
+		}
+		info, err := os.Lstat(dirPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat directory %s: %v", dirPath, err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%w: %s is not a directory", ErrInvalidName, dir)
+		}
+	}
+	return dirPath, nil
+}
+
+// validName reports whether name is a single path element that stays in
+// its parent directory. Hidden names are reserved, which keeps the trash out
+// of listings and download links.
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\\x00")
+}
@@ -0,0 +1,190 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanDir(t *testing.T) {
+	tests := []struct {
+		dir   string
+		valid bool
+	}{
+		{"shared", true},
+		{"shared/incoming", true},
+		{"a/b/c", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc", false},
+		{"shared/..", false},
+		{"shared/../..", false},
+		{"shared/./incoming", false},
+		{".trash", false},
+		{"shared/.quarantine", false},
+		{"shared//incoming", false},
+		{"/shared", false},
+		{"shared/", false},
+		{`shared\..\..`, false},
+		{`..\etc`, false},
+		{"shared\x00", false},
+	}
+	for _, tt := range tests {
+		_, err := CleanDir(tt.dir)
+		if tt.valid && err != nil {
+			t.Errorf("CleanDir(%q) = %v, want no error", tt.dir, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CleanDir(%q) = %v, want ErrInvalidName", tt.dir, err)
+		}
+	}
+}
+
+func TestSplitUploadPath(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		base string
+		ok   bool
+	}{
+		{"report.txt", "", "report.txt", true},
+		{"photos/2024/a.txt", "photos/2024", "a.txt", true},
+		{`photos\2024\a.txt`, "photos/2024", "a.txt", true},
+		{"my photos!/a.txt", "myphotos", "a.txt", true},
+		// Dots are stripped from folder names, so they cannot be hidden
+		{".trash/a.txt", "trash", "a.txt", true},
+		{"photos/.quarantine/a.txt", "photos/quarantine", "a.txt", true},
+		{"", "", "", false},
+		{".", "", "", false},
+		{"..", "", "", false},
+		{"photos/", "", "", false},
+		{"photos/..", "", "", false},
+		{"../a.txt", "", "", false},
+		{"photos/../../a.txt", "", "", false},
+		{`..\..\a.txt`, "", "", false},
+		{"./a.txt", "", "", false},
+		{"/etc/a.txt", "", "", false},
+		{"photos//a.txt", "", "", false},
+		{"!!!/a.txt", "", "", false},
+		{"a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/a.txt", "", "", false},
+	}
+	for _, tt := range tests {
+		dir, base, err := SplitUploadPath(tt.name)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidName) {
+				t.Errorf("SplitUploadPath(%q) = %q, %q, %v, want ErrInvalidName", tt.name, dir, base, err)
+			}
+			continue
+		}
+		if err != nil || dir != tt.dir || base != tt.base {
+			t.Errorf("SplitUploadPath(%q) = %q, %q, %v, want %q, %q", tt.name, dir, base, err, tt.dir, tt.base)
+		}
+	}
+}
+
+// newSymlinkTree creates a base directory holding shared/inside, with
+// symlinks in it pointing to a directory and a file outside of it.
+func newSymlinkTree(t *testing.T) (baseDir string) {
+	t.Helper()
+	root := t.TempDir()
+	baseDir = filepath.Join(root, "base")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{filepath.Join(baseDir, "shared", "inside"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(baseDir, "shared", "inside", "ok.txt"): "ok",
+		filepath.Join(outside, "secret.txt"):                 "secret",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(baseDir, "escape"):                    outside,
+		filepath.Join(baseDir, "shared", "escape"):          outside,
+		filepath.Join(baseDir, "shared", "inside", "link"):  filepath.Join(outside, "secret.txt"),
+		filepath.Join(baseDir, "shared", "inside", "local"): filepath.Join(baseDir, "shared", "inside", "ok.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return baseDir
+}
+
+func TestResolveDirSymlinks(t *testing.T) {
+	baseDir := newSymlinkTree(t)
+	tests := []struct {
+		dir   string
+		valid bool
+	}{
+		{"shared", true},
+		{"shared/inside", true},
+		{"escape", false},
+		{"shared/escape", false},
+		{"escape/nested", false},
+		{"shared/inside/link", false},
+		{"shared/../escape", false},
+	}
+	for _, tt := range tests {
+		dirPath, err := ResolveDir(baseDir, tt.dir)
+		if tt.valid && err != nil {
+			t.Errorf("ResolveDir(%q) = %v, want no error", tt.dir, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ResolveDir(%q) = %q, want an error", tt.dir, dirPath)
+		}
+	}
+}
+
+func TestMakeDirSymlinks(t *testing.T) {
+	baseDir := newSymlinkTree(t)
+	outside := filepath.Join(filepath.Dir(baseDir), "outside")
+	for _, dir := range []string{"escape", "escape/new", "shared/escape/new", "shared/inside/link", "../outside/new"} {
+		if dirPath, err := MakeDir(baseDir, dir); err == nil {
+			t.Errorf("MakeDir(%q) = %q, want an error", dir, dirPath)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("MakeDir created a directory outside the base directory")
+	}
+	if _, err := MakeDir(baseDir, "shared/inside/new"); err != nil {
+		t.Errorf("MakeDir(%q) = %v, want no error", "shared/inside/new", err)
+	}
+}
+
+func TestFilePathSymlinks(t *testing.T) {
+	baseDir := newSymlinkTree(t)
+	service := &FileService{config: &Config{BaseDir: baseDir}}
+	tests := []struct {
+		dir      string
+		filename string
+		valid    bool
+	}{
+		{"shared/inside", "ok.txt", true},
+		{"shared/inside", "link", false},
+		{"shared/inside", "local", false},
+		{"shared/escape", "secret.txt", false},
+		{"escape", "secret.txt", false},
+		{"shared", "../escape/secret.txt", false},
+		{"shared", "..", false},
+		{"shared", ".", false},
+		{"shared", "", false},
+	}
+	for _, tt := range tests {
+		filePath, _, err := service.filePath(tt.dir, tt.filename)
+		if tt.valid && err != nil {
+			t.Errorf("filePath(%q, %q) = %v, want no error", tt.dir, tt.filename, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("filePath(%q, %q) = %q, want an error", tt.dir, tt.filename, filePath)
+		}
+	}
+}
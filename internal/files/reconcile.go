@@ -0,0 +1,126 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/inspect"
+	"github.com/frodejac/globster/internal/tracing"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ReconcileReport lists what a reconcile run changed, or would change in a
+// dry run. Paths are relative to the base directory.
+type ReconcileReport struct {
+	Added   []string
+	Removed []string
+}
+
+// Reconcile brings the file metadata in line with the files on disk. Files
+// without metadata, such as those uploaded by older versions or copied in by
+// hand, are recorded with their size, content type and checksum. Metadata
+// of files that no longer exist is removed. The trash is left alone, since
+// its metadata is kept for restoring items.
+func (u *FileService) Reconcile(ctx context.Context, dryRun bool) (_ *ReconcileReport, err error) {
+	ctx, span := tracing.Start(ctx, "files.Reconcile")
+	defer tracing.End(span, &err)
+
+	recorded, err := u.metadata.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{}
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(u.config.BaseDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Hidden directories, including the trash, are never shared
+		if entry.IsDir() && filePath != u.config.BaseDir && !validName(entry.Name()) {
+			return filepath.SkipDir
+		}
+		// Symlinks are skipped, they could point anywhere
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(u.config.BaseDir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		directory := path.Dir(rel)
+		// Files directly in the base directory are not in any shared directory
+		if directory == "." {
+			return nil
+		}
+		seen[rel] = true
+		if _, ok := recorded[rel]; ok {
+			return nil
+		}
+		report.Added = append(report.Added, rel)
+		if dryRun {
+			return nil
+		}
+		file, err := inspectFile(filePath)
+		if err != nil {
+			return err
+		}
+		file.Directory = directory
+		file.Filename = entry.Name()
+		file.OriginalName = legacyName(entry.Name())
+		return u.metadata.Create(ctx, file)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile %s: %v", u.config.BaseDir, err)
+	}
+
+	for rel, file := range recorded {
+		if seen[rel] || file.Directory == TrashDir || strings.HasPrefix(file.Directory, TrashDir+"/") {
+			continue
+		}
+		report.Removed = append(report.Removed, rel)
+		if dryRun {
+			continue
+		}
+		if err := u.metadata.Delete(ctx, file.Directory, file.Filename); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// inspectFile reads a file to find its content type and SHA-256 checksum.
+func inspectFile(filePath string) (*metadata.File, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	content, err := inspect.Detect(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to calculate SHA-256: %v", err)
+	}
+	return &metadata.File{
+		Size:        info.Size(),
+		ContentType: content.MimeType,
+		Sha256:      hex.EncodeToString(h.Sum(nil)),
+		UploadedAt:  info.ModTime(),
+	}, nil
+}
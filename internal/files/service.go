@@ -1,8 +1,12 @@
 package files
 
 import (
-	"crypto/md5"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"log/slog"
 	"os"
@@ -10,17 +14,20 @@ import (
 	"strings"
 )
 
-func NewFileService(config *Config) *FileService {
+func NewFileService(config *Config, metadata *metadata.Store) *FileService {
 	if config == nil {
 		config = &Config{
 			BaseDir:     "/tmp",
 			MaxFileSize: 10 * 1024 * 1024, // 10 MB
 		}
 	}
-	return &FileService{config: config}
+	return &FileService{config: config, metadata: metadata}
 }
 
-func (u *FileService) ListDirectories() ([]Directory, error) {
+func (u *FileService) ListDirectories(ctx context.Context) (_ []Directory, err error) {
+	_, span := tracing.Start(ctx, "files.ListDirectories")
+	defer tracing.End(span, &err)
+
 	// List all directories on disk
 	entries, err := os.ReadDir(u.config.BaseDir)
 	if err != nil {
@@ -29,7 +36,7 @@ func (u *FileService) ListDirectories() ([]Directory, error) {
 
 	dirInfo := make([]Directory, 0, len(entries))
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && validName(entry.Name()) {
 			info, err := os.Stat(filepath.Join(u.config.BaseDir, entry.Name()))
 			if err != nil {
 				return nil, fmt.Errorf("failed to stat directory %s: %v", entry.Name(), err)
@@ -66,12 +73,15 @@ func (u *FileService) ListDirectories() ([]Directory, error) {
 	return dirInfo, nil
 }
 
-func (u *FileService) ListFiles(directory string) (*Directory, error) {
+func (u *FileService) ListFiles(ctx context.Context, directory string) (_ *Directory, err error) {
+	ctx, span := tracing.Start(ctx, "files.ListFiles", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
 	// Validate the directory
-	if directory == "" {
-		return nil, fmt.Errorf("directory is required")
+	dirPath, err := ResolveDir(u.config.BaseDir, directory)
+	if err != nil {
+		return nil, err
 	}
-	dirPath := filepath.Join(u.config.BaseDir, directory)
 
 	// Get directory info
 	info, err := os.Stat(dirPath)
@@ -85,52 +95,50 @@ func (u *FileService) ListFiles(directory string) (*Directory, error) {
 		return nil, fmt.Errorf("failed to read directory %s: %v", dirPath, err)
 	}
 
+	recorded, err := u.metadata.List(ctx, directory)
+	if err != nil {
+		return nil, err
+	}
+
 	fileList := make([]File, 0, len(files))
+	subdirectories := make([]string, 0)
 	for _, file := range files {
-		if !file.IsDir() {
+		// Symlinks are skipped, they could point anywhere
+		if file.IsDir() && validName(file.Name()) {
+			subdirectories = append(subdirectories, file.Name())
+		}
+		if file.Type().IsRegular() {
 			fileInfo, err := file.Info()
 			if err != nil {
 				return nil, fmt.Errorf("failed to get file info %s: %v", file.Name(), err)
 			}
-			fileList = append(fileList, File{
-				Name:         fileInfo.Name(),
-				DisplayName:  u.DisplayName(fileInfo.Name()),
-				Size:         fileInfo.Size(),
-				LastModified: fileInfo.ModTime(),
-			})
+			fileList = append(fileList, newFile(fileInfo, recorded[fileInfo.Name()]))
 		}
 	}
+	setDownloadNames(fileList)
 
 	// Create a Directory struct to return
 	dirInfo := &Directory{
-		Name:         directory,
-		FileCount:    len(fileList),
-		Files:        fileList,
-		Size:         info.Size(),
-		LastModified: info.ModTime(),
+		Name:           directory,
+		Parent:         Parent(directory),
+		FileCount:      len(fileList),
+		Files:          fileList,
+		Subdirectories: subdirectories,
+		Size:           info.Size(),
+		LastModified:   info.ModTime(),
 	}
 
 	return dirInfo, nil
 }
 
-func (u *FileService) GetFilePath(directory, filename string) (string, os.FileInfo, error) {
-	// Validate the directory and filename
-	if directory == "" || filename == "" {
-		return "", nil, fmt.Errorf("directory and filename are required")
-	}
-	filePath := filepath.Join(u.config.BaseDir, directory, filename)
-	filePath = filepath.Clean(filePath)
+func (u *FileService) GetFilePath(ctx context.Context, directory, filename string) (_ string, _ os.FileInfo, err error) {
+	_, span := tracing.Start(ctx, "files.GetFilePath", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
 
-	// Validate the file
-	fileInfo, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		return "", nil, fmt.Errorf("file does not exist")
-	}
+	// Validate the directory and filename
+	filePath, fileInfo, err := u.filePath(directory, filename)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to stat file %s: %v", filePath, err)
-	}
-	if fileInfo.IsDir() {
-		return "", nil, fmt.Errorf("path is a directory, not a file")
+		return "", nil, err
 	}
 	// Check file size
 	if fileInfo.Size() > u.config.MaxFileSize {
@@ -140,20 +148,258 @@ func (u *FileService) GetFilePath(directory, filename string) (string, os.FileIn
 	return filePath, fileInfo, nil
 }
 
-func (u *FileService) DisplayName(filename string) string {
-	return strings.SplitN(filename, "-", 3)[2]
+// Open opens a file for reading. Reads are recorded on a span that ends when
+// the returned file is closed.
+func (u *FileService) Open(ctx context.Context, directory, filename string) (io.ReadSeekCloser, os.FileInfo, error) {
+	filePath, fileInfo, err := u.GetFilePath(ctx, directory, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, span := tracing.Start(ctx, "files.Read", attribute.String("globster.directory", directory))
+	file, err := os.Open(filePath)
+	if err != nil {
+		tracing.End(span, &err)
+		return nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	return &tracedFile{File: file, span: span}, fileInfo, nil
+}
+
+// DisplayName returns the name a file is shown and downloaded as.
+func (u *FileService) DisplayName(ctx context.Context, directory, filename string) string {
+	if file, err := u.metadata.Get(ctx, directory, filename); err == nil {
+		return file.OriginalName
+	}
+	return legacyName(filename)
+}
+
+// legacyName recovers the original name of a file without metadata from
+// its filename, which uploads prefix with a random string and a token.
+// Files that were copied in by hand are shown as they are named.
+func legacyName(filename string) string {
+	parts := strings.SplitN(filename, "-", 3)
+	if len(parts) != 3 || len(parts[0]) != 16 || len(parts[1]) != 32 || parts[2] == "" {
+		return filename
+	}
+	return parts[2]
+}
+
+// newFile describes a file on disk, using its metadata if there is any.
+func newFile(info os.FileInfo, recorded metadata.File) File {
+	file := File{
+		Name:         info.Name(),
+		DisplayName:  legacyName(info.Name()),
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}
+	if recorded.Filename == "" {
+		return file
+	}
+	file.DisplayName = recorded.OriginalName
+	file.ContentType = recorded.ContentType
+	file.Sha256 = recorded.Sha256
+	file.Md5 = recorded.Md5
+	file.ScanVerdict = recorded.ScanVerdict
+	switch {
+	case recorded.UploadedBy != "":
+		file.Uploader = recorded.UploadedBy
+	case recorded.UploadToken != "":
+		file.Uploader = "Upload link"
+	}
+	return file
+}
+
+// setDownloadNames sets the name each file in a directory is downloaded as.
+// That is its display name, but files sharing one are numbered in the order
+// of their filenames, like "report (2).txt", so that downloading a folder
+// doesn't overwrite files and checksum manifests name each file once.
+func setDownloadNames(files []File) {
+	taken := make(map[string]bool, len(files))
+	for _, file := range files {
+		taken[file.DisplayName] = true
+	}
+	used := make(map[string]bool, len(files))
+	for i := range files {
+		name := files[i].DisplayName
+		if used[name] {
+			ext := filepath.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for n := 2; taken[name] || used[name]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+			}
+		}
+		used[name] = true
+		files[i].DownloadName = name
+	}
+}
+
+func (f *tracedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.bytesRead += int64(n)
+	return n, err
+}
+
+func (f *tracedFile) Close() error {
+	err := f.File.Close()
+	f.span.SetAttributes(attribute.Int64("globster.bytes_read", f.bytesRead))
+	tracing.End(f.span, &err)
+	return err
 }
 
-func (u *FileService) Md5Sum(filepath string) (string, error) {
-	f, err := os.Open(filepath)
+// Rename changes the name a file is shown and downloaded as. The random
+// prefix and the file extension are kept, so a rename can neither collide
+// with other uploads nor change the file type. It returns the new filename.
+func (u *FileService) Rename(ctx context.Context, directory, filename, name string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "files.Rename", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	filePath, _, err := u.filePath(directory, filename)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %v", err)
+		return "", err
+	}
+	parts := strings.SplitN(filename, "-", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: %s was not uploaded through Globster", ErrInvalidName, filename)
+	}
+	ext := filepath.Ext(parts[2])
+	base := strings.TrimSuffix(SanitizeName(name), ext)
+	if base == "" {
+		return "", ErrInvalidName
 	}
-	defer f.Close()
+	newName := fmt.Sprintf("%s-%s-%s%s", parts[0], parts[1], base, ext)
+	if len(newName) > 255 {
+		newName = newName[:255-len(ext)] + ext
+	}
+	if newName == filename {
+		return newName, nil
+	}
+	if err := u.rename(filePath, filepath.Join(filepath.Dir(filePath), newName)); err != nil {
+		return "", err
+	}
+	if err := u.metadata.Rename(ctx, directory, filename, newName, strings.SplitN(newName, "-", 3)[2]); err != nil {
+		logMetadataError(err)
+	}
+	return newName, nil
+}
+
+// Move moves a file to another existing directory, keeping its name.
+func (u *FileService) Move(ctx context.Context, directory, filename, target string) (err error) {
+	ctx, span := tracing.Start(ctx, "files.Move", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	filePath, _, err := u.filePath(directory, filename)
+	if err != nil {
+		return err
+	}
+	targetPath, err := ResolveDir(u.config.BaseDir, target)
+	if err != nil {
+		return err
+	}
+	if target == directory {
+		return nil
+	}
+	if err := u.rename(filePath, filepath.Join(targetPath, filename)); err != nil {
+		return err
+	}
+	if err := u.metadata.Move(ctx, directory, filename, target, filename); err != nil {
+		logMetadataError(err)
+	}
+	return nil
+}
+
+// logMetadataError logs a failure to update file metadata after the file
+// itself was changed. The file on disk is what counts, and reconcile
+// brings the metadata back in line with it.
+func logMetadataError(err error) {
+	slog.Error("Failed to update file metadata, run globster files reconcile to repair it", "error", err)
+}
+
+// rename moves a file without overwriting an existing one.
+func (u *FileService) rename(from, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return ErrExists
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	return nil
+}
 
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", fmt.Errorf("failed to calculate MD5: %v", err)
+// filePath returns the path of an existing regular file in a directory
+// below the base directory. Symlinks are never followed.
+func (u *FileService) filePath(directory, filename string) (string, os.FileInfo, error) {
+	dirPath, err := ResolveDir(u.config.BaseDir, directory)
+	if err != nil {
+		return "", nil, err
+	}
+	if !validName(filename) {
+		return "", nil, ErrInvalidName
+	}
+	filePath := filepath.Join(dirPath, filename)
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to stat file %s: %v", filePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidName, filename)
+	}
+	return filePath, info, nil
+}
+
+// CreateDirectory creates a subdirectory of parent, which may be empty to
+// create a top level directory. It returns the path of the new directory
+// relative to the base directory.
+func (u *FileService) CreateDirectory(ctx context.Context, parent, name string) (_ string, err error) {
+	_, span := tracing.Start(ctx, "files.CreateDirectory", attribute.String("globster.directory", parent))
+	defer tracing.End(span, &err)
+
+	name = SanitizeDir(name)
+	if name == "" {
+		return "", ErrInvalidName
+	}
+	directory := name
+	if parent != "" {
+		if _, err := ResolveDir(u.config.BaseDir, parent); err != nil {
+			return "", err
+		}
+		directory = parent + "/" + name
+	}
+	if _, err := ResolveDir(u.config.BaseDir, directory); err == nil {
+		return "", ErrExists
+	}
+	if _, err := MakeDir(u.config.BaseDir, directory); err != nil {
+		return "", err
+	}
+	return directory, nil
+}
+
+// GetFile returns a file in a directory.
+func (u *FileService) GetFile(ctx context.Context, directory, filename string) (_ *File, err error) {
+	ctx, span := tracing.Start(ctx, "files.GetFile", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	_, info, err := u.filePath(directory, filename)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := u.metadata.Get(ctx, directory, filename)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		recorded = &metadata.File{}
+	} else if err != nil {
+		return nil, err
+	}
+	file := newFile(info, *recorded)
+	// Whether the display name is taken depends on the other files
+	dir, err := u.ListFiles(ctx, directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range dir.Files {
+		if other.Name == filename {
+			file.DownloadName = other.DownloadName
+		}
 	}
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+	return &file, nil
 }
@@ -0,0 +1,187 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/trash"
+	"github.com/frodejac/globster/internal/random"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// TrashDir is the directory below the base directory that deleted files and
+// directories are moved to. Its name can never be a valid directory name.
+const TrashDir = ".trash"
+
+func NewTrashService(store *trash.Store, files *FileService) *TrashService {
+	return &TrashService{store: store, files: files}
+}
+
+// DeleteFile moves a file to the trash.
+func (t *TrashService) DeleteFile(ctx context.Context, directory, filename, username string) (err error) {
+	ctx, span := tracing.Start(ctx, "files.DeleteFile", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	filePath, info, err := t.files.filePath(directory, filename)
+	if err != nil {
+		return err
+	}
+	item := &trash.Item{
+		Kind:      trash.KindFile,
+		Directory: directory,
+		Filename:  filename,
+		Size:      info.Size(),
+		DeletedBy: username,
+	}
+	if err := t.moveToTrash(ctx, filePath, item); err != nil {
+		return err
+	}
+	if err := t.files.metadata.Move(ctx, directory, filename, TrashDir, item.TrashName); err != nil {
+		logMetadataError(err)
+	}
+	return nil
+}
+
+// DeleteDirectory moves a directory and everything in it to the trash.
+func (t *TrashService) DeleteDirectory(ctx context.Context, directory, username string) (err error) {
+	ctx, span := tracing.Start(ctx, "files.DeleteDirectory", attribute.String("globster.directory", directory))
+	defer tracing.End(span, &err)
+
+	dirPath, err := ResolveDir(t.files.config.BaseDir, directory)
+	if err != nil {
+		return err
+	}
+	item := &trash.Item{
+		Kind:      trash.KindDirectory,
+		Directory: directory,
+		Size:      dirSize(dirPath),
+		DeletedBy: username,
+	}
+	if err := t.moveToTrash(ctx, dirPath, item); err != nil {
+		return err
+	}
+	if err := t.files.metadata.MoveDirectory(ctx, directory, TrashDir+"/"+item.TrashName); err != nil {
+		logMetadataError(err)
+	}
+	return nil
+}
+
+func (t *TrashService) moveToTrash(ctx context.Context, path string, item *trash.Item) error {
+	trashDir := filepath.Join(t.files.config.BaseDir, TrashDir)
+	if err := os.MkdirAll(trashDir, 0700); err != nil {
+		return fmt.Errorf("failed to create trash directory: %v", err)
+	}
+	item.TrashName = random.String(32)
+	item.DeletedAt = time.Now()
+	trashPath := filepath.Join(trashDir, item.TrashName)
+	if err := os.Rename(path, trashPath); err != nil {
+		return fmt.Errorf("failed to move to trash: %v", err)
+	}
+	if err := t.store.Create(ctx, item); err != nil {
+		// Without a record the item could never be restored, so put it back
+		if err := os.Rename(trashPath, path); err != nil {
+			slog.Error("Failed to move item back from trash", "path", path, "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *TrashService) List(ctx context.Context) ([]trash.Item, error) {
+	return t.store.List(ctx)
+}
+
+// Restore moves an item back to where it was deleted from. It fails with
+// ErrExists if something else has been put there since.
+func (t *TrashService) Restore(ctx context.Context, id int64) (_ *trash.Item, err error) {
+	ctx, span := tracing.Start(ctx, "files.Restore")
+	defer tracing.End(span, &err)
+
+	item, err := t.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Recreate the parents of the item if they were deleted since
+	var target string
+	if item.Kind == trash.KindFile {
+		dirPath, err := MakeDir(t.files.config.BaseDir, item.Directory)
+		if err != nil {
+			return nil, err
+		}
+		target = filepath.Join(dirPath, item.Filename)
+	} else if parent := Parent(item.Directory); parent != "" {
+		parentPath, err := MakeDir(t.files.config.BaseDir, parent)
+		if err != nil {
+			return nil, err
+		}
+		target = filepath.Join(parentPath, path.Base(item.Directory))
+	} else {
+		target = filepath.Join(t.files.config.BaseDir, item.Directory)
+	}
+	if err := t.files.rename(t.trashPath(item), target); err != nil {
+		return nil, err
+	}
+	if item.Kind == trash.KindFile {
+		err = t.files.metadata.Move(ctx, TrashDir, item.TrashName, item.Directory, item.Filename)
+	} else {
+		err = t.files.metadata.MoveDirectory(ctx, TrashDir+"/"+item.TrashName, item.Directory)
+	}
+	if err != nil {
+		logMetadataError(err)
+	}
+	if err := t.store.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Purge permanently deletes an item in the trash.
+func (t *TrashService) Purge(ctx context.Context, id int64) (_ *trash.Item, err error) {
+	ctx, span := tracing.Start(ctx, "files.Purge")
+	defer tracing.End(span, &err)
+
+	item, err := t.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(t.trashPath(item)); err != nil {
+		return nil, fmt.Errorf("failed to purge trash item: %v", err)
+	}
+	if item.Kind == trash.KindFile {
+		err = t.files.metadata.Delete(ctx, TrashDir, item.TrashName)
+	} else {
+		err = t.files.metadata.DeleteDirectory(ctx, TrashDir+"/"+item.TrashName)
+	}
+	if err != nil {
+		logMetadataError(err)
+	}
+	if err := t.store.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (t *TrashService) trashPath(item *trash.Item) string {
+	return filepath.Join(t.files.config.BaseDir, TrashDir, item.TrashName)
+}
+
+// dirSize returns the total size of the regular files below path.
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
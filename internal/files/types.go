@@ -1,6 +1,19 @@
 package files
 
-import "time"
+import (
+	"errors"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/database/trash"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("file does not exist")
+	ErrExists      = errors.New("file already exists")
+	ErrInvalidName = errors.New("invalid file or directory name")
+)
 
 type Config struct {
 	BaseDir     string
@@ -8,20 +21,48 @@ type Config struct {
 }
 
 type FileService struct {
-	config *Config
+	config   *Config
+	metadata *metadata.Store
+}
+
+type TrashService struct {
+	store *trash.Store
+	files *FileService
 }
 
 type Directory struct {
-	Name         string
-	Size         int64
-	Files        []File
-	FileCount    int
-	LastModified time.Time
+	// Name is the slash separated path below the base directory
+	Name string
+	// Parent is empty for top level directories
+	Parent         string
+	Size           int64
+	Files          []File
+	Subdirectories []string
+	FileCount      int
+	LastModified   time.Time
 }
 
 type File struct {
-	Name         string
-	DisplayName  string
+	Name        string
+	DisplayName string
+	// DownloadName is DisplayName made unique within the directory
+	DownloadName string
 	Size         int64
 	LastModified time.Time
+	// ContentType, Uploader and the checksums are empty for files without
+	// metadata, and Md5 also when MD5 checksums are disabled
+	ContentType string
+	Uploader    string
+	Sha256      string
+	Md5         string
+	// ScanVerdict is the malware scanner's verdict, empty if not scanned
+	ScanVerdict string
+	// DeleteAt is when retention cleanup deletes the file, if ever
+	DeleteAt *time.Time
+}
+
+type tracedFile struct {
+	*os.File
+	span      trace.Span
+	bytesRead int64
 }
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+func NewHealthService(config *Config) *HealthService {
+	h := &HealthService{config: config}
+	h.AddCheck("upload_path", h.checkUploadPath)
+	return h
+}
+
+// AddCheck registers a readiness check. Checks are run concurrently, so fn
+// must be safe to call from multiple goroutines.
+func (h *HealthService) AddCheck(name string, fn CheckFunc) {
+	h.checks = append(h.checks, check{name: name, fn: fn})
+}
+
+// Ready runs all readiness checks and reports each result with its latency.
+func (h *HealthService) Ready(ctx context.Context) *Report {
+	report := &Report{
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult, len(h.checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t0 := time.Now()
+			err := c.fn(ctx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMs: float64(time.Since(t0).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// checkUploadPath verifies that the upload directory accepts writes and has
+// more free space than the configured minimum.
+func (h *HealthService) checkUploadPath(ctx context.Context) error {
+	f, err := os.CreateTemp(h.config.UploadPath, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("upload path not writable: %v", err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove probe file %s: %v", filepath.Base(name), err)
+	}
+
+	free, err := freeDiskBytes(h.config.UploadPath)
+	if err != nil {
+		return fmt.Errorf("failed to get free disk space: %v", err)
+	}
+	if free < h.config.MinFreeDiskBytes {
+		return fmt.Errorf("free disk space %d bytes is below minimum %d bytes", free, h.config.MinFreeDiskBytes)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package health
+
+import "context"
+
+type Config struct {
+	UploadPath       string
+	MinFreeDiskBytes uint64
+}
+
+// CheckFunc returns nil when the dependency is ready.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+type HealthService struct {
+	config *Config
+	checks []check
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
@@ -0,0 +1,176 @@
+package inspect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// headSize is how much of the start of a file is read. Signatures are
+	// found well within it, and PDF readers look for their header in the
+	// first 1024 bytes.
+	headSize    = 8192
+	pdfLookback = 1024
+	// tailSize covers a ZIP end of central directory record, along with
+	// the longest comment it can have
+	tailSize = 22 + 65535
+)
+
+var (
+	pdfMagic  = []byte("%PDF-")
+	eocdMagic = []byte("PK\x05\x06")
+	// htmlMarkers make browsers and other tools treat a file as HTML, and
+	// never occur in the first bytes of binary formats
+	htmlMarkers = [][]byte{[]byte("<script"), []byte("<html"), []byte("<svg"), []byte("<iframe"), []byte("<body")}
+)
+
+// Detect identifies the content of r, which is size bytes long, by the
+// signature of its format. Content without a known signature is sniffed
+// with http.DetectContentType, which tells text apart from other data.
+func Detect(r io.ReaderAt, size int64) (*Result, error) {
+	head := make([]byte, min(size, headSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	result := &Result{}
+	sig := match(head)
+	if sig != nil {
+		result.MimeType = sig.mimeType
+		result.Signature = true
+		result.Executable = sig.executable
+		if sig.mimeType == mimeZip {
+			result.MimeType, result.Executable = detectZip(r, size)
+		}
+	} else {
+		result.MimeType = sniff(head)
+	}
+
+	// Archives may hold files of any format, so they are not checked for
+	// other formats hidden in them
+	if sig == nil || !sig.container {
+		polyglot, err := findPolyglot(r, size, head, result)
+		if err != nil {
+			return nil, err
+		}
+		result.Polyglot = polyglot
+	}
+	return result, nil
+}
+
+// Inspect identifies the content of r like Detect, and checks that it is
+// safe to accept as a file with the given name. Executable and polyglot
+// content is rejected. A file with a known extension must contain one of
+// the formats expected for it, and a file in a format with a known
+// signature must have one of the extensions of that format.
+func Inspect(r io.ReaderAt, size int64, filename string) (*Result, error) {
+	result, err := Detect(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if result.Executable != "" {
+		return result, fmt.Errorf("%w: %s", ErrExecutable, result.Executable)
+	}
+	if result.Polyglot != "" {
+		return result, fmt.Errorf("%w: contains %s as well as %s", ErrPolyglot, result.Polyglot, result.MimeType)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if result.MimeType == mimeOleStorage {
+		if mimeType, ok := oleTypes[ext]; ok {
+			result.MimeType = mimeType
+		}
+	}
+	if expected, ok := extensions[ext]; ok {
+		if !slices.Contains(expected, result.MimeType) {
+			return result, fmt.Errorf("%w: %s file contains %s", ErrTypeMismatch, ext, result.MimeType)
+		}
+	} else if result.Signature {
+		return result, fmt.Errorf("%w: %s is not an extension of %s", ErrTypeMismatch, ext, result.MimeType)
+	}
+	return result, nil
+}
+
+// match returns the signature the content starts with, if any.
+func match(head []byte) *signature {
+	for i := range signatures {
+		sig := &signatures[i]
+		end := sig.offset + len(sig.magic)
+		if len(head) < end || string(head[sig.offset:end]) != sig.magic {
+			continue
+		}
+		if sig.check != nil && !sig.check(head) {
+			continue
+		}
+		return sig
+	}
+	return nil
+}
+
+// sniff identifies content without a known signature. The signatures of
+// http.DetectContentType are looser than ours, so when it detects a format
+// we have a signature for, ours rejected the content and it is sniffed as
+// text instead.
+func sniff(head []byte) string {
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	for _, sig := range signatures {
+		if sig.mimeType == mimeType {
+			if isText(head) {
+				return "text/plain"
+			}
+			return "application/octet-stream"
+		}
+	}
+	return mimeType
+}
+
+// isText reports whether content has no binary data, using the same rule as
+// http.DetectContentType.
+func isText(head []byte) bool {
+	for _, b := range head {
+		switch {
+		case b <= 0x08, b == 0x0B, 0x0E <= b && b <= 0x1A, 0x1C <= b && b <= 0x1F:
+			return false
+		}
+	}
+	return true
+}
+
+// findPolyglot looks for a second format that tools would find in the
+// content, besides the one it was detected as. It returns the name of the
+// format, or an empty string if there is none.
+func findPolyglot(r io.ReaderAt, size int64, head []byte, result *Result) (string, error) {
+	start := head[:min(len(head), pdfLookback)]
+	if result.MimeType != "application/pdf" && bytes.Contains(start, pdfMagic) {
+		return "PDF", nil
+	}
+	if result.Signature {
+		lower := bytes.ToLower(start)
+		for _, marker := range htmlMarkers {
+			if bytes.Contains(lower, marker) {
+				return "HTML", nil
+			}
+		}
+	}
+
+	// ZIP readers find archives by their end, so one can be appended to
+	// any other file
+	offset := max(0, size-tailSize)
+	tail := make([]byte, size-offset)
+	if _, err := r.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if bytes.Contains(tail, eocdMagic) {
+		return "ZIP archive", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,39 @@
+package inspect
+
+import (
+	"mime"
+	"strings"
+)
+
+// MatchMime reports whether mimeType matches pattern. A pattern is either an
+// exact MIME type like text/plain, a type with any subtype like image/*, or
+// */* for anything. Case and parameters such as charset are ignored.
+func MatchMime(pattern, mimeType string) bool {
+	patternType, patternSubtype, ok := splitMime(pattern)
+	if !ok {
+		return false
+	}
+	typ, subtype, ok := splitMime(mimeType)
+	if !ok {
+		return false
+	}
+	if patternType == "*" {
+		return true
+	}
+	return patternType == typ && (patternSubtype == "*" || patternSubtype == subtype)
+}
+
+// ValidPattern reports whether pattern can be matched with MatchMime.
+func ValidPattern(pattern string) bool {
+	typ, subtype, ok := splitMime(pattern)
+	return ok && (typ != "*" || subtype == "*")
+}
+
+func splitMime(s string) (typ, subtype string, ok bool) {
+	mediaType, _, err := mime.ParseMediaType(s)
+	if err != nil {
+		return "", "", false
+	}
+	typ, subtype, ok = strings.Cut(mediaType, "/")
+	return typ, subtype, ok && typ != "" && subtype != "" && !strings.Contains(subtype, "/")
+}
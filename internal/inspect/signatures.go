@@ -0,0 +1,139 @@
+package inspect
+
+import "encoding/binary"
+
+// MIME types of formats that need a closer look than their signature.
+const (
+	mimeZip        = "application/zip"
+	mimeOleStorage = "application/x-ole-storage"
+	mimeDocx       = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXlsx       = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimePptx       = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	mimeJar        = "application/java-archive"
+)
+
+// signatures are checked in order, so longer magic comes before any shorter
+// magic it starts with.
+var signatures = []signature{
+	// Documents
+	{magic: "%PDF-", mimeType: "application/pdf"},
+	{magic: "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", mimeType: mimeOleStorage},
+	{magic: "{\\rtf", mimeType: "application/rtf"},
+
+	// Images
+	{magic: "\x89PNG\r\n\x1A\n", mimeType: "image/png"},
+	{magic: "\xFF\xD8\xFF", mimeType: "image/jpeg"},
+	{magic: "GIF87a", mimeType: "image/gif"},
+	{magic: "GIF89a", mimeType: "image/gif"},
+	{magic: "RIFF", mimeType: "image/webp", check: at(8, "WEBP")},
+	{magic: "II*\x00", mimeType: "image/tiff"},
+	{magic: "MM\x00*", mimeType: "image/tiff"},
+	{magic: "BM", mimeType: "image/bmp", check: at(6, "\x00\x00\x00\x00")},
+	{magic: "\x00\x00\x01\x00", mimeType: "image/vnd.microsoft.icon"},
+
+	// Archives
+	{magic: "PK\x03\x04", mimeType: mimeZip, container: true},
+	{magic: "PK\x05\x06", mimeType: mimeZip, container: true},
+	{magic: "\x1F\x8B", mimeType: "application/gzip", container: true},
+	{magic: "BZh", mimeType: "application/x-bzip2", container: true, check: at(4, "1AY&SY")},
+	{magic: "\xFD7zXZ\x00", mimeType: "application/x-xz", container: true},
+	{magic: "\x28\xB5\x2F\xFD", mimeType: "application/zstd", container: true},
+	{magic: "7z\xBC\xAF\x27\x1C", mimeType: "application/x-7z-compressed", container: true},
+	{magic: "Rar!\x1A\x07", mimeType: "application/vnd.rar", container: true},
+	{offset: 257, magic: "ustar", mimeType: "application/x-tar", container: true},
+
+	// Executables
+	{magic: "MZ", mimeType: "application/vnd.microsoft.portable-executable", executable: "Windows executable", check: isDosExecutable},
+	{magic: "\x7FELF", mimeType: "application/x-elf", executable: "ELF executable"},
+	{magic: "\xFE\xED\xFA\xCE", mimeType: "application/x-mach-binary", executable: "Mach-O executable"},
+	{magic: "\xFE\xED\xFA\xCF", mimeType: "application/x-mach-binary", executable: "Mach-O executable"},
+	{magic: "\xCE\xFA\xED\xFE", mimeType: "application/x-mach-binary", executable: "Mach-O executable"},
+	{magic: "\xCF\xFA\xED\xFE", mimeType: "application/x-mach-binary", executable: "Mach-O executable"},
+	// Both universal Mach-O binaries and Java classes
+	{magic: "\xCA\xFE\xBA\xBE", mimeType: "application/x-mach-binary", executable: "Mach-O or Java executable"},
+	{magic: "\x00asm", mimeType: "application/wasm", executable: "WebAssembly module"},
+	{magic: "\x4C\x00\x00\x00\x01\x14\x02\x00", mimeType: "application/x-ms-shortcut", executable: "Windows shortcut"},
+	{magic: "#!", mimeType: "text/x-shellscript", executable: "script"},
+}
+
+// at returns a check for magic at an offset.
+func at(offset int, magic string) func([]byte) bool {
+	return func(head []byte) bool {
+		return len(head) >= offset+len(magic) && string(head[offset:offset+len(magic)]) == magic
+	}
+}
+
+// isDosExecutable confirms an MZ magic, which text may start with too. It
+// looks for the PE header that e_lfanew points to, and otherwise for a DOS
+// header whose sizes are plausible. Text never has one, as its bytes make
+// the last page longer than a page.
+func isDosExecutable(head []byte) bool {
+	if len(head) < 0x40 {
+		return false
+	}
+	if offset := binary.LittleEndian.Uint32(head[0x3C:]); uint64(offset)+4 <= uint64(len(head)) && string(head[offset:offset+4]) == "PE\x00\x00" {
+		return true
+	}
+	lastPageSize := binary.LittleEndian.Uint16(head[2:])
+	pages := binary.LittleEndian.Uint16(head[4:])
+	headerParagraphs := binary.LittleEndian.Uint16(head[8:])
+	return lastPageSize < 512 && pages > 0 && headerParagraphs >= 4 && int(headerParagraphs)*16 <= int(pages)*512
+}
+
+// extensions lists the MIME types a file with each extension may contain.
+// Files with other extensions may contain anything that is not detected by
+// a signature.
+var extensions = map[string][]string{
+	".pdf":  {"application/pdf"},
+	".doc":  {"application/msword"},
+	".xls":  {"application/vnd.ms-excel"},
+	".ppt":  {"application/vnd.ms-powerpoint"},
+	".msg":  {"application/vnd.ms-outlook"},
+	".docx": {mimeDocx},
+	".xlsx": {mimeXlsx},
+	".pptx": {mimePptx},
+	".odt":  {"application/vnd.oasis.opendocument.text"},
+	".ods":  {"application/vnd.oasis.opendocument.spreadsheet"},
+	".odp":  {"application/vnd.oasis.opendocument.presentation"},
+	".epub": {"application/epub+zip"},
+	".rtf":  {"application/rtf"},
+
+	".png":  {"image/png"},
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".gif":  {"image/gif"},
+	".webp": {"image/webp"},
+	".tif":  {"image/tiff"},
+	".tiff": {"image/tiff"},
+	".bmp":  {"image/bmp"},
+	".ico":  {"image/vnd.microsoft.icon"},
+
+	".zip": {mimeZip},
+	".gz":  {"application/gzip"},
+	".tgz": {"application/gzip"},
+	".bz2": {"application/x-bzip2"},
+	".xz":  {"application/x-xz"},
+	".zst": {"application/zstd"},
+	".7z":  {"application/x-7z-compressed"},
+	".rar": {"application/vnd.rar"},
+	".tar": {"application/x-tar"},
+
+	".txt":  {"text/plain"},
+	".csv":  {"text/plain", "text/csv"},
+	".tsv":  {"text/plain", "text/tab-separated-values"},
+	".log":  {"text/plain"},
+	".md":   {"text/plain", "text/markdown"},
+	".json": {"text/plain", "application/json"},
+	".xml":  {"text/xml", "application/xml"},
+	".html": {"text/html"},
+	".htm":  {"text/html"},
+}
+
+// oleTypes are the formats stored in OLE compound files, which share one
+// signature. They are told apart by their extension.
+var oleTypes = map[string]string{
+	".doc": "application/msword",
+	".xls": "application/vnd.ms-excel",
+	".ppt": "application/vnd.ms-powerpoint",
+	".msg": "application/vnd.ms-outlook",
+}
@@ -0,0 +1,50 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// peFile returns the start of a Windows executable, with the PE header
+// where e_lfanew points.
+func peFile() []byte {
+	data := make([]byte, 0x100)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3C:], 0x80)
+	copy(data[0x80:], "PE\x00\x00")
+	return data
+}
+
+// dosFile returns the start of a DOS executable without a PE header.
+func dosFile() []byte {
+	data := make([]byte, 0x100)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint16(data[2:], 0x90) // bytes on the last page
+	binary.LittleEndian.PutUint16(data[4:], 3)    // pages
+	binary.LittleEndian.PutUint16(data[8:], 4)    // header paragraphs
+	return data
+}
+
+func TestInspectMZ(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		err      error
+	}{
+		{"PE executable", "setup.exe", peFile(), ErrExecutable},
+		{"PE executable named as text", "notes.txt", peFile(), ErrExecutable},
+		{"DOS executable", "game.com", dosFile(), ErrExecutable},
+		{"text", "names.txt", []byte("MZ Mazur, Zofia\nAB Abel, Bo\n"), nil},
+		{"long text", "names.csv", bytes.Repeat([]byte("MZ,Mazur,Zofia,1984,Warszawa\n"), 10), nil},
+		{"short text", "mz.txt", []byte("MZ"), nil},
+	}
+	for _, tt := range tests {
+		result, err := Inspect(bytes.NewReader(tt.data), int64(len(tt.data)), tt.filename)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Inspect = %+v, %v, want %v", tt.name, result, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package inspect
+
+import "errors"
+
+var (
+	ErrExecutable   = errors.New("executable content not allowed")
+	ErrPolyglot     = errors.New("file is valid as more than one format")
+	ErrTypeMismatch = errors.New("file content does not match its extension")
+)
+
+// Result describes the content of a file.
+type Result struct {
+	// MimeType is the detected media type, without parameters
+	MimeType string
+	// Signature is true if MimeType was detected from a magic-byte signature
+	// of a binary format, rather than sniffed as text
+	Signature bool
+	// Executable names the kind of executable content found, if any
+	Executable string
+	// Polyglot names the second format found in the file, if any
+	Polyglot string
+}
+
+// signature identifies a format by the bytes at a fixed offset.
+type signature struct {
+	offset   int
+	magic    string
+	mimeType string
+	// executable names the kind of executable, for formats that are one
+	executable string
+	// container formats may hold other files, so signatures of other
+	// formats within them are expected
+	container bool
+	// check, if set, confirms a match of a short or shared magic
+	check func(head []byte) bool
+}
@@ -0,0 +1,70 @@
+package inspect
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxMimetypeSize limits how much of an OpenDocument mimetype entry is read.
+const maxMimetypeSize = 128
+
+// detectZip tells the formats built on ZIP apart by their entries. It
+// returns the MIME type, and names the executable content found, if any.
+// Archives that cannot be read are left as plain ZIP files.
+func detectZip(r io.ReaderAt, size int64) (mimeType, executable string) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return mimeZip, ""
+	}
+
+	mimeType = mimeZip
+	var contentTypes, word, excel, powerpoint bool
+	for i, entry := range archive.File {
+		name := entry.Name
+		switch {
+		case name == "META-INF/MANIFEST.MF", name == "classes.dex", name == "AndroidManifest.xml", path.Ext(name) == ".class":
+			return mimeJar, "Java or Android application"
+		case path.Base(name) == "vbaProject.bin":
+			executable = "Office macros"
+		case name == "[Content_Types].xml":
+			contentTypes = true
+		case strings.HasPrefix(name, "word/"):
+			word = true
+		case strings.HasPrefix(name, "xl/"):
+			excel = true
+		case strings.HasPrefix(name, "ppt/"):
+			powerpoint = true
+		case i == 0 && name == "mimetype":
+			// OpenDocument and EPUB files start with their MIME type
+			if t := readMimetype(entry); strings.HasPrefix(t, "application/vnd.oasis.opendocument.") || t == "application/epub+zip" {
+				mimeType = t
+			}
+		}
+	}
+	if contentTypes {
+		switch {
+		case word:
+			mimeType = mimeDocx
+		case excel:
+			mimeType = mimeXlsx
+		case powerpoint:
+			mimeType = mimePptx
+		}
+	}
+	return mimeType, executable
+}
+
+func readMimetype(entry *zip.File) string {
+	f, err := entry.Open()
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMimetypeSize))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,257 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/database/policies"
+	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/tracing"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func NewJanitor(store *links.Store, policies *policies.Store, metadata *metadata.Store, trash *files.TrashService, config *Config) *Janitor {
+	return &Janitor{store: store, policies: policies, metadata: metadata, trash: trash, config: config}
+}
+
+// TrashRetention returns how long deleted items stay in the trash, or zero
+// if they are kept until purged by hand.
+func (j *Janitor) TrashRetention() time.Duration {
+	return j.config.TrashRetention
+}
+
+// Retention returns how long files are kept under a directory policy, or
+// zero if they are kept forever.
+func (j *Janitor) Retention(policy policies.Policy) time.Duration {
+	return policy.Retention(j.config.FileRetention)
+}
+
+// Watch runs the janitor right away and then every interval until ctx is
+// cancelled.
+func (j *Janitor) Watch(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+	for {
+		j.Run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastReport returns the report of the most recent run, or nil before the
+// first run has finished.
+func (j *Janitor) LastReport() *Report {
+	return j.last.Load()
+}
+
+// Run cleans up once and returns the report, which is also logged and kept
+// for LastReport.
+func (j *Janitor) Run(ctx context.Context) *Report {
+	j.running.Lock()
+	defer j.running.Unlock()
+	ctx, span := tracing.Start(ctx, "janitor.Run")
+	defer span.End()
+
+	report := &Report{StartedAt: time.Now(), DryRun: j.config.DryRun}
+	if j.config.LinkRetention > 0 {
+		j.removeLinks(ctx, report)
+	}
+	j.removeFiles(ctx, report)
+	if j.config.TrashRetention > 0 {
+		j.emptyTrash(ctx, report)
+	}
+	if j.config.RemoveEmptyDirs {
+		j.removeEmptyDirs(ctx, report)
+	}
+	report.FinishedAt = time.Now()
+	j.last.Store(report)
+
+	slog.Info("Janitor finished",
+		slog.Bool("dry_run", report.DryRun),
+		slog.Int("links_purged", report.LinksPurged),
+		slog.Int("links_archived", report.LinksArchived),
+		slog.Int("files_deleted", report.FilesDeleted),
+		slog.Int64("bytes_freed", report.BytesFreed),
+		slog.Int("dirs_removed", report.DirsRemoved),
+		slog.Int("trash_purged", report.TrashPurged),
+		slog.Int("errors", len(report.Errors)),
+		slog.Duration("duration", report.FinishedAt.Sub(report.StartedAt)),
+	)
+	return report
+}
+
+func (r *Report) fail(err error) {
+	slog.Error("Janitor error", "error", err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (j *Janitor) removeLinks(ctx context.Context, report *Report) {
+	inactive, err := j.store.ListInactiveLinks(ctx, time.Now().Add(-j.config.LinkRetention))
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	for _, link := range inactive {
+		if j.config.DryRun {
+			slog.Info("Janitor would remove link", slog.String("kind", link.Kind), slog.String("dir", link.Dir), slog.Bool("archive", j.config.ArchiveLinks))
+		} else if j.config.ArchiveLinks {
+			if err := j.store.ArchiveLink(ctx, link.Kind, link.Token); err != nil {
+				report.fail(err)
+				continue
+			}
+		} else if err := j.store.PurgeLink(ctx, link.Kind, link.Token); err != nil {
+			report.fail(err)
+			continue
+		}
+		if j.config.ArchiveLinks {
+			report.LinksArchived++
+		} else {
+			report.LinksPurged++
+		}
+	}
+}
+
+// emptyTrash purges items deleted more than the trash retention ago.
+func (j *Janitor) emptyTrash(ctx context.Context, report *Report) {
+	items, err := j.trash.List(ctx)
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	cutoff := time.Now().Add(-j.config.TrashRetention)
+	for _, item := range items {
+		if !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if j.config.DryRun {
+			slog.Info("Janitor would purge trash item", slog.String("directory", item.Directory), slog.String("filename", item.Filename))
+		} else if _, err := j.trash.Purge(ctx, item.Id); err != nil {
+			report.fail(err)
+			continue
+		}
+		report.TrashPurged++
+		report.BytesFreed += item.Size
+	}
+}
+
+// removeFiles deletes uploaded files last modified before the retention
+// period of their directory. Symlinks are never followed.
+func (j *Janitor) removeFiles(ctx context.Context, report *Report) {
+	dirPolicies, err := j.policies.List(ctx)
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	entries, err := os.ReadDir(j.config.BaseDir)
+	if err != nil {
+		report.fail(fmt.Errorf("failed to read upload directory: %v", err))
+		return
+	}
+	for _, entry := range entries {
+		// The trash has its own retention, and the quarantine is emptied by hand
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		retention := j.Retention(dirPolicies[entry.Name()])
+		if retention == 0 {
+			continue
+		}
+		j.removeFilesBefore(ctx, filepath.Join(j.config.BaseDir, entry.Name()), time.Now().Add(-retention), report)
+	}
+}
+
+func (j *Janitor) removeFilesBefore(ctx context.Context, dir string, cutoff time.Time, report *Report) {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			report.fail(fmt.Errorf("failed to read %s: %v", path, err))
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			report.fail(fmt.Errorf("failed to stat %s: %v", path, err))
+			return nil
+		}
+		if !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if j.config.DryRun {
+			slog.Info("Janitor would delete file", slog.String("path", path), slog.Time("modified", info.ModTime()))
+		} else if err := os.Remove(path); err != nil {
+			report.fail(fmt.Errorf("failed to delete %s: %v", path, err))
+			return nil
+		} else if err := j.forget(ctx, path); err != nil {
+			report.fail(err)
+		}
+		report.FilesDeleted++
+		report.BytesFreed += info.Size()
+		return nil
+	})
+	if err != nil {
+		report.fail(fmt.Errorf("failed to walk %s: %v", dir, err))
+	}
+}
+
+// removeEmptyDirs removes empty upload directories that no usable link
+// points to and that are not on legal hold.
+func (j *Janitor) removeEmptyDirs(ctx context.Context, report *Report) {
+	activeDirs, err := j.store.ActiveDirs(ctx)
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	dirPolicies, err := j.policies.List(ctx)
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	entries, err := os.ReadDir(j.config.BaseDir)
+	if err != nil {
+		report.fail(fmt.Errorf("failed to read upload directory: %v", err))
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || activeDirs[entry.Name()] || dirPolicies[entry.Name()].LegalHold {
+			continue
+		}
+		path := filepath.Join(j.config.BaseDir, entry.Name())
+		children, err := os.ReadDir(path)
+		if err != nil {
+			report.fail(fmt.Errorf("failed to read %s: %v", path, err))
+			continue
+		}
+		// Files counted as deleted in a dry run are still there
+		if len(children) > 0 {
+			continue
+		}
+		if j.config.DryRun {
+			slog.Info("Janitor would remove directory", slog.String("path", path))
+		} else if err := os.Remove(path); err != nil {
+			report.fail(fmt.Errorf("failed to remove %s: %v", path, err))
+			continue
+		}
+		report.DirsRemoved++
+	}
+}
+
+// forget removes the metadata of a deleted file.
+func (j *Janitor) forget(ctx context.Context, filePath string) error {
+	rel, err := filepath.Rel(j.config.BaseDir, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to forget %s: %v", filePath, err)
+	}
+	rel = filepath.ToSlash(rel)
+	return j.metadata.Delete(ctx, path.Dir(rel), path.Base(rel))
+}
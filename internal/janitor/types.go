@@ -0,0 +1,57 @@
+package janitor
+
+import (
+	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/database/policies"
+	"github.com/frodejac/globster/internal/files"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	BaseDir  string
+	Interval time.Duration
+	// LinkRetention is how long expired and used up links are kept after
+	// their last use. Zero keeps them forever.
+	LinkRetention time.Duration
+	// ArchiveLinks moves old links to the archive instead of deleting them
+	ArchiveLinks bool
+	// FileRetention is how long uploaded files are kept in directories
+	// without a retention policy. Zero keeps them forever.
+	FileRetention time.Duration
+	// TrashRetention is how long deleted files and directories stay in the
+	// trash. Zero keeps them until they are purged by hand.
+	TrashRetention  time.Duration
+	RemoveEmptyDirs bool
+	// DryRun reports what would be removed without removing anything
+	DryRun bool
+}
+
+// Janitor periodically removes old links, files and empty directories.
+type Janitor struct {
+	store    *links.Store
+	policies *policies.Store
+	metadata *metadata.Store
+	trash    *files.TrashService
+	config   *Config
+	// running serializes scheduled and manual runs
+	running sync.Mutex
+	last    atomic.Pointer[Report]
+}
+
+// Report summarizes a janitor run. In a dry run the counts are what would
+// have been removed.
+type Report struct {
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	DryRun        bool
+	LinksPurged   int
+	LinksArchived int
+	FilesDeleted  int
+	BytesFreed    int64
+	DirsRemoved   int
+	TrashPurged   int
+	Errors        []string
+}
@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/frodejac/globster/internal/tracing"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+func NewMailer(config *Config) *Mailer {
+	return &Mailer{config: config}
+}
+
+// Enabled reports whether an SMTP server is configured.
+func (m *Mailer) Enabled() bool {
+	return m.config != nil && m.config.Host != ""
+}
+
+// Send sends a plain text message. net/smtp upgrades the connection with
+// STARTTLS when the server offers it.
+func (m *Mailer) Send(ctx context.Context, to, subject, body string) (err error) {
+	_, span := tracing.Start(ctx, "mail.Send")
+	defer tracing.End(span, &err)
+
+	if !m.Enabled() {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	if _, err := mail.ParseAddress(to); err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("header values must not contain line breaks")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	from, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	if err := smtp.SendMail(addr, auth, from.Address, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send mail: %v", err)
+	}
+	return nil
+}
+
+// SendBackground sends a message like Send, without waiting for the SMTP
+// server, so a slow server cannot hold up a response and its timing reveals
+// nothing. Failures are logged.
+func (m *Mailer) SendBackground(ctx context.Context, to, subject, body string) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := m.Send(ctx, to, subject, body); err != nil {
+			slog.Error("Failed to send mail", slog.String("subject", subject), slog.Any("error", err))
+		}
+	}()
+}
@@ -0,0 +1,13 @@
+package mail
+
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type Mailer struct {
+	config *Config
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges.
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %v", part, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %v", part, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func NewResolver(config *Config) *Resolver {
+	return &Resolver{trusted: config.TrustedProxies}
+}
+
+// Resolve returns the forwarding information for r, or nil if the request
+// did not come from a trusted proxy.
+func (p *Resolver) Resolve(r *http.Request) *Forwarded {
+	remote, ok := parseHost(r.RemoteAddr)
+	if !ok || !p.isTrusted(remote) {
+		return nil
+	}
+
+	fwd := &Forwarded{ClientIP: remote}
+	var hops []string
+	if header := r.Header.Values("Forwarded"); len(header) > 0 {
+		for _, element := range parseForwarded(header) {
+			hops = append(hops, element["for"])
+			if proto := element["proto"]; proto != "" {
+				fwd.Proto = proto
+			}
+			if host := element["host"]; host != "" {
+				fwd.Host = host
+			}
+		}
+	} else {
+		hops = splitList(r.Header.Values("X-Forwarded-For"))
+		fwd.Proto = last(splitList(r.Header.Values("X-Forwarded-Proto")))
+		fwd.Host = last(splitList(r.Header.Values("X-Forwarded-Host")))
+	}
+	fwd.Prefix = last(splitList(r.Header.Values("X-Forwarded-Prefix")))
+
+	// Walk from the nearest hop outwards, the first address we don't trust is
+	// the client. Anything to the left of it could have been forged.
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHost(hops[i])
+		if !ok {
+			break
+		}
+		fwd.ClientIP = addr
+		if !p.isTrusted(addr) {
+			break
+		}
+	}
+
+	fwd.Proto = strings.ToLower(fwd.Proto)
+	if fwd.Proto != "http" && fwd.Proto != "https" {
+		fwd.Proto = ""
+	}
+	if strings.ContainsAny(fwd.Host, "/\\@ ") {
+		fwd.Host = ""
+	}
+	fwd.Prefix = "/" + strings.Trim(fwd.Prefix, "/")
+	if fwd.Prefix == "/" || strings.Contains(fwd.Prefix, "..") {
+		fwd.Prefix = ""
+	}
+	return fwd
+}
+
+func (p *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func WithForwarded(ctx context.Context, fwd *Forwarded) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, fwd)
+}
+
+func FromContext(ctx context.Context) *Forwarded {
+	fwd, _ := ctx.Value(forwardedKey{}).(*Forwarded)
+	return fwd
+}
+
+// ClientIP returns the client address of r without the port.
+func ClientIP(r *http.Request) string {
+	if addr, ok := parseHost(r.RemoteAddr); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// BaseUrl returns the external URL the client used to reach us. It starts from
+// the configured base URL and replaces the scheme, host and path prefix with
+// whatever a trusted proxy reported.
+func BaseUrl(r *http.Request, baseUrl string) string {
+	fwd := FromContext(r.Context())
+	if fwd == nil {
+		return strings.TrimSuffix(baseUrl, "/")
+	}
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return strings.TrimSuffix(baseUrl, "/")
+	}
+	if fwd.Proto != "" {
+		base.Scheme = fwd.Proto
+	}
+	if fwd.Host != "" {
+		base.Host = fwd.Host
+	}
+	if fwd.Prefix != "" {
+		base.Path = fwd.Prefix
+	}
+	return strings.TrimSuffix(base.String(), "/")
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values into one map per element.
+func parseForwarded(values []string) []map[string]string {
+	elements := make([]map[string]string, 0)
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			pairs := make(map[string]string)
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				pairs[strings.ToLower(key)] = strings.Trim(val, `"`)
+			}
+			elements = append(elements, pairs)
+		}
+	}
+	return elements
+}
+
+// parseHost parses an address that may carry a port and IPv6 brackets.
+func parseHost(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func splitList(values []string) []string {
+	items := make([]string, 0)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func last(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return items[len(items)-1]
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import "net/netip"
+
+type Config struct {
+	TrustedProxies []netip.Prefix
+}
+
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// Forwarded holds what a trusted proxy told us about the original request.
+type Forwarded struct {
+	// ClientIP is the address of the first untrusted hop
+	ClientIP netip.Addr
+	// Proto, Host and Prefix are empty unless the proxy sent them
+	Proto  string
+	Host   string
+	Prefix string
+}
+
+type forwardedKey struct{}
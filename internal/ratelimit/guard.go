@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"slices"
+	"time"
+)
+
+// guardRetention is how long per-client counts are kept after the last
+// failure, so the admin UI can show recent probing
+const guardRetention = 24 * time.Hour
+
+// guardSweepInterval is how often expired client counts are evicted
+const guardSweepInterval = time.Hour
+
+// NewGuard creates a Guard that bans a client for banDuration after
+// maxFailures failed lookups.
+func NewGuard(maxFailures int, banDuration time.Duration) *Guard {
+	return &Guard{
+		lockout:   NewLockout(maxFailures, banDuration),
+		retention: max(guardRetention, banDuration),
+		clients:   make(map[string]*ClientStats),
+	}
+}
+
+// BannedUntil returns when the ban on client expires, if it is banned.
+func (g *Guard) BannedUntil(client string) (time.Time, bool) {
+	return g.lockout.LockedUntil(client)
+}
+
+// Failure records a failed lookup by client and returns its failure count and
+// whether this failure caused a ban.
+func (g *Guard) Failure(client string) (int, bool) {
+	banned := g.lockout.Failure(client)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	g.sweep(now)
+	stats, ok := g.clients[client]
+	if !ok {
+		stats = &ClientStats{Client: client}
+		g.clients[client] = stats
+	}
+	g.totalInvalid++
+	stats.InvalidCount++
+	stats.LastSeen = now
+	if banned {
+		stats.BannedUntil, _ = g.lockout.LockedUntil(client)
+	}
+	return stats.InvalidCount, banned
+}
+
+// sweep evicts counts of clients not seen within the retention period, at
+// most once per guardSweepInterval, so failures stay cheap no matter how
+// many clients are counted. Must hold g.mu.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < guardSweepInterval {
+		return
+	}
+	g.lastSweep = now
+	for key, stats := range g.clients {
+		if now.Sub(stats.LastSeen) > g.retention {
+			delete(g.clients, key)
+		}
+	}
+}
+
+// Stats returns the recorded counts, most recently seen client first.
+func (g *Guard) Stats() GuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	clients := make([]ClientStats, 0, len(g.clients))
+	for _, stats := range g.clients {
+		// Expired counts are only evicted now and then
+		if now.Sub(stats.LastSeen) > g.retention {
+			continue
+		}
+		clients = append(clients, *stats)
+	}
+	slices.SortFunc(clients, func(a, b ClientStats) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	return GuardStats{TotalInvalid: g.totalInvalid, Clients: clients}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// NewKeyedLimiter creates a limiter allowing limit events per second with the
+// given burst for each key. Keys unused for idleTTL are evicted.
+func NewKeyedLimiter(limit rate.Limit, burst int, idleTTL time.Duration) *KeyedLimiter {
+	return &KeyedLimiter{
+		limit:     limit,
+		burst:     burst,
+		idleTTL:   idleTTL,
+		lastSweep: time.Now(),
+		entries:   make(map[string]*limiterEntry),
+	}
+}
+
+// Allow reports whether an event for key may happen now.
+func (l *KeyedLimiter) Allow(key string) bool {
+	if l.limit == rate.Inf {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.AllowN(now, 1)
+}
+
+// sweep evicts idle entries, at most once per idleTTL. Must hold l.mu.
+func (l *KeyedLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastSeen) >= l.idleTTL {
+			delete(l.entries, key)
+		}
+	}
+}
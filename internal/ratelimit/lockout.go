@@ -0,0 +1,73 @@
+package ratelimit
+
+import "time"
+
+// NewLockout creates a Lockout that locks a key for duration after
+// maxFailures consecutive failures. Failures older than duration are
+// forgotten. A maxFailures of zero disables locking.
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: maxFailures,
+		duration:    duration,
+		lastSweep:   time.Now(),
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// LockedUntil returns when the lock on key expires, if it is locked.
+func (l *Lockout) LockedUntil(key string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[key]
+	if !ok || !entry.lockedUntil.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return entry.lockedUntil, true
+}
+
+// Failure records a failure for key. It returns true if this failure caused
+// the key to become locked.
+func (l *Lockout) Failure(key string) bool {
+	if l.maxFailures <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	entry, ok := l.entries[key]
+	if !ok || now.Sub(entry.lastFailure) > l.duration {
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= l.maxFailures {
+		entry.failures = 0
+		entry.lockedUntil = now.Add(l.duration)
+		return true
+	}
+	return false
+}
+
+// Reset clears the failures recorded for key.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep evicts entries that are neither locked nor counting recent failures,
+// at most once per lockout duration. Must hold l.mu.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.duration {
+		return
+	}
+	l.lastSweep = now
+	for key, entry := range l.entries {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.duration {
+			delete(l.entries, key)
+		}
+	}
+}
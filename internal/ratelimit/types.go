@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter keeps a token bucket per key, e.g. per client IP or username.
+type KeyedLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	idleTTL   time.Duration
+	lastSweep time.Time
+	entries   map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Lockout counts failures per key and locks the key for a fixed duration once
+// the maximum is reached.
+type Lockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	duration    time.Duration
+	lastSweep   time.Time
+	entries     map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Guard bans clients that repeatedly fail a lookup, such as probing link
+// tokens, and keeps per-client counts for reporting.
+type Guard struct {
+	mu           sync.Mutex
+	lockout      *Lockout
+	retention    time.Duration
+	lastSweep    time.Time
+	totalInvalid int64
+	clients      map[string]*ClientStats
+}
+
+type ClientStats struct {
+	Client       string
+	InvalidCount int
+	LastSeen     time.Time
+	BannedUntil  time.Time
+}
+
+type GuardStats struct {
+	TotalInvalid int64
+	Clients      []ClientStats
+}
@@ -0,0 +1,129 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// chunkSize is how much of a file is sent to clamd at a time. It must stay
+// below the StreamMaxLength clamd is configured with.
+const chunkSize = 64 * 1024
+
+var errSend = errors.New("failed to send to clamd")
+
+// NewClamdScanner returns a scanner that streams files to clamd with the
+// INSTREAM command. The address is a host:port, or the path of a unix socket.
+func NewClamdScanner(address string) *ClamdScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return &ClamdScanner{network: network, address: address}
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	if err := c.stream(conn, r); err != nil {
+		// clamd replies with the reason before closing the connection, for
+		// example when the stream exceeds its size limit
+		if errors.Is(err, errSend) {
+			if reply, replyErr := readReply(conn); replyErr == nil && reply != "" {
+				return Result{}, fmt.Errorf("clamd: %s", reply)
+			}
+		}
+		return Result{}, err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case reply == "stream: OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Infected: true, Signature: signature}, nil
+	}
+	return Result{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// stream sends r to clamd in length prefixed chunks, ending with an empty one.
+func (c *ClamdScanner) stream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("%w: %v", errSend, err)
+	}
+	buffer := make([]byte, 4+chunkSize)
+	for {
+		n, err := r.Read(buffer[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buffer, uint32(n))
+			if _, err := conn.Write(buffer[:4+n]); err != nil {
+				return fmt.Errorf("%w: %v", errSend, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("%w: %v", errSend, err)
+	}
+	return nil
+}
+
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("%w: %v", errSend, err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (c *ClamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set clamd deadline: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// readReply reads a null terminated reply, as sent for z prefixed commands.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		return "", fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
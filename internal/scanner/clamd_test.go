@@ -0,0 +1,168 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts a single connection and answers it like clamd. It
+// records the command and, for INSTREAM, the chunks that were sent.
+type fakeClamd struct {
+	listener net.Listener
+	reply    string
+	done     chan struct{}
+	command  string
+	chunks   []int
+	data     []byte
+	// terminated is true if the stream ended with an empty chunk
+	terminated bool
+}
+
+func newFakeClamd(t *testing.T, network, address, reply string) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeClamd{listener: listener, reply: reply, done: make(chan struct{})}
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	defer close(f.done)
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	f.command = strings.TrimRight(command, "\x00")
+	if f.command == "zINSTREAM" {
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				f.terminated = true
+				break
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			f.chunks = append(f.chunks, int(size))
+			f.data = append(f.data, chunk...)
+		}
+	}
+	conn.Write([]byte(f.reply + "\x00"))
+}
+
+func (f *fakeClamd) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake clamd did not finish")
+	}
+}
+
+func scanContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestClamdScanFraming(t *testing.T) {
+	clamd := newFakeClamd(t, "tcp", "127.0.0.1:0", "stream: OK")
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*chunkSize+1000)/16)
+
+	result, err := NewClamdScanner(clamd.listener.Addr().String()).Scan(scanContext(t), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Scan = %v", err)
+	}
+	clamd.wait(t)
+	if result.Infected {
+		t.Errorf("Scan = %+v, want clean", result)
+	}
+	if clamd.command != "zINSTREAM" {
+		t.Errorf("command = %q, want zINSTREAM", clamd.command)
+	}
+	if !clamd.terminated {
+		t.Error("stream not terminated with an empty chunk")
+	}
+	if len(clamd.chunks) < 3 {
+		t.Errorf("sent %d chunks, want at least 3", len(clamd.chunks))
+	}
+	for _, size := range clamd.chunks {
+		if size > chunkSize {
+			t.Errorf("chunk of %d bytes, want at most %d", size, chunkSize)
+		}
+	}
+	if !bytes.Equal(clamd.data, data) {
+		t.Errorf("clamd received %d bytes that differ from the %d sent", len(clamd.data), len(data))
+	}
+}
+
+func TestClamdScanReplies(t *testing.T) {
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		err       bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR", err: true},
+		{reply: "UNKNOWN COMMAND", err: true},
+	}
+	for _, tt := range tests {
+		clamd := newFakeClamd(t, "tcp", "127.0.0.1:0", tt.reply)
+		result, err := NewClamdScanner(clamd.listener.Addr().String()).Scan(scanContext(t), strings.NewReader("data"))
+		clamd.wait(t)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: Scan error = %v, want error %v", tt.reply, err, tt.err)
+		}
+		if result.Infected != tt.infected || result.Signature != tt.signature {
+			t.Errorf("%q: Scan = %+v, want infected %v with %q", tt.reply, result, tt.infected, tt.signature)
+		}
+	}
+}
+
+func TestClamdUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	clamd := newFakeClamd(t, "unix", socket, "PONG")
+	if err := NewClamdScanner(socket).Ping(scanContext(t)); err != nil {
+		t.Fatalf("Ping = %v", err)
+	}
+	clamd.wait(t)
+	if clamd.command != "zPING" {
+		t.Errorf("command = %q, want zPING", clamd.command)
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	if _, err := NewClamdScanner(address).Scan(scanContext(t), strings.NewReader("data")); err == nil {
+		t.Error("Scan succeeded without clamd")
+	}
+}
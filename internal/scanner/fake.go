@@ -0,0 +1,29 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// eicar is the EICAR test file, which scanners report as malware even
+// though it is harmless.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Scan reports files containing the EICAR test string as infected, which is
+// enough to try out quarantine without running clamd.
+func (FakeScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read file: %v", err)
+	}
+	if bytes.Contains(data, []byte(eicar)) {
+		return Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return Result{}, nil
+}
+
+func (FakeScanner) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/frodejac/globster/internal/database/quarantine"
+	"github.com/frodejac/globster/internal/mail"
+	"github.com/frodejac/globster/internal/random"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewScanner returns the scanner selected in the configuration, or nil if
+// scanning is disabled.
+func NewScanner(config *Config) (Scanner, error) {
+	switch config.Type {
+	case TypeNone, "":
+		return nil, nil
+	case TypeClamd:
+		return NewClamdScanner(config.ClamdAddress), nil
+	case TypeFake:
+		return FakeScanner{}, nil
+	}
+	return nil, fmt.Errorf("unknown scanner %q", config.Type)
+}
+
+func NewService(scanner Scanner, store *quarantine.Store, mailer *mail.Mailer, config *Config) *Service {
+	return &Service{scanner: scanner, store: store, mailer: mailer, config: config}
+}
+
+// Enabled reports whether uploads are scanned.
+func (s *Service) Enabled() bool {
+	return s.scanner != nil
+}
+
+func (s *Service) Ping(ctx context.Context) error {
+	if s.scanner == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	return s.scanner.Ping(ctx)
+}
+
+// Scan scans an upload before it is stored, and returns the verdict to record
+// for it. An infected upload is quarantined, admins are notified, and
+// ErrInfected is returned. If the scan itself fails, the upload is accepted
+// with VerdictFailed when failing open, and rejected with ErrScanFailed
+// otherwise. The item describes the upload, and is filled in and stored if
+// the upload is quarantined.
+func (s *Service) Scan(ctx context.Context, src io.ReadSeeker, item *quarantine.Item) (_ string, err error) {
+	if s.scanner == nil {
+		return VerdictUnscanned, nil
+	}
+	ctx, span := tracing.Start(ctx, "scanner.Scan", attribute.String("globster.directory", item.Directory))
+	defer tracing.End(span, &err)
+
+	scanCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	result, err := s.scanner.Scan(scanCtx, src)
+	if err != nil {
+		if s.config.FailOpen {
+			slog.Warn("Failed to scan upload, accepting it unscanned",
+				slog.String("directory", item.Directory),
+				slog.String("filename", item.OriginalName),
+				slog.String("error", err.Error()),
+			)
+			return VerdictFailed, nil
+		}
+		return "", fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	if !result.Infected {
+		return VerdictClean, nil
+	}
+
+	item.Signature = result.Signature
+	if err := s.quarantine(ctx, src, item); err != nil {
+		slog.Error("Failed to quarantine infected upload", "error", err)
+	}
+	s.notify(ctx, item)
+	return "", fmt.Errorf("%w: %s", ErrInfected, result.Signature)
+}
+
+// quarantine copies an infected upload into the quarantine directory, where
+// it can be examined but never downloaded, and records it.
+func (s *Service) quarantine(ctx context.Context, src io.ReadSeeker, item *quarantine.Item) error {
+	quarantineDir := filepath.Join(s.config.BaseDir, QuarantineDir)
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %v", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %v", err)
+	}
+	item.QuarantineName = random.String(32)
+	item.QuarantinedAt = time.Now()
+	quarantinePath := filepath.Join(quarantineDir, item.QuarantineName)
+	out, err := os.OpenFile(quarantinePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine file: %v", err)
+	}
+	h := sha256.New()
+	item.Size, err = io.Copy(io.MultiWriter(out, h), src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(quarantinePath)
+		return fmt.Errorf("failed to write quarantine file: %v", err)
+	}
+	item.Sha256 = hex.EncodeToString(h.Sum(nil))
+	if err := s.store.Create(ctx, item); err != nil {
+		os.Remove(quarantinePath)
+		return err
+	}
+	return nil
+}
+
+// notify tells admins about a quarantined upload. It is always logged, and
+// mailed to the configured addresses when SMTP is set up. The mail is sent in
+// the background, so a slow SMTP server doesn't hold up the upload response.
+func (s *Service) notify(ctx context.Context, item *quarantine.Item) {
+	slog.Error("Quarantined infected upload",
+		slog.String("directory", item.Directory),
+		slog.String("filename", item.OriginalName),
+		slog.String("signature", item.Signature),
+		slog.String("uploaded_by", item.UploadedBy),
+		slog.Bool("upload_link", item.UploadToken != ""),
+	)
+	if !s.mailer.Enabled() {
+		return
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "An upload was found to be infected and has been quarantined.\n\n")
+	fmt.Fprintf(&body, "Directory: ./%s\n", item.Directory)
+	fmt.Fprintf(&body, "File: %s\n", item.OriginalName)
+	fmt.Fprintf(&body, "Signature: %s\n", item.Signature)
+	if item.UploadedBy != "" {
+		fmt.Fprintf(&body, "Uploaded by: %s\n", item.UploadedBy)
+	} else {
+		fmt.Fprintf(&body, "Uploaded through an upload link\n")
+	}
+	if item.Sha256 != "" {
+		fmt.Fprintf(&body, "SHA-256: %s\n", item.Sha256)
+	}
+	for _, to := range s.config.NotifyEmails {
+		s.mailer.SendBackground(ctx, to, "Infected upload quarantined", body.String())
+	}
+}
+
+func (s *Service) List(ctx context.Context) ([]quarantine.Item, error) {
+	return s.store.List(ctx)
+}
+
+// Delete permanently deletes a quarantined upload.
+func (s *Service) Delete(ctx context.Context, id int64) (_ *quarantine.Item, err error) {
+	ctx, span := tracing.Start(ctx, "scanner.Delete")
+	defer tracing.End(span, &err)
+
+	item, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	quarantinePath := filepath.Join(s.config.BaseDir, QuarantineDir, item.QuarantineName)
+	if err := os.Remove(quarantinePath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to delete quarantined file: %v", err)
+	}
+	if err := s.store.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,114 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"github.com/frodejac/globster/internal/database"
+	"github.com/frodejac/globster/internal/database/quarantine"
+	"github.com/frodejac/globster/internal/mail"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingScanner stands in for a scanner that cannot be reached.
+type failingScanner struct{}
+
+func (failingScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func (failingScanner) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func newTestService(t *testing.T, scanner Scanner, failOpen bool) (*Service, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := quarantine.NewQuarantineStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDir := filepath.Join(dir, "uploads")
+	config := &Config{Timeout: time.Second, FailOpen: failOpen, BaseDir: baseDir}
+	return NewService(scanner, store, mail.NewMailer(&mail.Config{}), config), baseDir
+}
+
+func TestScanQuarantinesInfected(t *testing.T) {
+	service, baseDir := newTestService(t, FakeScanner{}, false)
+	ctx := context.Background()
+	content := "prefix " + eicar + " suffix"
+
+	item := &quarantine.Item{Directory: "shared", OriginalName: "eicar.txt", UploadedBy: "admin"}
+	verdict, err := service.Scan(ctx, strings.NewReader(content), item)
+	if !errors.Is(err, ErrInfected) {
+		t.Fatalf("Scan = %q, %v, want ErrInfected", verdict, err)
+	}
+
+	items, err := service.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("%d items quarantined, want 1", len(items))
+	}
+	got := items[0]
+	if got.Directory != "shared" || got.OriginalName != "eicar.txt" || got.UploadedBy != "admin" {
+		t.Errorf("quarantined %+v, want the upload", got)
+	}
+	if got.Signature != "Eicar-Test-Signature" || got.Size != int64(len(content)) || got.Sha256 == "" {
+		t.Errorf("quarantined %+v, want signature, size and checksum recorded", got)
+	}
+	quarantinePath := filepath.Join(baseDir, QuarantineDir, got.QuarantineName)
+	data, err := os.ReadFile(quarantinePath)
+	if err != nil {
+		t.Fatalf("quarantined file: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("quarantined file differs from the upload")
+	}
+
+	if _, err := service.Delete(ctx, got.Id); err != nil {
+		t.Fatalf("Delete = %v", err)
+	}
+	if _, err := os.Stat(quarantinePath); !os.IsNotExist(err) {
+		t.Errorf("quarantined file still exists after delete")
+	}
+	if _, err := service.Delete(ctx, got.Id); !errors.Is(err, quarantine.ErrItemNotFound) {
+		t.Errorf("second Delete = %v, want ErrItemNotFound", err)
+	}
+}
+
+func TestScanVerdicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		scanner  Scanner
+		failOpen bool
+		verdict  string
+		err      error
+	}{
+		{"clean", FakeScanner{}, false, VerdictClean, nil},
+		{"disabled", nil, false, VerdictUnscanned, nil},
+		{"fail closed", failingScanner{}, false, "", ErrScanFailed},
+		{"fail open", failingScanner{}, true, VerdictFailed, nil},
+	}
+	for _, tt := range tests {
+		service, baseDir := newTestService(t, tt.scanner, tt.failOpen)
+		item := &quarantine.Item{Directory: "shared", OriginalName: "a.txt"}
+		verdict, err := service.Scan(context.Background(), strings.NewReader("harmless"), item)
+		if verdict != tt.verdict || !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("%s: Scan = %q, %v, want %q, %v", tt.name, verdict, err, tt.verdict, tt.err)
+		}
+		if _, err := os.Stat(filepath.Join(baseDir, QuarantineDir)); !os.IsNotExist(err) {
+			t.Errorf("%s: quarantine directory created for a file that is not infected", tt.name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"github.com/frodejac/globster/internal/database/quarantine"
+	"github.com/frodejac/globster/internal/mail"
+	"io"
+	"time"
+)
+
+// Scanners that can be configured
+const (
+	TypeNone  = "none"
+	TypeClamd = "clamd"
+	TypeFake  = "fake"
+)
+
+// Verdicts recorded for uploaded files
+const (
+	// VerdictUnscanned is recorded when scanning is disabled
+	VerdictUnscanned = ""
+	VerdictClean     = "clean"
+	// VerdictFailed is recorded when a scan failed and the scanner fails open
+	VerdictFailed = "failed"
+)
+
+// QuarantineDir is the directory below the base directory that infected
+// uploads are kept in. Like the trash, it can never be a valid directory name.
+const QuarantineDir = ".quarantine"
+
+var (
+	ErrInfected   = errors.New("file is infected")
+	ErrScanFailed = errors.New("failed to scan file")
+)
+
+type Config struct {
+	Type string
+	// ClamdAddress is a host:port, or the path of a unix socket
+	ClamdAddress string
+	Timeout      time.Duration
+	// FailOpen accepts uploads that could not be scanned instead of
+	// rejecting them
+	FailOpen bool
+	BaseDir  string
+	// NotifyEmails are sent a message when an upload is quarantined
+	NotifyEmails []string
+}
+
+// Scanner scans file contents for malware.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+	// Ping checks that the scanner is ready to scan
+	Ping(ctx context.Context) error
+}
+
+type Result struct {
+	Infected bool
+	// Signature is the name of the malware found
+	Signature string
+}
+
+// Service scans uploads and quarantines the infected ones.
+type Service struct {
+	// scanner is nil when scanning is disabled
+	scanner Scanner
+	store   *quarantine.Store
+	mailer  *mail.Mailer
+	config  *Config
+}
+
+// ClamdScanner scans files with a clamd daemon.
+type ClamdScanner struct {
+	network string
+	address string
+}
+
+// FakeScanner stands in for clamd in development and tests.
+type FakeScanner struct{}
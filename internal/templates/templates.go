@@ -0,0 +1,53 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// funcs are available in every template. Directory paths contain slashes, so
+// they must go through pathEscape to fit in a single URL path segment.
+var funcs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"joinPath":   path.Join,
+}
+
+// Set holds the parsed HTML templates. Reload swaps in a new set atomically,
+// so requests being rendered keep using the set they started with.
+type Set struct {
+	templates atomic.Pointer[template.Template]
+}
+
+// Load parses every .html file in dir.
+func Load(dir string) (*Set, error) {
+	s := &Set{}
+	if err := s.Reload(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload parses the templates in dir again. On failure the current templates
+// are kept.
+func (s *Set) Reload(dir string) error {
+	templates, err := template.New("").Funcs(funcs).ParseGlob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return fmt.Errorf("failed to parse templates: %v", err)
+	}
+	s.templates.Store(templates)
+	return nil
+}
+
+func (s *Set) ExecuteTemplate(w io.Writer, name string, data any) error {
+	return s.templates.Load().ExecuteTemplate(w, name, data)
+}
+
+// Count returns the number of templates loaded.
+func (s *Set) Count() int {
+	return len(s.templates.Load().Templates())
+}
@@ -0,0 +1,7 @@
+package tracing
+
+type Config struct {
+	Enabled     bool
+	ServiceName string
+	SampleRatio float64
+}
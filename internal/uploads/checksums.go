@@ -0,0 +1,52 @@
+package uploads
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// expectedChecksum returns the SHA-256 checksum in hex that the client sent
+// for a file, or an empty string if it sent none. Clients can send it in a
+// Repr-Digest or Content-Digest header on the part of the file, as in
+// RFC 9530, or in the sha256 form field when uploading a single file.
+func expectedChecksum(r *http.Request, header *multipart.FileHeader, single bool) (string, error) {
+	for _, name := range []string{"Repr-Digest", "Content-Digest"} {
+		if value := header.Header.Get(name); value != "" {
+			return parseDigest(value)
+		}
+	}
+	value := strings.ToLower(strings.TrimSpace(r.FormValue("sha256")))
+	if value == "" {
+		return "", nil
+	}
+	if !single {
+		return "", fmt.Errorf("%w: the sha256 field can only be used when uploading a single file", ErrInvalidChecksum)
+	}
+	if sum, err := hex.DecodeString(value); err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("%w: %q is not a SHA-256 checksum", ErrInvalidChecksum, value)
+	}
+	return value, nil
+}
+
+// parseDigest returns the sha-256 checksum from a digest field in hex. Other
+// algorithms in the field are ignored.
+func parseDigest(value string) (string, error) {
+	for _, member := range strings.Split(value, ",") {
+		algorithm, digest, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || strings.ToLower(algorithm) != "sha-256" {
+			continue
+		}
+		digest = strings.TrimSuffix(strings.TrimPrefix(digest, ":"), ":")
+		sum, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil || len(sum) != sha256.Size {
+			return "", fmt.Errorf("%w: malformed sha-256 digest", ErrInvalidChecksum)
+		}
+		return hex.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("%w: no sha-256 digest in %q", ErrInvalidChecksum, value)
+}
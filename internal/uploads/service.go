@@ -1,23 +1,42 @@
 package uploads
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/frodejac/globster/internal/auth"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/database/quarantine"
+	"github.com/frodejac/globster/internal/files"
+	"github.com/frodejac/globster/internal/inspect"
 	"github.com/frodejac/globster/internal/random"
+	"github.com/frodejac/globster/internal/scanner"
+	"github.com/frodejac/globster/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"hash"
 	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 )
 
-func NewUploadService(store *links.Store, cfg *Config) (*UploadService, error) {
+func NewUploadService(store *links.Store, metadata *metadata.Store, scanner *scanner.Service, cfg *Config) (*UploadService, error) {
 	uploads := &UploadService{
-		store:  store,
-		config: cfg,
+		store:    store,
+		metadata: metadata,
+		scanner:  scanner,
+		config:   cfg,
 	}
+	uploads.SetAllowedTypes(cfg.AllowedExtensions, cfg.AllowedMimeTypes)
 	// Create uploads directory if it doesn't exist
 	if err := os.MkdirAll(cfg.BaseDir, 0755); err != nil {
 		return nil, fmt.Errorf("error creating upload directory: %v", err)
@@ -25,7 +44,7 @@ func NewUploadService(store *links.Store, cfg *Config) (*UploadService, error) {
 	return uploads, nil
 }
 
-func (u *UploadService) CreateLink(directory string, expiresAt time.Time, remainingUses int) error {
+func (u *UploadService) CreateLink(ctx context.Context, directory string, expiresAt time.Time, remainingUses int, password string) error {
 	// Input validation
 	if directory == "" {
 		return fmt.Errorf("directory is required")
@@ -39,61 +58,63 @@ func (u *UploadService) CreateLink(directory string, expiresAt time.Time, remain
 	// Create a new upload token
 	token := random.String(32)
 
-	// Sanitize directory
-	directory = filepath.Clean(directory)
-	directory = filepath.Base(directory)
-	directory = regexp.MustCompile("[^a-zA-Z0-9\\-_]+").ReplaceAllString(directory, "")
+	// Sanitize directory, which may be a subfolder like "partner/incoming"
+	directory = files.SanitizeDir(directory)
 
 	if directory == "" {
 		return fmt.Errorf("invalid directory name")
 	}
 
 	// Create the directory if it doesn't exist
-	dirPath := filepath.Join(u.config.BaseDir, directory)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+	if _, err := files.MakeDir(u.config.BaseDir, directory); err != nil {
+		return err
+	}
+
+	passwordHash, err := auth.HashLinkPassword(password)
+	if err != nil {
+		return err
 	}
 
 	// Insert the upload link into the database
-	if err := u.store.CreateUploadLink(token, directory, expiresAt, remainingUses); err != nil {
+	if err := u.store.CreateUploadLink(ctx, token, directory, expiresAt, remainingUses, passwordHash); err != nil {
 		return fmt.Errorf("failed to create upload link: %v", err)
 	}
 	return nil
 }
 
-func (u *UploadService) DeactivateLink(token string) error {
+func (u *UploadService) DeactivateLink(ctx context.Context, token string) error {
 	// Validate the token
 	if token == "" {
 		return fmt.Errorf("token is required")
 	}
 	// Deactivate the upload link
-	if err := u.store.DeactivateUploadLink(token); err != nil {
+	if err := u.store.DeactivateUploadLink(ctx, token); err != nil {
 		return fmt.Errorf("failed to deactivate upload link: %v", err)
 	}
 	return nil
 }
 
-func (u *UploadService) DeleteLink(token string) error {
+func (u *UploadService) DeleteLink(ctx context.Context, token string) error {
 	// Validate the token
 	if token == "" {
 		return fmt.Errorf("token is required")
 	}
 	// Delete the upload link
-	if err := u.store.DeleteUploadLink(token); err != nil {
+	if err := u.store.DeleteUploadLink(ctx, token); err != nil {
 		return fmt.Errorf("failed to delete upload link: %v", err)
 	}
 	return nil
 }
 
-func (u *UploadService) ValidateToken(token string) (*links.UploadLink, error) {
+func (u *UploadService) ValidateToken(ctx context.Context, token string) (*links.UploadLink, error) {
 	// Validate the token
 	if token == "" {
 		return nil, fmt.Errorf("no token provided")
 	}
 	// Check if the token exists and is not expired
-	link, err := u.store.GetUploadLink(token)
+	link, err := u.store.GetUploadLink(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get upload link: %v", err)
+		return nil, fmt.Errorf("failed to get upload link: %w", err)
 	}
 	if link.RemainingUses <= 0 {
 		return nil, fmt.Errorf("token exhausted")
@@ -109,69 +130,17 @@ func (u *UploadService) Upload(r *http.Request, link *links.UploadLink) error {
 		return fmt.Errorf("failed to parse form: %v", err)
 	}
 
-	// Get the file from the form
-	file, handler, err := r.FormFile("file")
-	if err != nil {
-		return fmt.Errorf("failed to get file from form: %v", err)
-	}
-	defer file.Close()
-
-	if handler.Size <= 0 {
-		return fmt.Errorf("file size is zero")
-	}
-
-	// Check extension
-	if !u.checkFileExtension(handler.Filename) {
-		return fmt.Errorf("file extension not allowed")
-	}
-
-	// Check reported MIME type
-	if mime := handler.Header["Content-Type"]; !u.checkMimeType(mime) {
-		return fmt.Errorf("MIME type not allowed")
-	}
-
-	// Check actual MIME type
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read file: %v", err)
-	}
-	if n > 0 {
-		if mimeType := http.DetectContentType(buffer[:n]); !u.checkMimeType([]string{mimeType}) {
-			return fmt.Errorf("MIME type not allowed")
-		}
-	}
-
 	// Create the directory if it doesn't exist
-	dirPath := filepath.Join(u.config.BaseDir, link.Dir)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
-	}
-	// Sanitize the filename
-	filename := sanitizeFilename(handler.Filename, link.Token)
-
-	// Don't overwrite existing files (highly unlikely, but still)
-	if _, err := os.Stat(filepath.Join(dirPath, filename)); err == nil {
-		return fmt.Errorf("file already exists")
-	}
-
-	outfile, err := os.Create(filepath.Join(dirPath, filename))
-	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+	if _, err := files.MakeDir(u.config.BaseDir, link.Dir); err != nil {
+		return err
 	}
-	defer outfile.Close()
 
-	// Rewind the file reader to the beginning
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to seek file: %v", err)
-	}
-
-	if _, err := io.Copy(outfile, file); err != nil {
-		return fmt.Errorf("failed to save file: %v", err)
+	if err := u.saveFiles(r, link.Dir, link.Token, ""); err != nil {
+		return err
 	}
 
 	// Update the remaining uses and last used time in the database
-	if err := u.store.UpdateUploadLink(link.Token, link.RemainingUses-1, time.Now()); err != nil {
+	if err := u.store.UpdateUploadLink(r.Context(), link.Token, link.RemainingUses-1, time.Now()); err != nil {
 		return fmt.Errorf("failed to update remaining uses: %v", err)
 	}
 
@@ -185,13 +154,13 @@ func (u *UploadService) Upload(r *http.Request, link *links.UploadLink) error {
 // If the upload is successful, it returns nil. Otherwise, it returns an error.
 // The directory parameter specifies the target directory for the upload.
 // The directory must exist on the server and be writable by the application.
-func (u *UploadService) AdminUpload(r *http.Request, directory string) error {
+// The username of the admin is recorded as the uploader of the files.
+func (u *UploadService) AdminUpload(r *http.Request, directory, username string) error {
 	if directory == "" {
 		return fmt.Errorf("directory cannot be empty")
 	}
 	// Check if directory exists
-	dirPath := filepath.Join(u.config.BaseDir, directory)
-	if _, err := os.Stat(dirPath); err != nil {
+	if _, err := files.ResolveDir(u.config.BaseDir, directory); err != nil {
 		return fmt.Errorf("directory does not exist")
 	}
 
@@ -199,68 +168,236 @@ func (u *UploadService) AdminUpload(r *http.Request, directory string) error {
 		return fmt.Errorf("failed to parse form: %v", err)
 	}
 
-	// Get the file from the form
-	file, handler, err := r.FormFile("file")
-	if err != nil {
-		return fmt.Errorf("failed to get file from form: %v", err)
+	return u.saveFiles(r, directory, "", username)
+}
+
+// pendingFile is a file from a multipart form along with the folder it goes in,
+// which is empty unless it was part of a folder upload.
+type pendingFile struct {
+	header      *multipart.FileHeader
+	dir         string
+	name        string
+	contentType string
+	// sha256 is the checksum the client expects, if it sent one
+	sha256      string
+	scanVerdict string
+}
+
+// saveFiles stores every file in the parsed form below directory. Files from
+// a folder upload keep their relative path, so the folder tree is recreated.
+// All files are checked and scanned for malware before any is written, so a
+// single rejected or infected file fails the whole upload. If writing a file
+// fails or its checksum does not match the one the client sent, the files
+// already written are removed again. Files are recorded as uploaded through
+// the link with the given token, or by the admin with the given username.
+func (u *UploadService) saveFiles(r *http.Request, directory, token, username string) error {
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		return fmt.Errorf("failed to get file from form: %v", http.ErrMissingFile)
+	}
+
+	pending := make([]pendingFile, 0, len(headers))
+	for _, header := range headers {
+		dir, name, err := files.SplitUploadPath(clientFilename(header))
+		if err != nil {
+			return err
+		}
+		contentType, err := u.checkFile(header, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		expected, err := expectedChecksum(r, header, len(headers) == 1)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if dir != "" {
+			dir = path.Join(directory, dir)
+		} else {
+			dir = directory
+		}
+		pending = append(pending, pendingFile{header: header, dir: dir, name: name, contentType: contentType, sha256: expected})
 	}
-	defer file.Close()
 
-	if handler.Size <= 0 {
-		return fmt.Errorf("file size is zero")
+	for i := range pending {
+		verdict, err := u.scanFile(r.Context(), pending[i], token, username)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pending[i].name, err)
+		}
+		pending[i].scanVerdict = verdict
+	}
+
+	saved := make([]*metadata.File, 0, len(pending))
+	for _, file := range pending {
+		record, err := u.saveFile(r.Context(), file, token, username)
+		if err != nil {
+			u.removeFiles(r.Context(), saved)
+			return fmt.Errorf("%s: %w", file.name, err)
+		}
+		saved = append(saved, record)
+	}
+	return nil
+}
+
+// removeFiles removes files saved by an upload that failed later on.
+func (u *UploadService) removeFiles(ctx context.Context, saved []*metadata.File) {
+	for _, file := range saved {
+		filePath := filepath.Join(u.config.BaseDir, filepath.FromSlash(file.Directory), file.Filename)
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("Failed to remove uploaded file", "path", filePath, "error", err)
+			continue
+		}
+		if err := u.metadata.Delete(ctx, file.Directory, file.Filename); err != nil {
+			slog.Error("Failed to remove file metadata", "path", filePath, "error", err)
+		}
+	}
+}
+
+// checkFile checks the size, extension and MIME type of an uploaded file,
+// and inspects its content. It returns the MIME type detected from the
+// content.
+func (u *UploadService) checkFile(header *multipart.FileHeader, name string) (string, error) {
+	if header.Size <= 0 {
+		return "", fmt.Errorf("file size is zero")
 	}
 
 	// Check extension
-	if !u.checkFileExtension(handler.Filename) {
-		return fmt.Errorf("file extension not allowed")
+	if !u.checkFileExtension(name) {
+		return "", fmt.Errorf("file extension not allowed")
 	}
 
 	// Check reported MIME type
-	if mime := handler.Header["Content-Type"]; !u.checkMimeType(mime) {
-		return fmt.Errorf("MIME type not allowed")
+	if reported := header.Header["Content-Type"]; !u.checkMimeType(reported) {
+		return "", fmt.Errorf("MIME type not allowed")
 	}
 
-	// Check actual MIME type
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read file: %v", err)
+	// Check actual content, which must match the extension
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
 	}
-	if n > 0 {
-		if mimeType := http.DetectContentType(buffer[:n]); !u.checkMimeType([]string{mimeType}) {
-			return fmt.Errorf("MIME type not allowed")
-		}
+	defer file.Close()
+
+	content, err := inspect.Inspect(file, header.Size, name)
+	if err != nil {
+		return "", err
+	}
+	if !u.checkMimeType([]string{content.MimeType}) {
+		return "", fmt.Errorf("MIME type not allowed")
 	}
+	return content.MimeType, nil
+}
 
-	// Sanitize the filename
-	filename := sanitizeFilename(handler.Filename, random.String(32))
+// scanFile scans an uploaded file for malware, and returns the verdict to
+// record for it. Infected files are quarantined by the scanner.
+func (u *UploadService) scanFile(ctx context.Context, pending pendingFile, token, username string) (string, error) {
+	file, err := pending.header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	return u.scanner.Scan(ctx, file, &quarantine.Item{
+		Directory:    pending.dir,
+		OriginalName: pending.name,
+		Size:         pending.header.Size,
+		UploadToken:  token,
+		UploadedBy:   username,
+	})
+}
+
+// saveFile writes an uploaded file into its directory, creating the
+// directory and any missing parents first, and records its metadata.
+func (u *UploadService) saveFile(ctx context.Context, pending pendingFile, token, username string) (*metadata.File, error) {
+	dirPath, err := files.MakeDir(u.config.BaseDir, pending.dir)
+	if err != nil {
+		return nil, err
+	}
+	// Sanitize the filename, admin uploads get a random token instead
+	nameToken := token
+	if nameToken == "" {
+		nameToken = random.String(32)
+	}
+	filename := sanitizeFilename(pending.name, nameToken)
 
 	// Don't overwrite existing files (highly unlikely, but still)
 	if _, err := os.Stat(filepath.Join(dirPath, filename)); err == nil {
-		return fmt.Errorf("file already exists")
+		return nil, fmt.Errorf("file already exists")
 	}
 
-	outfile, err := os.Create(filepath.Join(dirPath, filename))
+	file, err := pending.header.Open()
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return nil, fmt.Errorf("failed to open file: %v", err)
 	}
-	defer outfile.Close()
+	defer file.Close()
 
-	// Rewind the file reader to the beginning
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to seek file: %v", err)
+	filePath := filepath.Join(dirPath, filename)
+	record, err := u.writeFile(ctx, filePath, file)
+	if err != nil {
+		return nil, err
+	}
+	record.Directory = pending.dir
+	record.Filename = filename
+	record.OriginalName = pending.name
+	record.ContentType = pending.contentType
+	record.ScanVerdict = pending.scanVerdict
+	record.UploadToken = token
+	record.UploadedBy = username
+	record.UploadedAt = time.Now()
+
+	if pending.sha256 != "" && pending.sha256 != record.Sha256 {
+		err = ErrChecksumMismatch
+	} else {
+		// Without metadata the original name would be lost, so fail the upload
+		err = u.metadata.Create(ctx, record)
 	}
+	if err != nil {
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("Failed to remove uploaded file", "path", filePath, "error", err)
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// writeFile copies src into a newly created file at path. It returns the
+// size and checksums of what was written, which are calculated on the way.
+func (u *UploadService) writeFile(ctx context.Context, path string, src io.Reader) (_ *metadata.File, err error) {
+	_, span := tracing.Start(ctx, "uploads.WriteFile")
+	defer tracing.End(span, &err)
 
-	if _, err := io.Copy(outfile, file); err != nil {
-		return fmt.Errorf("failed to save file: %v", err)
+	outfile, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %v", err)
 	}
+	defer outfile.Close()
 
-	return nil
+	sha256Hash := sha256.New()
+	writers := []io.Writer{outfile, sha256Hash}
+	var md5Hash hash.Hash
+	if u.config.ComputeMd5 {
+		md5Hash = md5.New()
+		writers = append(writers, md5Hash)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), src)
+	span.SetAttributes(attribute.Int64("globster.bytes_written", n))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %v", err)
+	}
+	record := &metadata.File{Size: n, Sha256: hex.EncodeToString(sha256Hash.Sum(nil))}
+	if md5Hash != nil {
+		record.Md5 = hex.EncodeToString(md5Hash.Sum(nil))
+	}
+	return record, nil
+}
+
+// SetAllowedTypes replaces the file extensions and MIME types accepted for
+// uploads. It is safe to call while uploads are being handled.
+func (u *UploadService) SetAllowedTypes(extensions, mimeTypes []string) {
+	u.allowed.Store(&allowedTypes{extensions: extensions, mimeTypes: mimeTypes})
 }
 
 func (u *UploadService) checkFileExtension(filename string) bool {
 	ext := filepath.Ext(filename)
-	for _, allowedExt := range u.config.AllowedExtensions {
+	for _, allowedExt := range u.allowed.Load().extensions {
 		if allowedExt == ext {
 			return true
 		}
@@ -268,10 +405,12 @@ func (u *UploadService) checkFileExtension(filename string) bool {
 	return false
 }
 
+// checkMimeType reports whether any of the MIME types is allowed. Allowed
+// types are matched exactly, or by wildcard like image/*.
 func (u *UploadService) checkMimeType(mime []string) bool {
-	for _, allowedMime := range u.config.AllowedMimeTypes {
+	for _, allowedMime := range u.allowed.Load().mimeTypes {
 		for _, m := range mime {
-			if strings.HasPrefix(m, allowedMime) {
+			if inspect.MatchMime(allowedMime, m) {
 				return true
 			}
 		}
@@ -279,13 +418,22 @@ func (u *UploadService) checkMimeType(mime []string) bool {
 	return false
 }
 
+// clientFilename returns the file name as sent by the client. The multipart
+// package reduces it to its base name, which drops the relative path browsers
+// send for folder uploads, so it is read from the part header instead.
+func clientFilename(header *multipart.FileHeader) string {
+	_, params, err := mime.ParseMediaType(header.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return header.Filename
+}
+
 // sanitizeFilename sanitizes the filename by cleaning it up, extracting the base name,
 // removing invalid characters, appending a random prefix, and ensuring it doesn't exceed
 // the maximum length.
 func sanitizeFilename(filename, token string) string {
-	filename = filepath.Clean(filename)
-	filename = filepath.Base(filename)
-	filename = regexp.MustCompile(`[^a-zA-Z0-9\-_.]+`).ReplaceAllString(filename, "")
+	filename = files.SanitizeName(filename)
 	ext := filepath.Ext(filename)
 	base := strings.TrimSuffix(filename, ext)
 	prefix := random.String(16)
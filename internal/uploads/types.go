@@ -1,20 +1,39 @@
 package uploads
 
 import (
+	"errors"
 	"github.com/frodejac/globster/internal/database/links"
+	"github.com/frodejac/globster/internal/database/metadata"
+	"github.com/frodejac/globster/internal/scanner"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrChecksumMismatch = errors.New("file does not match its checksum")
+	ErrInvalidChecksum  = errors.New("invalid checksum")
+)
+
 type Config struct {
 	MaxFileSize       int64
 	BaseDir           string
 	AllowedExtensions []string
 	AllowedMimeTypes  []string
+	ComputeMd5        bool
 }
 
 type UploadService struct {
-	store  *links.Store
-	config *Config
+	store    *links.Store
+	metadata *metadata.Store
+	scanner  *scanner.Service
+	config   *Config
+	// allowed is swapped as a whole when the configuration is reloaded
+	allowed atomic.Pointer[allowedTypes]
+}
+
+type allowedTypes struct {
+	extensions []string
+	mimeTypes  []string
 }
 
 type Directory struct {
@@ -0,0 +1,105 @@
+// Passkey registration and login. Buttons with data-passkey-login and forms
+// with data-passkey-register point at an endpoint prefix with /begin and
+// /finish routes. Errors are shown in the nearest [data-passkey-error].
+(function() {
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+        return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+    }
+
+    function toBase64Url(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        for (let i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    async function post(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json'},
+            body: body === undefined ? undefined : JSON.stringify(body),
+            credentials: 'same-origin'
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.error || 'Request failed');
+        }
+        return data;
+    }
+
+    async function register(prefix, name) {
+        const options = await post(prefix + '/begin');
+        const publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        publicKey.user.id = toBuffer(publicKey.user.id);
+        (publicKey.excludeCredentials || []).forEach(c => c.id = toBuffer(c.id));
+        const credential = await navigator.credentials.create({publicKey: publicKey});
+        return post(prefix + '/finish?name=' + encodeURIComponent(name), {
+            id: credential.id,
+            rawId: toBase64Url(credential.rawId),
+            type: credential.type,
+            response: {
+                clientDataJSON: toBase64Url(credential.response.clientDataJSON),
+                attestationObject: toBase64Url(credential.response.attestationObject),
+                transports: credential.response.getTransports ? credential.response.getTransports() : []
+            }
+        });
+    }
+
+    async function login(prefix) {
+        const options = await post(prefix + '/begin');
+        const publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        (publicKey.allowCredentials || []).forEach(c => c.id = toBuffer(c.id));
+        const credential = await navigator.credentials.get({publicKey: publicKey});
+        return post(prefix + '/finish', {
+            id: credential.id,
+            rawId: toBase64Url(credential.rawId),
+            type: credential.type,
+            response: {
+                clientDataJSON: toBase64Url(credential.response.clientDataJSON),
+                authenticatorData: toBase64Url(credential.response.authenticatorData),
+                signature: toBase64Url(credential.response.signature),
+                userHandle: credential.response.userHandle ? toBase64Url(credential.response.userHandle) : null
+            }
+        });
+    }
+
+    function showError(element, err) {
+        const container = element.closest('div') || document;
+        const target = container.querySelector('[data-passkey-error]') || document.querySelector('[data-passkey-error]');
+        if (target) {
+            target.textContent = err.message || 'Passkey operation failed';
+            target.hidden = false;
+        }
+        console.error('Passkey error: ', err);
+    }
+
+    document.addEventListener('DOMContentLoaded', function() {
+        if (!window.PublicKeyCredential) {
+            document.querySelectorAll('[data-passkey-login], [data-passkey-register]').forEach(el => el.hidden = true);
+            return;
+        }
+        document.querySelectorAll('[data-passkey-login]').forEach(function(button) {
+            button.addEventListener('click', function(event) {
+                event.preventDefault();
+                login(button.getAttribute('data-passkey-login'))
+                    .then(data => window.location.assign(data.redirect))
+                    .catch(err => showError(button, err));
+            });
+        });
+        document.querySelectorAll('form[data-passkey-register]').forEach(function(form) {
+            form.addEventListener('submit', function(event) {
+                event.preventDefault();
+                const name = form.querySelector('input[name="name"]').value;
+                register(form.getAttribute('data-passkey-register'), name)
+                    .then(() => window.location.reload())
+                    .catch(err => showError(form, err));
+            });
+        });
+    });
+})();